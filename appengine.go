@@ -14,7 +14,8 @@ import (
   "net/http";
   "strconv";
   "time";
-  "neural"
+  "neural";
+  "neural/metrics"
 )
 
 func init() {
@@ -100,7 +101,7 @@ func create(w http.ResponseWriter, r *http.Request) {
     return
   }
   // If synapse weights aren't specified, randomize them.
-  if neuralNetwork.Layers[0].Weight.At(0, 0) == 0 {
+  if neuralNetwork.Layers[0].Weights().At(0, 0) == 0 {
     neuralNetwork.RandomizeSynapses()
   }
   var modelId string
@@ -176,14 +177,34 @@ func train(w http.ResponseWriter, r *http.Request) {
       BatchSize: proto.Int32(int32(batchSize)),
       ErrorName: errorName.Enum(),
   }
-  neural.Train(&neuralNetwork, trainingExamples, learningConfiguration)
+  if err = neural.Train(&neuralNetwork, trainingExamples,
+                        learningConfiguration); err != nil {
+    c.Errorf("Could not train neural network with error: %s", err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
   if _, success := putModelIntoCache(
          r.FormValue("modelId"), neuralNetwork, c, w); !success {
     return
   }
-  w.Write([]byte(fmt.Sprintf(
-      "Training error: %v\n",
-      neural.Evaluate(neuralNetwork, trainingExamples))))
+  var trainingLoss float64
+  trainingLoss, err = neural.Loss(neuralNetwork, trainingExamples,
+                                  learningConfiguration)
+  if err != nil {
+    c.Errorf("Could not compute training loss with error: %s", err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  var trainingError float64
+  trainingError, err = neural.Evaluate(neuralNetwork, trainingExamples)
+  if err != nil {
+    c.Errorf("Could not evaluate training examples with error: %s",
+             err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Write([]byte(fmt.Sprintf("Training loss: %v\nTraining error: %v\n",
+                             trainingLoss, trainingError)))
 }
 
 func test(w http.ResponseWriter, r *http.Request) {
@@ -207,10 +228,68 @@ func test(w http.ResponseWriter, r *http.Request) {
                 w) {
     return
   }
+  if err = neuralNetwork.CheckLabels(testingExamples); err != nil {
+    c.Errorf("Could not check testing labels with error: %s", err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
 
   // Test the model.
-  w.Write([]byte(fmt.Sprintf(
-    "Testing error: %v\n", neural.Evaluate(neuralNetwork, testingExamples))))
+  var evaluation float64
+  evaluation, err = neural.Evaluate(neuralNetwork, testingExamples)
+  if err != nil {
+    c.Errorf("Could not evaluate testing examples with error: %s",
+             err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  testingError := fmt.Sprintf("Testing error: %v\n", evaluation)
+  if neuralNetwork.MultiLabel {
+    multiLabelMetrics := neural.EvaluateMultiLabel(neuralNetwork,
+                                                   testingExamples)
+    if r.FormValue("format") == "json" {
+      byteMetrics, err := json.Marshal(multiLabelMetrics)
+      if err != nil {
+        c.Errorf("Could not marshal metrics with error: %s", err.Error())
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+      }
+      w.Write(byteMetrics)
+      return
+    }
+    w.Write([]byte(testingError + fmt.Sprintf("Testing metrics: %+v\n",
+                                              multiLabelMetrics)))
+    return
+  }
+  if neuralNetwork.Classes == 0 {
+    w.Write([]byte(testingError))
+    return
+  }
+  values := make([][]float64, len(testingExamples))
+  weights := make([]float64, len(testingExamples))
+  for i, datapoint := range testingExamples {
+    values[i] = datapoint.Values
+    weights[i] = datapoint.Weight
+  }
+  report, err := metrics.NewReport(
+      neural.Outputs(neuralNetwork, testingExamples), values, weights,
+      neuralNetwork.ClassNames)
+  if err != nil {
+    c.Errorf("Could not compute report with error: %s", err.Error())
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  if r.FormValue("format") == "json" {
+    byteReport, err := report.JSON()
+    if err != nil {
+      c.Errorf("Could not marshal report with error: %s", err.Error())
+      http.Error(w, err.Error(), http.StatusInternalServerError)
+      return
+    }
+    w.Write(byteReport)
+    return
+  }
+  w.Write([]byte(testingError + report.Text()))
 }
 
 func evaluate(w http.ResponseWriter, r *http.Request) {
@@ -234,6 +313,23 @@ func evaluate(w http.ResponseWriter, r *http.Request) {
   }
 
   // Evaluate the example.
+  if neuralNetwork.MultiLabel {
+    labels, probabilities := neuralNetwork.PredictLabels(features)
+    names := make([]string, len(labels))
+    for i, label := range labels {
+      names[i] = neuralNetwork.ClassName(label)
+    }
+    w.Write([]byte(fmt.Sprintf(
+      "Prediction: %v\nProbabilities: %v\n", names, probabilities)))
+    return
+  }
+  if neuralNetwork.Classes > 0 {
+    class, probabilities := neuralNetwork.Predict(features)
+    w.Write([]byte(fmt.Sprintf(
+      "Prediction: %v\nProbabilities: %v\n", neuralNetwork.ClassName(class),
+      probabilities)))
+    return
+  }
   w.Write([]byte(fmt.Sprintf(
     "Evaluation: %v\n", neuralNetwork.Evaluate(features))))
 }
@@ -0,0 +1,172 @@
+// +build ignore
+// Runs the neural package benchmarks and writes their results as JSON, so that
+// throughput and allocations can be compared between commits.
+//
+// Sample usage, from the repository checked out as $GOPATH/src (see README.md):
+// go run benchmark.go -output new.json -baseline old.json
+
+package main
+
+import (
+  "bufio";
+  "bytes";
+  "encoding/json";
+  "flag";
+  "fmt";
+  "io/ioutil";
+  "log";
+  "os";
+  "os/exec";
+  "runtime";
+  "strconv";
+  "strings";
+  "time"
+)
+
+var benchFlag = flag.String(
+  "bench", ".", "Regular expression selecting benchmarks to run.")
+var benchTimeFlag = flag.String(
+  "benchtime", "1s", "Time or iterations (e.g. 100x) to run each benchmark.")
+var countFlag = flag.Int(
+  "count", 1, "Number of times to run each benchmark.")
+var outputFlag = flag.String(
+  "output", "", "File to write JSON results to, or stdout if empty.")
+var baselineFlag = flag.String(
+  "baseline", "",
+  "JSON results from an earlier run to compare against, if any.")
+
+type Result struct {
+  Name string
+  Iterations int
+  NsPerOp float64
+  ExamplesPerSecond float64
+  BytesPerOp float64
+  AllocsPerOp float64
+}
+
+type Results struct {
+  Commit string
+  GoVersion string
+  Time time.Time
+  Benchmarks []Result
+}
+
+// Parse a line of go test -bench output such as
+// "BenchmarkTrain-8  3  412 ns/op  2480 examples/s  96 B/op  2 allocs/op".
+func parseResult(line string) (Result, bool) {
+  fields := strings.Fields(line)
+  if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+    return Result{}, false
+  }
+  var result Result
+  result.Name = fields[0]
+  // Drop the GOMAXPROCS suffix.
+  if i := strings.LastIndex(result.Name, "-"); i > 0 {
+    result.Name = result.Name[:i]
+  }
+  iterations, err := strconv.Atoi(fields[1])
+  if err != nil {
+    return Result{}, false
+  }
+  result.Iterations = iterations
+  for i := 2; i + 1 < len(fields); i += 2 {
+    value, err := strconv.ParseFloat(fields[i], 64)
+    if err != nil {
+      return Result{}, false
+    }
+    switch fields[i + 1] {
+    case "ns/op":
+      result.NsPerOp = value
+    case "examples/s":
+      result.ExamplesPerSecond = value
+    case "B/op":
+      result.BytesPerOp = value
+    case "allocs/op":
+      result.AllocsPerOp = value
+    }
+  }
+  return result, true
+}
+
+func commit() string {
+  out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+  if err != nil {
+    return ""
+  }
+  return strings.TrimSpace(string(out))
+}
+
+// Print the relative change of each benchmark in results from baseline.
+func compare(baseline, results Results) {
+  previous := make(map[string]Result)
+  for _, result := range baseline.Benchmarks {
+    previous[result.Name] = result
+  }
+  name := baseline.Commit
+  if len(name) == 0 {
+    // Outside a git checkout.
+    name = baseline.Time.Format(time.RFC3339)
+  }
+  fmt.Fprintf(os.Stderr, "Compared to %v:\n", name)
+  for _, result := range results.Benchmarks {
+    old, ok := previous[result.Name]
+    if !ok || old.ExamplesPerSecond == 0 {
+      continue
+    }
+    fmt.Fprintf(
+        os.Stderr, "%-28s %+7.1f%% examples/s %8v -> %-8v allocs/op\n",
+        result.Name,
+        100 * (result.ExamplesPerSecond / old.ExamplesPerSecond - 1),
+        old.AllocsPerOp, result.AllocsPerOp)
+  }
+}
+
+func main() {
+  flag.Parse()
+  command := exec.Command(
+      "go", "test", "neural", "-run", "^$", "-bench", *benchFlag,
+      "-benchmem", "-benchtime", *benchTimeFlag,
+      "-count", strconv.Itoa(*countFlag))
+  command.Stderr = os.Stderr
+  out, err := command.Output()
+  if err != nil {
+    os.Stderr.Write(out)
+    log.Fatal(err)
+  }
+
+  results := Results{
+    Commit: commit(),
+    GoVersion: runtime.Version(),
+    Time: time.Now().UTC(),
+  }
+  scanner := bufio.NewScanner(bytes.NewReader(out))
+  for scanner.Scan() {
+    if result, ok := parseResult(scanner.Text()); ok {
+      results.Benchmarks = append(results.Benchmarks, result)
+    }
+  }
+
+  if len(*baselineFlag) > 0 {
+    byteBaseline, err := ioutil.ReadFile(*baselineFlag)
+    if err != nil {
+      log.Fatal(err)
+    }
+    var baseline Results
+    if err := json.Unmarshal(byteBaseline, &baseline); err != nil {
+      log.Fatal(err)
+    }
+    compare(baseline, results)
+  }
+
+  byteResults, err := json.MarshalIndent(results, "", "  ")
+  if err != nil {
+    log.Fatal(err)
+  }
+  if len(*outputFlag) > 0 {
+    if err := ioutil.WriteFile(*outputFlag, byteResults, 0644); err != nil {
+      log.Fatal(err)
+    }
+  } else {
+    os.Stdout.Write(byteResults)
+  }
+}
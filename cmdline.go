@@ -3,8 +3,10 @@
 // training, testing, and serialization. MNIST data is supported as a motivating
 // example.
 //
-// Sample usage:
-// go run cmdline.go -serialized_network_file network.txt -training_file training.txt -testing_file testing.txt
+// Sample usage, from the repository checked out as $GOPATH/src (see README.md):
+// go run cmdline.go -serialized_network network.txt -training_file training.txt -testing_file testing.txt
+// go run cmdline.go -serialized_network network.txt -training_format csv -target_columns label -training_file training.csv -testing_file testing.csv
+// go run cmdline.go -serialized_network network.txt -training_format jsonl -training_file 'training-*.jsonl' -testing_file testing.jsonl -batch_size 100
 
 package main
 
@@ -13,28 +15,83 @@ import (
   "flag";
   "fmt";
   "github.com/golang/protobuf/proto";
-  "github.com/petar/GoMNIST";
   "io/ioutil";
   "log";
+  "math";
   "math/rand";
   "os";
   "runtime/pprof";
+  "sort";
+  "strings";
   "time";
-  "./neural"
+  "neural";
+  "neural/data";
+  "neural/metrics"
 )
 
 var serializedNetworkFlag = flag.String(
   "serialized_network", "", "File with JSON-formatted NetworkConfiguration.")
+var stripLayersFlag = flag.Int(
+  "strip_layers", 0,
+  "Number of layers to remove from the end of -serialized_network before " +
+  "appending the layers of -head_network.")
+var headNetworkFlag = flag.String(
+  "head_network", "",
+  "File with JSON-formatted NetworkConfiguration whose layers are appended " +
+  "to -serialized_network, for transfer learning. Its classes, class names, " +
+  "multi_label and thresholds replace the network's.")
+var freezeLayersFlag = flag.Int(
+  "freeze_layers", 0, "Number of initial layers not to train.")
 var mnistFlag = flag.String(
   "mnist", "",
   "Location of MNIST training / testing data. If non-empty, overrides " +
   "-training_file and -testing_file.")
+var mnistPrefixFlag = flag.String(
+  "mnist_prefix", "",
+  "Prefix of the names of -mnist's files, such as emnist-balanced- for EMNIST.")
+var normalizePixelsFlag = flag.Bool(
+  "normalize_pixels", false, "Scale -mnist's pixels from [0, 255] to [0, 1].")
+var mnistClassesFlag = flag.Int(
+  "mnist_classes", 0,
+  "Number of classes to one-hot encode -mnist's labels into, such as 10 for " +
+  "MNIST or 47 for EMNIST's balanced split. If 0, each label is kept as a " +
+  "single value.")
 var trainingExamplesFlag = flag.String(
   "training_file", "",
-  "File with JSON-formatted array of training examples with values.")
+  "File of training examples in -training_format, by default a " +
+  "JSON-formatted array of examples with values.")
 var testingExamplesFlag = flag.String(
   "testing_file", "",
-  "File with JSON-formatted array of testing examples with values.")
+  "File of testing examples in -testing_format.")
+var trainingFormatFlag = flag.String(
+  "training_format", "json",
+  "Format of -training_file: json, csv, tsv, libsvm or jsonl. CSV and TSV " +
+  "files have a row per example, LIBSVM files a line per sparse example and " +
+  "JSON-lines files a JSON-formatted example per line. JSON-lines training " +
+  "examples are streamed rather than held in memory, and -training_file may " +
+  "be a glob of files to read in a random order.")
+var testingFormatFlag = flag.String(
+  "testing_format", "",
+  "Format of -testing_file, -training_format if empty.")
+var featureColumnsFlag = flag.String(
+  "feature_columns", "",
+  "Comma-separated names or 0-based indices of the feature columns of CSV " +
+  "and TSV files. Every column that isn't a target if empty.")
+var targetColumnsFlag = flag.String(
+  "target_columns", "",
+  "Comma-separated names or 0-based indices of the value columns of CSV and " +
+  "TSV files.")
+var libsvmZeroBasedFlag = flag.Bool(
+  "libsvm_zero_based", false,
+  "Whether feature indices of LIBSVM files start at 0 rather than 1.")
+var shuffleBufferFlag = flag.Int(
+  "shuffle_buffer", 10000,
+  "Number of streamed training examples to shuffle together, or 0 to read " +
+  "them in order.")
+var csvHeaderFlag = flag.String(
+  "csv_header", "detect",
+  "Whether the first row of CSV and TSV files names the columns: detect, " +
+  "true or false.")
 var trainingIterationsFlag = flag.Int(
   "training_iterations", 1000, "Number of training iterations.")
 var learningRateFlag = flag.Float64(
@@ -43,15 +100,106 @@ var weightDecayFlag = flag.Float64(
   "weight_decay", 0, "Weight decay rate.")
 var batchSizeFlag = flag.Int(
   "batch_size", 1, "Size of batches used for training.")
+var accumulationStepsFlag = flag.Int(
+  "accumulation_steps", 1,
+  "Number of batches whose gradients are summed before each update.")
 var errorNameFlag = flag.String(
   "error_name", "QUADRATIC_COST", "Which error function to use for training.")
+var balanceClassesFlag = flag.Bool(
+  "balance_classes", false,
+  "Weight training examples inversely to the frequency of their class.")
+var clipValueFlag = flag.Float64(
+  "clip_value", 0,
+  "Clip each gradient element to this magnitude, 0 to disable.")
+var clipNormFlag = flag.Float64(
+  "clip_norm", 0, "Clip the global gradient norm to this value, 0 to disable.")
+var nonFiniteActionFlag = flag.String(
+  "non_finite_action", "ABORT",
+  "What to do when training produces NaN or infinite values: ABORT, " +
+  "ROLLBACK or IGNORE.")
+var autoencoderFlag = flag.Bool(
+  "autoencoder", false,
+  "Train an autoencoder to reproduce each example's features, ignoring " +
+  "any values.")
+var corruptionFlag = flag.Float64(
+  "corruption", 0,
+  "Probability of zeroing each training input, for denoising autoencoders.")
+var anomalyFileFlag = flag.String(
+  "anomaly_file", "",
+  "File of examples in -training_format to score by reconstruction error " +
+  "after training, flagging those above -anomaly_percentile.")
+var anomalyPercentileFlag = flag.Float64(
+  "anomaly_percentile", 95,
+  "Percentile of -anomaly_file's reconstruction errors above which examples " +
+  "are anomalies.")
+var samplesFlag = flag.Int(
+  "samples", 0,
+  "Number of synthetic examples to generate after training a variational " +
+  "autoencoder.")
+var samplesFileFlag = flag.String(
+  "samples_file", "",
+  "File to write -samples JSON-formatted synthetic examples.")
+var embeddingsFileFlag = flag.String(
+  "embeddings_file", "",
+  "File to write JSON-formatted testing examples whose features are a " +
+  "variational autoencoder's latent means.")
 var serializedNetworkOutFlag = flag.String(
   "serialized_network_out", "",
   "File to write JSON-formatted NetworkConfiguration.")
+var metricsFormatFlag = flag.String(
+  "metrics_format", "text",
+  "Format of the classification report for classifiers: text or json.")
+var backendFlag = flag.String(
+  "backend", "mat64",
+  "Backend for matrix operations: mat64, reference or blocked.")
 var cpuProfileFlag = flag.String(
   "cpu_profile", "", "Write CPU profile to file.")
 
-func ReadDatapointsOrDie(filename string) []neural.Datapoint {
+// Split a comma-separated flag into its elements, none if it is empty.
+func splitList(list string) []string {
+  if len(list) == 0 {
+    return nil
+  }
+  return strings.Split(list, ",")
+}
+
+// Read datapoints from filename in format: json, csv, tsv, libsvm or jsonl.
+func ReadDatapointsOrDie(filename, format string) []neural.Datapoint {
+  if format == "csv" || format == "tsv" {
+    options := data.CSVOptions{
+      Features: splitList(*featureColumnsFlag),
+      Targets: splitList(*targetColumnsFlag),
+    }
+    if format == "tsv" {
+      options.Comma = '\t'
+    }
+    switch *csvHeaderFlag {
+    case "true":
+      options.Header = data.Header
+    case "false":
+      options.Header = data.NoHeader
+    }
+    datapoints, err := data.ReadCSVFile(filename, options)
+    if err != nil {
+      log.Fatal(err)
+    }
+    return datapoints
+  } else if format == "libsvm" {
+    datapoints, _, err := data.ReadLIBSVMFile(
+        filename, data.LIBSVMOptions{ZeroBased: *libsvmZeroBasedFlag})
+    if err != nil {
+      log.Fatal(err)
+    }
+    return datapoints
+  } else if format == "jsonl" {
+    datapoints, err := neural.Collect(data.JSONLinesFile(filename))
+    if err != nil {
+      log.Fatal(err)
+    }
+    return datapoints
+  } else if format != "json" {
+    log.Fatalf("unknown format %q", format)
+  }
   bytes, err := ioutil.ReadFile(filename)
   if err != nil {
     log.Fatal(err)
@@ -64,8 +212,125 @@ func ReadDatapointsOrDie(filename string) []neural.Datapoint {
   return datapoints
 }
 
+// Return a dataset streaming the JSON-lines files matching the glob pattern,
+// shuffled by -shuffle_buffer.
+func StreamDatapointsOrDie(pattern string) neural.Dataset {
+  shards, err := data.JSONLinesFiles(pattern)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if *shuffleBufferFlag == 0 {
+    return shards
+  }
+  return &neural.ShuffleBuffer{Dataset: shards, Size: *shuffleBufferFlag}
+}
+
+// Return Evaluate of neuralNetwork on datapoints.
+func EvaluateOrDie(neuralNetwork *neural.Network,
+                   datapoints []neural.Datapoint) float64 {
+  evaluation, err := neural.Evaluate(*neuralNetwork, datapoints)
+  if err != nil {
+    log.Fatal(err)
+  }
+  return evaluation
+}
+
+// Return Loss of neuralNetwork on datapoints.
+func LossOrDie(neuralNetwork *neural.Network, datapoints []neural.Datapoint,
+               learningConfiguration neural.LearningConfiguration) float64 {
+  loss, err := neural.Loss(*neuralNetwork, datapoints, learningConfiguration)
+  if err != nil {
+    log.Fatal(err)
+  }
+  return loss
+}
+
+// Return a classification report of neuralNetwork on datapoints, formatted
+// according to -metrics_format.
+func ReportOrDie(neuralNetwork *neural.Network,
+                 datapoints []neural.Datapoint) string {
+  values := make([][]float64, len(datapoints))
+  weights := make([]float64, len(datapoints))
+  for i, datapoint := range datapoints {
+    values[i] = datapoint.Values
+    weights[i] = datapoint.Weight
+  }
+  report, err := metrics.NewReport(
+      neural.Outputs(*neuralNetwork, datapoints), values, weights,
+      neuralNetwork.ClassNames)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if *metricsFormatFlag == "json" {
+    bytes, err := report.JSON()
+    if err != nil {
+      log.Fatal(err)
+    }
+    return string(bytes)
+  }
+  return report.Text()
+}
+
+// Return the smallest of values that at least percentile percent of values are
+// less than or equal to, or an error if there are no values or percentile
+// isn't between 0 and 100.
+func Percentile(values []float64, percentile float64) (float64, error) {
+  if len(values) == 0 {
+    return 0, fmt.Errorf("percentile of no values")
+  }
+  if percentile < 0 || percentile > 100 {
+    return 0, fmt.Errorf("percentile %v isn't between 0 and 100", percentile)
+  }
+  sorted := append([]float64(nil), values...)
+  sort.Float64s(sorted)
+  rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+  if rank < 1 {
+    rank = 1
+  }
+  return sorted[rank - 1], nil
+}
+
+// Print the reconstruction error of each example in -anomaly_file, flagging
+// those above -anomaly_percentile.
+func ScoreAnomaliesOrDie(neuralNetwork *neural.Network) {
+  datapoints := ReadDatapointsOrDie(*anomalyFileFlag, *trainingFormatFlag)
+  scores := make([]float64, len(datapoints))
+  for i := range datapoints {
+    scores[i] = neural.DatapointReconstructionError(*neuralNetwork,
+                                                    &datapoints[i])
+  }
+  threshold, err := Percentile(scores, *anomalyPercentileFlag)
+  if err != nil {
+    log.Fatalf("%v: %v", *anomalyFileFlag, err)
+  }
+  anomalies := 0
+  fmt.Printf("Anomaly threshold: %v\nexample\terror\tanomaly\n", threshold)
+  for i, score := range scores {
+    if score > threshold {
+      anomalies++
+    }
+    fmt.Printf("%v\t%v\t%v\n", i, score, score > threshold)
+  }
+  fmt.Printf("Anomalies: %v of %v\n", anomalies, len(scores))
+}
+
+// Write datapoints to filename as a JSON-formatted array.
+func WriteDatapointsOrDie(filename string, datapoints []neural.Datapoint) {
+  bytes, err := json.Marshal(datapoints)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if err = ioutil.WriteFile(filename, bytes, 0666); err != nil {
+    log.Fatal(err)
+  }
+}
+
 func main() {
   flag.Parse()
+  // Fail before training rather than after.
+  if percentile := *anomalyPercentileFlag; percentile < 0 || percentile > 100 {
+    log.Fatalf("-anomaly_percentile %v isn't between 0 and 100", percentile)
+  }
   if *cpuProfileFlag != "" {
     f, err := os.Create(*cpuProfileFlag)
     if err != nil {
@@ -76,37 +341,38 @@ func main() {
   }
 
   rand.Seed(time.Now().UTC().UnixNano())
+  backend, err := neural.NewBackend(*backendFlag)
+  if err != nil {
+    log.Fatal(err)
+  }
+  neural.SetDefaultBackend(backend)
 
   // Set up neural network.
   var neuralNetwork *neural.Network
   var trainingExamples []neural.Datapoint
+  // Streamed training examples, which trainingExamples then doesn't hold.
+  var trainingDataset neural.Dataset
   var testingExamples []neural.Datapoint
   if len(*mnistFlag) > 0 {
-    train, test, err := GoMNIST.Load(*mnistFlag)
+    trainingExamples, testingExamples, err = data.ReadMNIST(
+        *mnistFlag, *mnistPrefixFlag,
+        data.IDXOptions{Normalize: *normalizePixelsFlag,
+                        Classes: *mnistClassesFlag})
     if err != nil {
       log.Fatal(err)
     }
-    for i := 0; i < train.Count(); i++ {
-      var datapoint neural.Datapoint
-      image, label := train.Get(i)
-      datapoint.Values = append(datapoint.Values, float64(label))
-      for _, pixel := range(image) {
-        datapoint.Features = append(datapoint.Features, float64(pixel))
-      }
-      trainingExamples = append(trainingExamples, datapoint)
-    }
-    for i := 0; i < test.Count(); i++ {
-      var datapoint neural.Datapoint
-      image, label := test.Get(i)
-      datapoint.Values = append(datapoint.Values, float64(label))
-      for _, pixel := range(image) {
-        datapoint.Features = append(datapoint.Features, float64(pixel))
-      }
-      testingExamples = append(testingExamples, datapoint)
-    }
   } else {
-    trainingExamples = ReadDatapointsOrDie(*trainingExamplesFlag)
-    testingExamples = ReadDatapointsOrDie(*testingExamplesFlag)
+    if *trainingFormatFlag == "jsonl" {
+      trainingDataset = StreamDatapointsOrDie(*trainingExamplesFlag)
+    } else {
+      trainingExamples = ReadDatapointsOrDie(*trainingExamplesFlag,
+                                             *trainingFormatFlag)
+    }
+    testingFormat := *testingFormatFlag
+    if len(testingFormat) == 0 {
+      testingFormat = *trainingFormatFlag
+    }
+    testingExamples = ReadDatapointsOrDie(*testingExamplesFlag, testingFormat)
   }
   fmt.Printf("Finished loading data!\n")
 
@@ -115,11 +381,30 @@ func main() {
     log.Fatal(err)
   }
   neuralNetwork = new(neural.Network)
-  neuralNetwork.Deserialize(byteNetwork)
+  if err = neuralNetwork.Deserialize(byteNetwork); err != nil {
+    log.Fatal(err)
+  }
   // If synapse weights aren't specified, randomize them.
-  if neuralNetwork.Layers[0].Weight.At(0, 0) == 0 {
+  if neuralNetwork.Layers[0].Weights().At(0, 0) == 0 {
     neuralNetwork.RandomizeSynapses()
   }
+  if len(*headNetworkFlag) > 0 {
+    byteHead, err := ioutil.ReadFile(*headNetworkFlag)
+    if err != nil {
+      log.Fatal(err)
+    }
+    var headConfiguration neural.NetworkConfiguration
+    if err = json.Unmarshal(byteHead, &headConfiguration); err != nil {
+      log.Fatal(err)
+    }
+    if err = neuralNetwork.ReplaceLayers(*stripLayersFlag,
+                                         headConfiguration); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if err = neuralNetwork.Freeze(*freezeLayersFlag); err != nil {
+    log.Fatal(err)
+  }
   fmt.Printf("Finished creating the network!\n")
 
   // Train the model.
@@ -128,15 +413,82 @@ func main() {
       Rate: proto.Float64(*learningRateFlag),
       Decay: proto.Float64(*weightDecayFlag),
       BatchSize: proto.Int32(int32(*batchSizeFlag)),
+      AccumulationSteps: proto.Int32(int32(*accumulationStepsFlag)),
       ErrorName:
           neural.ErrorName(neural.ErrorName_value[*errorNameFlag]).Enum(),
+      BalanceClasses: proto.Bool(*balanceClassesFlag),
+      ClipValue: proto.Float64(*clipValueFlag),
+      ClipNorm: proto.Float64(*clipNormFlag),
+      NonFiniteAction: neural.NonFiniteAction(
+          neural.NonFiniteAction_value[*nonFiniteActionFlag]).Enum(),
+      Autoencoder: proto.Bool(*autoencoderFlag),
+      Corruption: proto.Float64(*corruptionFlag),
+  }
+  if trainingDataset != nil {
+    err = neural.TrainDataset(neuralNetwork, trainingDataset,
+                              learningConfiguration)
+  } else {
+    err = neural.Train(neuralNetwork, trainingExamples, learningConfiguration)
+  }
+  if err != nil {
+    log.Fatal(err)
+  }
+  if err = neuralNetwork.CheckLabels(testingExamples); err != nil {
+    log.Fatal(err)
   }
-  neural.Train(neuralNetwork, trainingExamples, learningConfiguration)
 
   // Test & output model:
-  fmt.Printf("Training error: %v\nTesting error: %v\n",
-             neural.Evaluate(*neuralNetwork, trainingExamples),
-             neural.Evaluate(*neuralNetwork, testingExamples))
+  labeledTestingExamples := testingExamples
+  if *autoencoderFlag {
+    trainingExamples = neural.AutoencoderDatapoints(trainingExamples)
+    testingExamples = neural.AutoencoderDatapoints(testingExamples)
+  }
+  // Streamed training examples are too many to evaluate in memory.
+  streamed := trainingDataset != nil
+  if !streamed {
+    fmt.Printf("Training loss: %v\nTraining error: %v\n",
+               LossOrDie(neuralNetwork, trainingExamples,
+                         learningConfiguration),
+               EvaluateOrDie(neuralNetwork, trainingExamples))
+  }
+  fmt.Printf("Testing error: %v\n",
+             EvaluateOrDie(neuralNetwork, testingExamples))
+  if neuralNetwork.MultiLabel {
+    if !streamed {
+      fmt.Printf("Training metrics: %+v\n",
+                 neural.EvaluateMultiLabel(*neuralNetwork, trainingExamples))
+    }
+    fmt.Printf("Testing metrics: %+v\n",
+               neural.EvaluateMultiLabel(*neuralNetwork, testingExamples))
+  } else if neuralNetwork.Classes > 0 {
+    if !streamed {
+      fmt.Printf("Training accuracy: %v\n",
+                 neural.Accuracy(*neuralNetwork, trainingExamples))
+    }
+    fmt.Printf("Testing accuracy: %v\n",
+               neural.Accuracy(*neuralNetwork, testingExamples))
+    fmt.Printf("Testing report:\n%v\n",
+               ReportOrDie(neuralNetwork, testingExamples))
+  }
+  if len(*anomalyFileFlag) > 0 {
+    ScoreAnomaliesOrDie(neuralNetwork)
+  }
+  if *samplesFlag > 0 {
+    samples := make([]neural.Datapoint, *samplesFlag)
+    for i := range samples {
+      samples[i].Features = neuralNetwork.Sample()
+    }
+    WriteDatapointsOrDie(*samplesFileFlag, samples)
+  }
+  if len(*embeddingsFileFlag) > 0 {
+    embeddings := make([]neural.Datapoint, len(labeledTestingExamples))
+    for i, datapoint := range labeledTestingExamples {
+      embeddings[i] = datapoint
+      embeddings[i].Features, _ = neuralNetwork.Encode(datapoint.Features)
+      embeddings[i].Shape = nil
+    }
+    WriteDatapointsOrDie(*embeddingsFileFlag, embeddings)
+  }
   if len(*serializedNetworkOutFlag) > 0 {
     ioutil.WriteFile(*serializedNetworkOutFlag, neuralNetwork.Serialize(), 0777)
   }
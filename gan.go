@@ -0,0 +1,196 @@
+// +build ignore
+// Trains a generative adversarial network from a generator and a discriminator
+// NetworkConfiguration, writing grids of generated images as PNGs as it goes.
+// MNIST data is supported as a motivating example, with pixels scaled to
+// [0, 1].
+//
+// Sample usage, from the repository checked out as $GOPATH/src (see README.md):
+// go run gan.go -generator generator.txt -discriminator discriminator.txt -mnist data -snapshot_dir snapshots
+
+package main
+
+import (
+  "encoding/json";
+  "flag";
+  "fmt";
+  "github.com/golang/protobuf/proto";
+  "image";
+  "image/color";
+  "image/png";
+  "io/ioutil";
+  "log";
+  "math";
+  "math/rand";
+  "os";
+  "path/filepath";
+  "time";
+  "neural";
+  "neural/data"
+)
+
+var generatorFlag = flag.String(
+  "generator", "",
+  "File with JSON-formatted NetworkConfiguration of the generator, whose " +
+  "inputs are noise.")
+var discriminatorFlag = flag.String(
+  "discriminator", "",
+  "File with JSON-formatted NetworkConfiguration of the discriminator, with " +
+  "a single LOGISTIC output.")
+var mnistFlag = flag.String(
+  "mnist", "",
+  "Location of MNIST training data. If non-empty, overrides -training_file.")
+var mnistPrefixFlag = flag.String(
+  "mnist_prefix", "",
+  "Prefix of the names of -mnist's files, such as emnist-balanced- for EMNIST.")
+var trainingExamplesFlag = flag.String(
+  "training_file", "",
+  "File with JSON-formatted array of real examples, whose values are unused.")
+var trainingIterationsFlag = flag.Int(
+  "training_iterations", 10, "Number of training iterations.")
+var learningRateFlag = flag.Float64(
+  "learning_rate", 0.001, "Speed of training.")
+var batchSizeFlag = flag.Int(
+  "batch_size", 64,
+  "Number of real and of generated examples in each discriminator batch.")
+var discriminatorStepsFlag = flag.Int(
+  "discriminator_steps", 1,
+  "Number of discriminator updates per generator update.")
+var minimaxFlag = flag.Bool(
+  "minimax", false,
+  "Train the generator with the minimax loss instead of the non-saturating " +
+  "loss.")
+var snapshotDirFlag = flag.String(
+  "snapshot_dir", "", "Directory to write PNG grids of generated images to.")
+var snapshotIterationsFlag = flag.Int(
+  "snapshot_iterations", 1, "Number of training iterations between grids.")
+var snapshotSamplesFlag = flag.Int(
+  "snapshot_samples", 64, "Number of generated images in each grid.")
+var imageHeightFlag = flag.Int(
+  "image_height", 28, "Height of each generated image.")
+var imageWidthFlag = flag.Int(
+  "image_width", 28, "Width of each generated image.")
+var serializedGeneratorOutFlag = flag.String(
+  "serialized_generator_out", "",
+  "File to write the generator's JSON-formatted NetworkConfiguration.")
+var serializedDiscriminatorOutFlag = flag.String(
+  "serialized_discriminator_out", "",
+  "File to write the discriminator's JSON-formatted NetworkConfiguration.")
+
+func ReadNetworkOrDie(filename string) *neural.Network {
+  bytes, err := ioutil.ReadFile(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  network := new(neural.Network)
+  if err = network.Deserialize(bytes); err != nil {
+    log.Fatal(err)
+  }
+  // If synapse weights aren't specified, randomize them.
+  if network.Layers[0].Weights().At(0, 0) == 0 {
+    network.RandomizeSynapses()
+  }
+  return network
+}
+
+func ReadDatapointsOrDie(filename string) []neural.Datapoint {
+  bytes, err := ioutil.ReadFile(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  var datapoints []neural.Datapoint
+  if err = json.Unmarshal(bytes, &datapoints); err != nil {
+    log.Fatal(err)
+  }
+  return datapoints
+}
+
+// Write images, each height x width grayscale pixels in [0, 1] in row-major
+// order, to filename as a PNG of a square-ish grid.
+func WriteImageGridOrDie(filename string, images [][]float64,
+                         height, width int) {
+  columns := int(math.Ceil(math.Sqrt(float64(len(images)))))
+  rows := (len(images) + columns - 1) / columns
+  grid := image.NewGray(image.Rect(0, 0, columns * width, rows * height))
+  for i, pixels := range images {
+    top, left := i / columns * height, i % columns * width
+    for j, pixel := range pixels[:height * width] {
+      gray := uint8(255 * math.Max(0, math.Min(1, pixel)) + 0.5)
+      grid.SetGray(left + j % width, top + j / width, color.Gray{gray})
+    }
+  }
+  f, err := os.Create(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+  if err = png.Encode(f, grid); err != nil {
+    log.Fatal(err)
+  }
+}
+
+func main() {
+  flag.Parse()
+  rand.Seed(time.Now().UTC().UnixNano())
+
+  var trainingExamples []neural.Datapoint
+  if len(*mnistFlag) > 0 {
+    var err error
+    trainingExamples, _, err = data.ReadMNIST(
+        *mnistFlag, *mnistPrefixFlag, data.IDXOptions{Normalize: true})
+    if err != nil {
+      log.Fatal(err)
+    }
+  } else {
+    trainingExamples = ReadDatapointsOrDie(*trainingExamplesFlag)
+  }
+  fmt.Printf("Finished loading data!\n")
+
+  generator := ReadNetworkOrDie(*generatorFlag)
+  discriminator := ReadNetworkOrDie(*discriminatorFlag)
+  fmt.Printf("Finished creating the networks!\n")
+
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(int32(*trainingIterationsFlag)),
+      Rate: proto.Float64(*learningRateFlag),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(int32(*batchSizeFlag)),
+      DiscriminatorSteps: proto.Int32(int32(*discriminatorStepsFlag)),
+      Minimax: proto.Bool(*minimaxFlag),
+  }
+  snapshot := func(epoch int) {
+    // Mean discriminator output on real and generated examples.
+    samples := *snapshotSamplesFlag
+    if samples > len(trainingExamples) {
+      samples = len(trainingExamples)
+    }
+    real, generated := 0.0, 0.0
+    for _, datapoint := range trainingExamples[:samples] {
+      real += discriminator.Evaluate(datapoint.Features)[0]
+    }
+    images := neural.Generate(generator, samples)
+    for _, features := range images {
+      generated += discriminator.Evaluate(features)[0]
+    }
+    fmt.Printf("Iteration %v: D(real) %v, D(generated) %v\n", epoch,
+               real / float64(samples), generated / float64(samples))
+    if len(*snapshotDirFlag) > 0 &&
+       (epoch + 1) % *snapshotIterationsFlag == 0 {
+      WriteImageGridOrDie(
+          filepath.Join(*snapshotDirFlag, fmt.Sprintf("%06d.png", epoch)),
+          images, *imageHeightFlag, *imageWidthFlag)
+    }
+  }
+  if err := neural.TrainAdversarial(generator, discriminator, trainingExamples,
+                                    learningConfiguration,
+                                    snapshot); err != nil {
+    log.Fatal(err)
+  }
+
+  if len(*serializedGeneratorOutFlag) > 0 {
+    ioutil.WriteFile(*serializedGeneratorOutFlag, generator.Serialize(), 0777)
+  }
+  if len(*serializedDiscriminatorOutFlag) > 0 {
+    ioutil.WriteFile(*serializedDiscriminatorOutFlag,
+                     discriminator.Serialize(), 0777)
+  }
+}
@@ -5,26 +5,22 @@ import (
   "math"
 )
 
+// Sets y, of x's dimensions, to the activation of each element of x, which may
+// be y itself.
 type ActivationFunction func(x mat64.Matrix, y *mat64.Dense)
 
 func NewActivationFunction(name ActivationName) ActivationFunction {
   switch name {
   case ActivationName_LINEAR:
-    return func(x mat64.Matrix, y *mat64.Dense) { y.Clone(x) }
+    return elementwise(func(v float64) float64 { return v })
   case ActivationName_LOGISTIC:
-    return func(x mat64.Matrix, y *mat64.Dense) {
-      y.Apply(func(r, c int, v float64) float64 {
-        return 1 / (1 + math.Exp(-v))
-      }, x)
-    }
+    return elementwise(func(v float64) float64 {
+      return 1 / (1 + math.Exp(-v))
+    })
   case ActivationName_RELU:
-    return func(x mat64.Matrix, y *mat64.Dense) {
-      y.Apply(func(r, c int, v float64) float64 { return math.Max(0, v) }, x)
-    }
+    return elementwise(func(v float64) float64 { return math.Max(0, v) })
   case ActivationName_TANH:
-    return func(x mat64.Matrix, y *mat64.Dense) {
-      y.Apply(func(r, c int, v float64) float64 { return math.Tanh(v) }, x)
-    }
+    return elementwise(math.Tanh)
   case ActivationName_SOFTMAX:
     return func(x mat64.Matrix, y *mat64.Dense) {
       r, c := x.Dims()
@@ -42,48 +38,46 @@ func NewActivationFunction(name ActivationName) ActivationFunction {
   return nil
 }
 
+// Sets x, of y's dimensions, to the derivative of the activation at each
+// element of y.
 type DActivationFunction func(y mat64.Matrix, x *mat64.Dense)
 
 func NewDActivationFunction(name ActivationName) DActivationFunction {
   switch name {
   case ActivationName_LINEAR:
-    return func(y mat64.Matrix, x *mat64.Dense) {
-      x.Apply(func(r, c int, v float64) float64 { return 1 }, y)
-    }
+    return elementwise(func(v float64) float64 { return 1 })
   case ActivationName_RELU:
-    return func(y mat64.Matrix, x *mat64.Dense) {
-      x.Apply(func(r, c int, v float64) float64 {
-        if v <= 0 {
-         return 0
-        }
-        return 1
-      }, y)
-    }
+    return elementwise(func(v float64) float64 {
+      if v <= 0 {
+       return 0
+      }
+      return 1
+    })
   case ActivationName_LOGISTIC:
-    return func(y mat64.Matrix, x *mat64.Dense) {
-      x.Apply(func(r, c int, v float64) float64 {
-        logistic := 1 / (1 + math.Exp(-v))
-        return logistic * (1 - logistic)
-      }, y)
-    }
+    return elementwise(func(v float64) float64 {
+      logistic := 1 / (1 + math.Exp(-v))
+      return logistic * (1 - logistic)
+    })
   case ActivationName_TANH:
-    return func(y mat64.Matrix, x *mat64.Dense) {
-      x.Apply(func(r, c int, v float64) float64 {
-        tanh := math.Tanh(v)
-        return 1 - tanh * tanh
-      }, y)
-    }
+    return elementwise(func(v float64) float64 {
+      tanh := math.Tanh(v)
+      return 1 - tanh * tanh
+    })
   case ActivationName_SOFTMAX:
+    // Only the diagonal of the softmax Jacobian; Layer applies the full
+    // Jacobian when backpropagating. y is outputs x examples.
+    exp := elementwise(math.Exp)
     return func(y mat64.Matrix, x *mat64.Dense) {
-      // TODO(ariw): Finish this.
-      r, c := y.Dims()
-      for i := 0; i < r; i++ {
+      exp(y, x)
+      r, c := x.Dims()
+      for j := 0; j < c; j++ {
         exp_sum := 0.0
-        for j := 0; j < c; j++ {
-          exp_sum = exp_sum + math.Exp(y.At(i, j))
+        for i := 0; i < r; i++ {
+          exp_sum = exp_sum + x.At(i, j)
         }
-        for j := 0; j < c; j++ {
-          x.Set(i, j, math.Exp(y.At(i, j)) / exp_sum)
+        for i := 0; i < r; i++ {
+          softmax := x.At(i, j) / exp_sum
+          x.Set(i, j, softmax * (1 - softmax))
         }
       }
     }
@@ -91,3 +85,24 @@ func NewDActivationFunction(name ActivationName) DActivationFunction {
   return nil
 }
 
+// Function setting y, of x's dimensions, to f of each element of x, which may
+// be y itself. Unlike an in-place Dense.Apply, it allocates no workspace.
+func elementwise(f func(float64) float64) func(x mat64.Matrix,
+                                              y *mat64.Dense) {
+  return func(x mat64.Matrix, y *mat64.Dense) {
+    rows, cols := x.Dims()
+    dense, isDense := x.(*mat64.Dense)
+    for i := 0; i < rows; i++ {
+      row := y.RawRowView(i)
+      if isDense {
+        for j, v := range dense.RawRowView(i) {
+          row[j] = f(v)
+        }
+        continue
+      }
+      for j := 0; j < cols; j++ {
+        row[j] = f(x.At(i, j))
+      }
+    }
+  }
+}
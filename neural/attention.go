@@ -0,0 +1,299 @@
+package neural
+
+import (
+  "fmt";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "neural/autodiff"
+)
+
+// Variance epsilon of layer normalization.
+const layerNormEpsilon = 1e-5
+
+// Create a sequence layer of layerType, which isn't DENSE, for inputs of shape
+// tokens x features. Configuration of ATTENTION and TRANSFORMER_ENCODER layers
+// is read from attention, which may be nil. weight holds every parameter, as
+// from Weights(), or is nil for zeros.
+func NewSequenceLayer(layerType LayerType, shape []int,
+                      attention *AttentionConfiguration,
+                      weight []float64) *Layer {
+  if len(shape) != 2 {
+    panic(fmt.Sprintf("neural: %v layer needs tokens x features inputs, " +
+                      "not %v", layerType, shape))
+  }
+  heads, feedForward := 1, 4 * shape[1]
+  var causal, maskPadding bool
+  if attention != nil {
+    heads = int(attention.GetHeads())
+    causal = attention.GetCausal()
+    maskPadding = attention.GetMaskPadding()
+    if attention.FeedForward != nil {
+      feedForward = int(attention.GetFeedForward())
+    }
+  }
+  if shape[1] % heads != 0 {
+    panic(fmt.Sprintf("neural: %v heads don't divide %v features", heads,
+                      shape[1]))
+  }
+  blocks := sequenceBlocks(layerType, shape, feedForward)
+  var regularized []bool
+  for _, block := range blocks {
+    for i := 0; i < block.rows * block.cols; i++ {
+      regularized = append(regularized, block.regularized())
+    }
+  }
+  layer := NewLayer(ActivationName_LINEAR, len(regularized), 1, weight)
+  layer.Type = layerType
+  layer.Heads = heads
+  layer.Causal = causal
+  layer.FeedForward = feedForward
+  layer.MaskPadding = maskPadding
+  layer.InputShape = append([]int(nil), shape...)
+  layer.OutputShape = append([]int(nil), shape...)
+  layer.Function = sequenceFunction(layerType, shape, heads, causal,
+                                    maskPadding, blocks)
+  layer.blocks = blocks
+  layer.regularized = regularized
+  return layer
+}
+
+// One named parameter matrix packed into a sequence layer's weights.
+type parameterBlock struct {
+  name string
+  rows, cols int
+  // Initial value of each element, or nil for weights drawn from a normal
+  // distribution with variance 1 / rows.
+  initial func(i, j int) float64
+}
+
+// Whether regularization and constraints apply to the block: only weight
+// matrices, which are drawn at random, and not biases, layer normalization
+// gains and shifts or positional encodings.
+func (self parameterBlock) regularized() bool {
+  return self.initial == nil
+}
+
+func zeros(i, j int) float64 {
+  return 0
+}
+
+func ones(i, j int) float64 {
+  return 1
+}
+
+// Sinusoidal encoding of position i in dimension j of features.
+func sinusoid(features int) func(i, j int) float64 {
+  return func(i, j int) float64 {
+    angle := float64(i) /
+             math.Pow(10000, float64(j - j % 2) / float64(features))
+    if j % 2 == 0 {
+      return math.Sin(angle)
+    }
+    return math.Cos(angle)
+  }
+}
+
+// Layout of the parameters of a sequence layer of layerType for inputs of
+// shape, in order.
+func sequenceBlocks(layerType LayerType, shape []int,
+                    feedForward int) []parameterBlock {
+  tokens, features := shape[0], shape[1]
+  attention := []parameterBlock{
+    {"query", features, features, nil},
+    {"query_bias", 1, features, zeros},
+    {"key", features, features, nil},
+    {"key_bias", 1, features, zeros},
+    {"value", features, features, nil},
+    {"value_bias", 1, features, zeros},
+    {"output", features, features, nil},
+    {"output_bias", 1, features, zeros},
+  }
+  switch layerType {
+  case LayerType_POSITIONAL_ENCODING:
+    return []parameterBlock{
+      {"position", tokens, features, sinusoid(features)},
+    }
+  case LayerType_ATTENTION:
+    return attention
+  case LayerType_TRANSFORMER_ENCODER:
+    blocks := []parameterBlock{
+      {"attention_gain", 1, features, ones},
+      {"attention_shift", 1, features, zeros},
+    }
+    blocks = append(blocks, attention...)
+    return append(blocks, []parameterBlock{
+      {"feed_forward_gain", 1, features, ones},
+      {"feed_forward_shift", 1, features, zeros},
+      {"hidden", features, feedForward, nil},
+      {"hidden_bias", 1, feedForward, zeros},
+      {"projection", feedForward, features, nil},
+      {"projection_bias", 1, features, zeros},
+    }...)
+  }
+  panic(fmt.Sprintf("neural: %v isn't a sequence layer", layerType))
+}
+
+// Initialize each parameter block of a sequence layer.
+func (self* Layer) initializeParameters() {
+  blocks := sequenceBlocks(self.Type, self.InputShape, self.FeedForward)
+  offset := 0
+  for _, block := range blocks {
+    for i := 0; i < block.rows; i++ {
+      for j := 0; j < block.cols; j++ {
+        value := rand.NormFloat64() / math.Sqrt(float64(block.rows))
+        if block.initial != nil {
+          value = block.initial(i, j)
+        }
+        self.weight.Set(offset, 0, value)
+        offset++
+      }
+    }
+  }
+}
+
+// Forward pass of a sequence layer, whose weight is its blocks in one column.
+func sequenceFunction(layerType LayerType, shape []int, heads int,
+                      causal, maskPadding bool,
+                      blocks []parameterBlock) LayerFunction {
+  tokens, features := shape[0], shape[1]
+  return func(tape *autodiff.Tape,
+              input, weight, bias *autodiff.Variable) *autodiff.Variable {
+    parameters := make(map[string]*autodiff.Variable)
+    offset := 0
+    for _, block := range blocks {
+      size := block.rows * block.cols
+      parameters[block.name] = autodiff.Reshape(
+          autodiff.Slice(weight, offset, offset + size, 0, 1), block.rows,
+          block.cols)
+      offset += size
+    }
+    examples, _ := input.Value.Dims()
+    // One token per row.
+    x := autodiff.Reshape(input, examples * tokens, features)
+    // 1 for each feature of a token and 0 for each of padding, if masked.
+    var kept *autodiff.Variable
+    if maskPadding {
+      kept = tape.Variable(keptTokens(x.Value))
+    }
+    var y *autodiff.Variable
+    switch layerType {
+    case LayerType_POSITIONAL_ENCODING:
+      y = autodiff.AddRow(input, autodiff.Reshape(
+          parameters["position"], 1, tokens * features))
+      if kept != nil {
+        y = autodiff.MulElem(y, autodiff.Reshape(kept, examples,
+                                                 tokens * features))
+      }
+      return y
+    case LayerType_ATTENTION:
+      y = selfAttention(tape, x, parameters, tokens, heads, causal, kept)
+    case LayerType_TRANSFORMER_ENCODER:
+      normalized := layerNorm(x, parameters["attention_gain"],
+                              parameters["attention_shift"])
+      x = autodiff.Add(x, selfAttention(tape, normalized, parameters, tokens,
+                                        heads, causal, kept))
+      normalized = layerNorm(x, parameters["feed_forward_gain"],
+                             parameters["feed_forward_shift"])
+      hidden := autodiff.ReLU(autodiff.AddRow(
+          autodiff.MatMul(normalized, parameters["hidden"]),
+          parameters["hidden_bias"]))
+      y = autodiff.Add(x, autodiff.AddRow(
+          autodiff.MatMul(hidden, parameters["projection"]),
+          parameters["projection_bias"]))
+    }
+    if kept != nil {
+      y = autodiff.MulElem(y, kept)
+    }
+    return autodiff.Reshape(y, examples, tokens * features)
+  }
+}
+
+// 1s for the tokens of x, one per row, and 0s for its padding tokens, all of
+// whose features are zero.
+func keptTokens(x *mat64.Dense) *mat64.Dense {
+  rows, cols := x.Dims()
+  kept := mat64.NewDense(rows, cols, nil)
+  for i := 0; i < rows; i++ {
+    for _, v := range x.RawRowView(i) {
+      if v != 0 {
+        for j := 0; j < cols; j++ {
+          kept.Set(i, j, 1)
+        }
+        break
+      }
+    }
+  }
+  return kept
+}
+
+// Multi-head scaled dot-product attention of each sequence of tokens rows of
+// x to itself. Padding tokens, those with 0s in kept, aren't attended to
+// unless kept is nil.
+func selfAttention(tape *autodiff.Tape, x *autodiff.Variable,
+                   parameters map[string]*autodiff.Variable, tokens int,
+                   heads int, causal bool,
+                   kept *autodiff.Variable) *autodiff.Variable {
+  project := func(name string) *autodiff.Variable {
+    return autodiff.AddRow(autodiff.MatMul(x, parameters[name]),
+                           parameters[name + "_bias"])
+  }
+  query, key, value := project("query"), project("key"), project("value")
+  rows, features := x.Value.Dims()
+  size := features / heads
+  scale := 1 / math.Sqrt(float64(size))
+  // Hide tokens by adding -Inf to their scores.
+  mask := func(start int) *autodiff.Variable {
+    if !causal && kept == nil {
+      return nil
+    }
+    maskValue := mat64.NewDense(tokens, tokens, nil)
+    for i := 0; i < tokens; i++ {
+      // Padding tokens still attend to every token, so that their scores stay
+      // finite, and are zeroed later.
+      padding := kept != nil && kept.Value.At(start + i, 0) == 0
+      for j := 0; j < tokens; j++ {
+        if causal && j > i ||
+           !padding && kept != nil && kept.Value.At(start + j, 0) == 0 {
+          maskValue.Set(i, j, math.Inf(-1))
+        }
+      }
+    }
+    return tape.Variable(maskValue)
+  }
+  var causalMask *autodiff.Variable
+  if kept == nil {
+    causalMask = mask(0)
+  }
+  var sequences []*autodiff.Variable
+  for start := 0; start < rows; start += tokens {
+    sequenceMask := causalMask
+    if kept != nil {
+      sequenceMask = mask(start)
+    }
+    var outputs []*autodiff.Variable
+    for h := 0; h < heads; h++ {
+      slice := func(m *autodiff.Variable) *autodiff.Variable {
+        return autodiff.Slice(m, start, start + tokens, h * size,
+                              (h + 1) * size)
+      }
+      scores := autodiff.Scale(scale, autodiff.MatMul(
+          slice(query), autodiff.Transpose(slice(key))))
+      if sequenceMask != nil {
+        scores = autodiff.Add(scores, sequenceMask)
+      }
+      outputs = append(outputs, autodiff.MatMul(autodiff.Softmax(scores),
+                                                slice(value)))
+    }
+    sequences = append(sequences, autodiff.ConcatColumns(outputs...))
+  }
+  return autodiff.AddRow(
+      autodiff.MatMul(autodiff.ConcatRows(sequences...), parameters["output"]),
+      parameters["output_bias"])
+}
+
+// Normalize each row of x, then scale by gain and add shift.
+func layerNorm(x, gain, shift *autodiff.Variable) *autodiff.Variable {
+  return autodiff.AddRow(
+      autodiff.MulRow(autodiff.Normalize(x, layerNormEpsilon), gain), shift)
+}
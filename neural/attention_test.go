@@ -0,0 +1,330 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+const (
+  sequenceTokens = 5
+  sequenceFeatures = 4
+)
+
+// Network of layers over sequences of sequenceTokens tokens with
+// sequenceFeatures features, followed by a LOGISTIC output.
+func createSequenceNetwork(layers ...*neural.LayerConfiguration) *neural.Network {
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(sequenceTokens * sequenceFeatures),
+    InputShape: []int32{sequenceTokens, sequenceFeatures},
+    Layer: append(layers, &neural.LayerConfiguration{
+      Name: neural.ActivationName_LOGISTIC.Enum(),
+      Outputs: proto.Int32(1),
+    }),
+  })
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+func sequenceLayer(layerType neural.LayerType, heads int32,
+                   causal bool) *neural.LayerConfiguration {
+  return &neural.LayerConfiguration{
+    Type: layerType.Enum(),
+    Attention: &neural.AttentionConfiguration{
+      Heads: proto.Int32(heads),
+      Causal: proto.Bool(causal),
+      FeedForward: proto.Int32(6),
+    },
+  }
+}
+
+// Sequences of one-hot tokens labeled by whether the first token appears
+// again later.
+func createSequenceDatapoints(n int) []neural.Datapoint {
+  datapoints := make([]neural.Datapoint, n)
+  for i := range datapoints {
+    tokens := make([]int, sequenceTokens)
+    features := make([]float64, sequenceTokens * sequenceFeatures)
+    for j := range tokens {
+      tokens[j] = rand.Intn(sequenceFeatures)
+      features[j * sequenceFeatures + tokens[j]] = 1
+    }
+    // Balance the classes.
+    repeated := i % 2 == 0
+    for j := 1; j < sequenceTokens; j++ {
+      if tokens[j] == tokens[0] && !repeated {
+        features[j * sequenceFeatures + tokens[j]] = 0
+        tokens[j] = (tokens[0] + 1 + rand.Intn(sequenceFeatures - 1)) %
+                    sequenceFeatures
+        features[j * sequenceFeatures + tokens[j]] = 1
+      }
+    }
+    if repeated {
+      j := 1 + rand.Intn(sequenceTokens - 1)
+      features[j * sequenceFeatures + tokens[j]] = 0
+      tokens[j] = tokens[0]
+      features[j * sequenceFeatures + tokens[j]] = 1
+    }
+    value := 0.0
+    if repeated {
+      value = 1
+    }
+    datapoints[i] = neural.Datapoint{
+      Features: features,
+      Values: []float64{value},
+      Shape: []int{sequenceTokens, sequenceFeatures},
+    }
+  }
+  return datapoints
+}
+
+func TestSequenceGradientCheck(t *testing.T) {
+  testCases := map[string][]*neural.LayerConfiguration{
+    "attention": {
+      sequenceLayer(neural.LayerType_ATTENTION, 2, false),
+    },
+    "causal encoder": {
+      sequenceLayer(neural.LayerType_POSITIONAL_ENCODING, 1, false),
+      sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, true),
+    },
+  }
+  for name, layers := range testCases {
+    rand.Seed(1)
+    neuralNetwork := createSequenceNetwork(layers...)
+    datapoints := createSequenceDatapoints(3)
+    for i := range datapoints {
+      for j := range datapoints[i].Features {
+        datapoints[i].Features[j] += 0.1 * rand.NormFloat64()
+      }
+    }
+    for i, maxError := range neural.GradientCheck(
+        neuralNetwork, datapoints, new(neural.CrossEntropyErrorFunction)) {
+      if !(maxError < 1e-4) {
+        t.Errorf("%v: layer %v relative error %v too large", name, i,
+                 maxError)
+      }
+    }
+  }
+}
+
+func TestCausalAttention(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createSequenceNetwork(
+      sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, true))
+  features := mat64.NewDense(1, sequenceTokens * sequenceFeatures, nil)
+  features.Apply(func(i, j int, v float64) float64 {
+    return rand.NormFloat64()
+  }, features)
+  neuralNetwork.Forward(features)
+  before := append([]float64(nil),
+                   neuralNetwork.Layers[0].Output.RawRowView(0)...)
+  // Change the last token.
+  last := (sequenceTokens - 1) * sequenceFeatures
+  features.Set(0, last, features.At(0, last) + 1)
+  neuralNetwork.Forward(features)
+  after := neuralNetwork.Layers[0].Output.RawRowView(0)
+  for j := 0; j < last; j++ {
+    if before[j] != after[j] {
+      t.Fatalf("output %v of earlier tokens changed from %v to %v", j,
+               before[j], after[j])
+    }
+  }
+  if before[last] == after[last] {
+    t.Errorf("output of last token didn't change")
+  }
+}
+
+func TestTrainTransformer(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createSequenceNetwork(
+      sequenceLayer(neural.LayerType_POSITIONAL_ENCODING, 1, false),
+      sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, false))
+  datapoints := createSequenceDatapoints(400)
+  if err := neural.Train(neuralNetwork, datapoints, neural.LearningConfiguration{
+    Epochs: proto.Int32(30),
+    Rate: proto.Float64(0.005),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }); err != nil {
+    t.Fatal(err)
+  }
+  if accuracy := neural.Accuracy(
+      *neuralNetwork, createSequenceDatapoints(200)); accuracy < 0.8 {
+    t.Errorf("accuracy %v too low", accuracy)
+  }
+}
+
+func TestSequenceRegularization(t *testing.T) {
+  rand.Seed(1)
+  position := sequenceLayer(neural.LayerType_POSITIONAL_ENCODING, 1, false)
+  encoder := sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, false)
+  for _, layer := range []*neural.LayerConfiguration{position, encoder} {
+    layer.L1 = proto.Float64(0.1)
+    layer.L2 = proto.Float64(1)
+    layer.UnitNorm = proto.Bool(true)
+  }
+  neuralNetwork := createSequenceNetwork(position, encoder)
+  if penalty := neuralNetwork.Layers[0].Penalty(1); penalty != 0 {
+    t.Errorf("positional encoding penalty %v, expected 0", penalty)
+  }
+  // Sizes of the encoder's blocks, negative for those not regularized: layer
+  // normalization, attention and feed-forward weights of 6 hidden units.
+  const f = sequenceFeatures
+  blocks := []int{
+    -f, -f,
+    f * f, -f, f * f, -f, f * f, -f, f * f, -f,
+    -f, -f,
+    f * 6, -6, 6 * f, -f,
+  }
+  weight := neuralNetwork.Layers[1].Weight
+  expected := 0.0
+  offset := 0
+  for _, size := range blocks {
+    for i := 0; i < size; i++ {
+      w := weight.At(offset + i, 0)
+      expected += 0.1 * math.Abs(w) + w * w
+    }
+    offset += int(math.Abs(float64(size)))
+  }
+  if penalty := neuralNetwork.Layers[1].Penalty(1);
+     !equalsApprox(penalty, expected, 1e-9) {
+    t.Errorf("encoder penalty %v, expected %v", penalty, expected)
+  }
+
+  // An update without gradients only decays and constrains.
+  before := mat64.DenseCopyOf(neuralNetwork.Layers[0].Weight)
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.1),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+  neuralNetwork.Update(learningConfiguration)
+  if !mat64.Equal(neuralNetwork.Layers[0].Weight, before) {
+    t.Errorf("positional encoding regularized")
+  }
+  for j := 0; j < f; j++ {
+    if gain, shift := weight.At(j, 0), weight.At(f + j, 0);
+       gain != 1 || shift != 0 {
+      t.Errorf("layer normalization gain %v and shift %v changed from 1 and " +
+               "0", gain, shift)
+    }
+  }
+  // Each column of the query weights has unit norm.
+  for j := 0; j < f; j++ {
+    norm := 0.0
+    for i := 0; i < f; i++ {
+      w := weight.At(2 * f + i * f + j, 0)
+      norm += w * w
+    }
+    if !equalsApprox(norm, 1, 1e-9) {
+      t.Errorf("query column %v squared norm %v, expected 1", j, norm)
+    }
+  }
+}
+
+func TestMaskPadding(t *testing.T) {
+  rand.Seed(1)
+  const tokens = 3
+  encoder := sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, false)
+  encoder.Attention.MaskPadding = proto.Bool(true)
+  neuralNetwork := createSequenceNetwork(encoder)
+  // The same encoder for sequences of only the unpadded tokens.
+  var networkConfiguration neural.NetworkConfiguration
+  json.Unmarshal(neuralNetwork.Serialize(), &networkConfiguration)
+  networkConfiguration.Inputs = proto.Int32(tokens * sequenceFeatures)
+  networkConfiguration.InputShape = []int32{tokens, sequenceFeatures}
+  networkConfiguration.Layer = networkConfiguration.Layer[:1]
+  bytes, _ := json.Marshal(networkConfiguration)
+  unpadded := new(neural.Network)
+  if err := unpadded.Deserialize(bytes); err != nil {
+    t.Fatal(err)
+  }
+  features := mat64.NewDense(1, sequenceTokens * sequenceFeatures, nil)
+  for j := 0; j < tokens * sequenceFeatures; j++ {
+    features.Set(0, j, rand.NormFloat64())
+  }
+  neuralNetwork.Forward(features)
+  output := neuralNetwork.Layers[0].Output.RawRowView(0)
+  unpadded.Forward(mat64.NewDense(1, tokens * sequenceFeatures,
+                                  features.RawRowView(0)[:tokens *
+                                                         sequenceFeatures]))
+  expected := unpadded.Layers[0].Output.RawRowView(0)
+  for j, v := range output {
+    if j >= len(expected) && v != 0 {
+      t.Fatalf("output %v of padding is %v, expected 0", j, v)
+    }
+    if j < len(expected) && !equalsApprox(v, expected[j], 1e-12) {
+      t.Fatalf("output %v is %v with padding, %v without", j, v, expected[j])
+    }
+  }
+
+  rand.Seed(1)
+  position := sequenceLayer(neural.LayerType_POSITIONAL_ENCODING, 1, false)
+  position.Attention.MaskPadding = proto.Bool(true)
+  encoder.Attention.Causal = proto.Bool(true)
+  neuralNetwork = createSequenceNetwork(position, encoder)
+  datapoints := createSequenceDatapoints(3)
+  for i := range datapoints {
+    for j := range datapoints[i].Features {
+      datapoints[i].Features[j] += 0.1 * rand.NormFloat64()
+      if j >= tokens * sequenceFeatures {
+        datapoints[i].Features[j] = 0
+      }
+    }
+  }
+  for i, maxError := range neural.GradientCheck(
+      neuralNetwork, datapoints, new(neural.CrossEntropyErrorFunction)) {
+    if !(maxError < 1e-4) {
+      t.Errorf("layer %v relative error %v too large with padding", i,
+               maxError)
+    }
+  }
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if !deserialized.Layers[0].MaskPadding || !deserialized.Layers[1].MaskPadding {
+    t.Errorf("padding masks lost by serialization")
+  }
+}
+
+func TestSerializeSequenceLayers(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createSequenceNetwork(
+      sequenceLayer(neural.LayerType_POSITIONAL_ENCODING, 1, false),
+      sequenceLayer(neural.LayerType_TRANSFORMER_ENCODER, 2, true))
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if layer := deserialized.Layers[1]; layer.Type !=
+     neural.LayerType_TRANSFORMER_ENCODER || layer.Heads != 2 ||
+     !layer.Causal || layer.FeedForward != 6 {
+    t.Errorf("layer configuration changed by serialization: %v %v %v %v",
+             layer.Type, layer.Heads, layer.Causal, layer.FeedForward)
+  }
+  datapoint := createSequenceDatapoints(1)[0]
+  expected := neuralNetwork.Evaluate(datapoint.Features)[0]
+  if output := deserialized.Evaluate(datapoint.Features)[0];
+     output != expected {
+    t.Errorf("output %v changed by serialization from %v", output, expected)
+  }
+  // 3 heads don't divide 4 features.
+  bytes, _ := json.Marshal(neural.NetworkConfiguration{
+    Inputs: proto.Int32(sequenceTokens * sequenceFeatures),
+    InputShape: []int32{sequenceTokens, sequenceFeatures},
+    Layer: []*neural.LayerConfiguration{
+      sequenceLayer(neural.LayerType_ATTENTION, 3, false),
+    },
+  })
+  if err := deserialized.Deserialize(bytes); err == nil {
+    t.Errorf("deserialized attention with 3 heads of 4 features")
+  }
+}
@@ -0,0 +1,423 @@
+// Reverse-mode automatic differentiation of matrix expressions. Operations on
+// Variables are recorded on a Tape, which then computes the gradient of one
+// output with respect to every recorded variable by visiting them in reverse.
+package autodiff
+
+import (
+  "fmt";
+  "github.com/gonum/matrix/mat64";
+  "math"
+)
+
+type Tape struct {
+  variables []*Variable
+}
+
+type Variable struct {
+  Value *mat64.Dense
+  // Gradient of the output passed to Tape.Backward with respect to Value.
+  Gradient *mat64.Dense
+  tape *Tape
+  // Add this variable's gradient's contribution to its operands' gradients.
+  backward func()
+}
+
+func NewTape() *Tape {
+  return new(Tape)
+}
+
+// Record value as an input, whose gradient Backward computes.
+func (self *Tape) Variable(value *mat64.Dense) *Variable {
+  return self.record(value, nil)
+}
+
+// Forget every recorded variable.
+func (self *Tape) Reset() {
+  self.variables = self.variables[:0]
+}
+
+// Compute the gradient of output with respect to every variable, where seed
+// is the gradient of some final scalar with respect to output. nil seeds
+// a 1 x 1 output with 1.
+func (self *Tape) Backward(output *Variable, seed *mat64.Dense) {
+  for _, variable := range self.variables {
+    rows, cols := variable.Value.Dims()
+    variable.Gradient = mat64.NewDense(rows, cols, nil)
+  }
+  if seed == nil {
+    if rows, cols := output.Value.Dims(); rows != 1 || cols != 1 {
+      panic(fmt.Sprintf("autodiff: no seed for %vx%v output", rows, cols))
+    }
+    output.Gradient.Set(0, 0, 1)
+  } else {
+    output.Gradient.Copy(seed)
+  }
+  for i := len(self.variables) - 1; i >= 0; i-- {
+    if self.variables[i].backward != nil {
+      self.variables[i].backward()
+    }
+  }
+}
+
+func (self *Tape) record(value *mat64.Dense, backward func()) *Variable {
+  variable := &Variable{Value: value, tape: self, backward: backward}
+  self.variables = append(self.variables, variable)
+  return variable
+}
+
+// Add g to the variable's gradient.
+func (self *Variable) accumulate(g mat64.Matrix) {
+  self.Gradient.Add(self.Gradient, g)
+}
+
+// a * b.
+func MatMul(a, b *Variable) *Variable {
+  var value mat64.Dense
+  value.Mul(a.Value, b.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    var gradient mat64.Dense
+    gradient.Mul(result.Gradient, b.Value.T())
+    a.accumulate(&gradient)
+    gradient.Reset()
+    gradient.Mul(a.Value.T(), result.Gradient)
+    b.accumulate(&gradient)
+  })
+  return result
+}
+
+// a + b.
+func Add(a, b *Variable) *Variable {
+  var value mat64.Dense
+  value.Add(a.Value, b.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    a.accumulate(result.Gradient)
+    b.accumulate(result.Gradient)
+  })
+  return result
+}
+
+// a - b.
+func Sub(a, b *Variable) *Variable {
+  var value mat64.Dense
+  value.Sub(a.Value, b.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    a.accumulate(result.Gradient)
+    var gradient mat64.Dense
+    gradient.Scale(-1, result.Gradient)
+    b.accumulate(&gradient)
+  })
+  return result
+}
+
+// Add row, 1 x columns, to every row of a.
+func AddRow(a, row *Variable) *Variable {
+  var value mat64.Dense
+  value.Apply(func(r, c int, v float64) float64 {
+    return v + row.Value.At(0, c)
+  }, a.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    a.accumulate(result.Gradient)
+    rows, cols := result.Gradient.Dims()
+    for j := 0; j < cols; j++ {
+      sum := 0.0
+      for i := 0; i < rows; i++ {
+        sum += result.Gradient.At(i, j)
+      }
+      row.Gradient.Set(0, j, row.Gradient.At(0, j) + sum)
+    }
+  })
+  return result
+}
+
+// Elementwise product of a and b.
+func MulElem(a, b *Variable) *Variable {
+  var value mat64.Dense
+  value.MulElem(a.Value, b.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    var gradient mat64.Dense
+    gradient.MulElem(result.Gradient, b.Value)
+    a.accumulate(&gradient)
+    gradient.Reset()
+    gradient.MulElem(result.Gradient, a.Value)
+    b.accumulate(&gradient)
+  })
+  return result
+}
+
+// f * a.
+func Scale(f float64, a *Variable) *Variable {
+  var value mat64.Dense
+  value.Scale(f, a.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    var gradient mat64.Dense
+    gradient.Scale(f, result.Gradient)
+    a.accumulate(&gradient)
+  })
+  return result
+}
+
+// Apply f to each element of a, where df(x, y) is the derivative of f at x
+// given y = f(x).
+func Map(a *Variable, f func(x float64) float64,
+         df func(x, y float64) float64) *Variable {
+  var value mat64.Dense
+  value.Apply(func(r, c int, v float64) float64 { return f(v) }, a.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    var gradient mat64.Dense
+    gradient.Apply(func(r, c int, v float64) float64 {
+      return v * df(a.Value.At(r, c), value.At(r, c))
+    }, result.Gradient)
+    a.accumulate(&gradient)
+  })
+  return result
+}
+
+func Sigmoid(a *Variable) *Variable {
+  return Map(a, func(x float64) float64 { return 1 / (1 + math.Exp(-x)) },
+             func(x, y float64) float64 { return y * (1 - y) })
+}
+
+func Tanh(a *Variable) *Variable {
+  return Map(a, math.Tanh, func(x, y float64) float64 { return 1 - y * y })
+}
+
+func ReLU(a *Variable) *Variable {
+  return Map(a, func(x float64) float64 { return math.Max(0, x) },
+             func(x, y float64) float64 {
+               if x <= 0 {
+                 return 0
+               }
+               return 1
+             })
+}
+
+func Exp(a *Variable) *Variable {
+  return Map(a, math.Exp, func(x, y float64) float64 { return y })
+}
+
+func Log(a *Variable) *Variable {
+  return Map(a, math.Log, func(x, y float64) float64 { return 1 / x })
+}
+
+func Square(a *Variable) *Variable {
+  return Map(a, func(x float64) float64 { return x * x },
+             func(x, y float64) float64 { return 2 * x })
+}
+
+// Softmax of each row of a.
+func Softmax(a *Variable) *Variable {
+  rows, cols := a.Value.Dims()
+  value := mat64.NewDense(rows, cols, nil)
+  for i := 0; i < rows; i++ {
+    // Subtract the maximum for numerical stability.
+    maximum := math.Inf(-1)
+    for j := 0; j < cols; j++ {
+      maximum = math.Max(maximum, a.Value.At(i, j))
+    }
+    sum := 0.0
+    for j := 0; j < cols; j++ {
+      value.Set(i, j, math.Exp(a.Value.At(i, j) - maximum))
+      sum += value.At(i, j)
+    }
+    for j := 0; j < cols; j++ {
+      value.Set(i, j, value.At(i, j) / sum)
+    }
+  }
+  var result *Variable
+  result = a.tape.record(value, func() {
+    for i := 0; i < rows; i++ {
+      dot := 0.0
+      for j := 0; j < cols; j++ {
+        dot += result.Gradient.At(i, j) * value.At(i, j)
+      }
+      for j := 0; j < cols; j++ {
+        a.Gradient.Set(i, j, a.Gradient.At(i, j) + value.At(i, j) *
+                             (result.Gradient.At(i, j) - dot))
+      }
+    }
+  })
+  return result
+}
+
+// Sum of every element of a, 1 x 1.
+func Sum(a *Variable) *Variable {
+  value := mat64.NewDense(1, 1, []float64{mat64.Sum(a.Value)})
+  var result *Variable
+  result = a.tape.record(value, func() {
+    g := result.Gradient.At(0, 0)
+    a.Gradient.Apply(func(r, c int, v float64) float64 {
+      return v + g
+    }, a.Gradient)
+  })
+  return result
+}
+
+// The elements of a in row-major order as a rows x cols matrix.
+func Reshape(a *Variable, rows, cols int) *Variable {
+  aRows, aCols := a.Value.Dims()
+  if rows * cols != aRows * aCols {
+    panic(fmt.Sprintf("autodiff: can't reshape %vx%v to %vx%v", aRows, aCols,
+                      rows, cols))
+  }
+  data := make([]float64, 0, rows * cols)
+  for i := 0; i < aRows; i++ {
+    data = append(data, a.Value.RawRowView(i)...)
+  }
+  var result *Variable
+  result = a.tape.record(mat64.NewDense(rows, cols, data), func() {
+    gradient := make([]float64, 0, rows * cols)
+    for i := 0; i < rows; i++ {
+      gradient = append(gradient, result.Gradient.RawRowView(i)...)
+    }
+    a.accumulate(mat64.NewDense(aRows, aCols, gradient))
+  })
+  return result
+}
+
+// Rows [i, k) and columns [j, l) of a.
+func Slice(a *Variable, i, k, j, l int) *Variable {
+  var value mat64.Dense
+  value.Clone(a.Value.View(i, j, k - i, l - j))
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    gradient := a.Gradient.View(i, j, k - i, l - j).(*mat64.Dense)
+    gradient.Add(gradient, result.Gradient)
+  })
+  return result
+}
+
+// Transpose of a.
+func Transpose(a *Variable) *Variable {
+  var value mat64.Dense
+  value.Clone(a.Value.T())
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    a.accumulate(result.Gradient.T())
+  })
+  return result
+}
+
+// parts side by side, which must have the same number of rows.
+func ConcatColumns(parts ...*Variable) *Variable {
+  rows, cols := parts[0].Value.Dims()
+  for _, part := range parts[1:] {
+    _, partCols := part.Value.Dims()
+    cols += partCols
+  }
+  value := mat64.NewDense(rows, cols, nil)
+  offset := 0
+  for _, part := range parts {
+    _, partCols := part.Value.Dims()
+    value.View(0, offset, rows, partCols).(*mat64.Dense).Copy(part.Value)
+    offset += partCols
+  }
+  var result *Variable
+  result = parts[0].tape.record(value, func() {
+    offset := 0
+    for _, part := range parts {
+      _, partCols := part.Value.Dims()
+      part.accumulate(result.Gradient.View(0, offset, rows, partCols))
+      offset += partCols
+    }
+  })
+  return result
+}
+
+// parts one above the other, which must have the same number of columns.
+func ConcatRows(parts ...*Variable) *Variable {
+  rows, cols := parts[0].Value.Dims()
+  for _, part := range parts[1:] {
+    partRows, _ := part.Value.Dims()
+    rows += partRows
+  }
+  value := mat64.NewDense(rows, cols, nil)
+  offset := 0
+  for _, part := range parts {
+    partRows, _ := part.Value.Dims()
+    value.View(offset, 0, partRows, cols).(*mat64.Dense).Copy(part.Value)
+    offset += partRows
+  }
+  var result *Variable
+  result = parts[0].tape.record(value, func() {
+    offset := 0
+    for _, part := range parts {
+      partRows, _ := part.Value.Dims()
+      part.accumulate(result.Gradient.View(offset, 0, partRows, cols))
+      offset += partRows
+    }
+  })
+  return result
+}
+
+// Multiply every row of a elementwise by row, 1 x columns.
+func MulRow(a, row *Variable) *Variable {
+  var value mat64.Dense
+  value.Apply(func(r, c int, v float64) float64 {
+    return v * row.Value.At(0, c)
+  }, a.Value)
+  var result *Variable
+  result = a.tape.record(&value, func() {
+    var gradient mat64.Dense
+    gradient.Apply(func(r, c int, v float64) float64 {
+      return v * row.Value.At(0, c)
+    }, result.Gradient)
+    a.accumulate(&gradient)
+    rows, cols := result.Gradient.Dims()
+    for j := 0; j < cols; j++ {
+      sum := 0.0
+      for i := 0; i < rows; i++ {
+        sum += result.Gradient.At(i, j) * a.Value.At(i, j)
+      }
+      row.Gradient.Set(0, j, row.Gradient.At(0, j) + sum)
+    }
+  })
+  return result
+}
+
+// Shift and scale each row of a to zero mean and unit variance, adding epsilon
+// to the variance.
+func Normalize(a *Variable, epsilon float64) *Variable {
+  rows, cols := a.Value.Dims()
+  value := mat64.NewDense(rows, cols, nil)
+  deviations := make([]float64, rows)
+  for i := 0; i < rows; i++ {
+    row := a.Value.RawRowView(i)
+    mean := 0.0
+    for _, v := range row {
+      mean += v / float64(cols)
+    }
+    variance := 0.0
+    for _, v := range row {
+      variance += (v - mean) * (v - mean) / float64(cols)
+    }
+    deviations[i] = math.Sqrt(variance + epsilon)
+    for j, v := range row {
+      value.Set(i, j, (v - mean) / deviations[i])
+    }
+  }
+  var result *Variable
+  result = a.tape.record(value, func() {
+    // dx = (dy - mean(dy) - y * mean(dy * y)) / deviation.
+    for i := 0; i < rows; i++ {
+      gradient, y := result.Gradient.RawRowView(i), value.RawRowView(i)
+      meanGradient, meanProduct := 0.0, 0.0
+      for j := range gradient {
+        meanGradient += gradient[j] / float64(cols)
+        meanProduct += gradient[j] * y[j] / float64(cols)
+      }
+      aGradient := a.Gradient.RawRowView(i)
+      for j := range gradient {
+        aGradient[j] += (gradient[j] - meanGradient - y[j] * meanProduct) /
+                        deviations[i]
+      }
+    }
+  })
+  return result
+}
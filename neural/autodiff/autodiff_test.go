@@ -0,0 +1,160 @@
+package autodiff_test
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "testing"
+  "neural/autodiff";
+)
+
+func randomDense(rows, cols int) *mat64.Dense {
+  m := mat64.NewDense(rows, cols, nil)
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      m.Set(i, j, rand.NormFloat64())
+    }
+  }
+  return m
+}
+
+// Check the gradient of a weighted sum of f's output with respect to each of
+// inputs against central finite differences.
+func checkGradient(t *testing.T, name string, inputs []*mat64.Dense,
+                   f func(inputs []*autodiff.Variable) *autodiff.Variable) {
+  tape := autodiff.NewTape()
+  var weights *mat64.Dense
+  cost := func() (float64, []*autodiff.Variable) {
+    tape.Reset()
+    variables := make([]*autodiff.Variable, len(inputs))
+    for i, input := range inputs {
+      variables[i] = tape.Variable(input)
+    }
+    output := f(variables)
+    if weights == nil {
+      rows, cols := output.Value.Dims()
+      weights = randomDense(rows, cols)
+    }
+    sum := autodiff.Sum(autodiff.MulElem(output, tape.Variable(weights)))
+    return sum.Value.At(0, 0), append(variables, sum)
+  }
+  _, variables := cost()
+  sum := variables[len(variables) - 1]
+  tape.Backward(sum, nil)
+  const step = 1e-6
+  for v, input := range inputs {
+    gradient := variables[v].Gradient
+    rows, cols := input.Dims()
+    for i := 0; i < rows; i++ {
+      for j := 0; j < cols; j++ {
+        x := input.At(i, j)
+        input.Set(i, j, x + step)
+        plus, _ := cost()
+        input.Set(i, j, x - step)
+        minus, _ := cost()
+        input.Set(i, j, x)
+        expected := (plus - minus) / (2 * step)
+        if math.Abs(gradient.At(i, j) - expected) >
+           1e-5 * math.Max(1, math.Abs(expected)) {
+          t.Errorf("%v: input %v gradient at (%v, %v) %v, expected %v", name,
+                   v, i, j, gradient.At(i, j), expected)
+        }
+      }
+    }
+  }
+}
+
+func TestGradients(t *testing.T) {
+  rand.Seed(1)
+  unary := map[string]func(*autodiff.Variable) *autodiff.Variable{
+      "Sigmoid": autodiff.Sigmoid,
+      "Tanh": autodiff.Tanh,
+      "ReLU": autodiff.ReLU,
+      "Exp": autodiff.Exp,
+      "Square": autodiff.Square,
+      "Softmax": autodiff.Softmax,
+      "Scale": func(a *autodiff.Variable) *autodiff.Variable {
+        return autodiff.Scale(-2.5, a)
+      },
+  }
+  for name, f := range unary {
+    f := f
+    checkGradient(t, name, []*mat64.Dense{randomDense(3, 4)},
+                  func(v []*autodiff.Variable) *autodiff.Variable {
+                    return f(v[0])
+                  })
+  }
+  checkGradient(t, "Log", []*mat64.Dense{mat64.NewDense(1, 3, []float64{
+                    0.5, 1, 3})},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.Log(v[0])
+                })
+  binary := map[string]func(a, b *autodiff.Variable) *autodiff.Variable{
+      "Add": autodiff.Add,
+      "Sub": autodiff.Sub,
+      "MulElem": autodiff.MulElem,
+  }
+  for name, f := range binary {
+    f := f
+    checkGradient(t, name, []*mat64.Dense{randomDense(3, 4), randomDense(3, 4)},
+                  func(v []*autodiff.Variable) *autodiff.Variable {
+                    return f(v[0], v[1])
+                  })
+  }
+  checkGradient(t, "MatMul", []*mat64.Dense{randomDense(3, 4),
+                                            randomDense(4, 2)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.MatMul(v[0], v[1])
+                })
+  checkGradient(t, "AddRow", []*mat64.Dense{randomDense(3, 4),
+                                            randomDense(1, 4)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.AddRow(v[0], v[1])
+                })
+  checkGradient(t, "Reshape", []*mat64.Dense{randomDense(3, 4)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.Reshape(v[0], 2, 6)
+                })
+  checkGradient(t, "Slice", []*mat64.Dense{randomDense(4, 5)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.Slice(v[0], 1, 3, 2, 5)
+                })
+  checkGradient(t, "Transpose", []*mat64.Dense{randomDense(3, 4)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.Transpose(v[0])
+                })
+  checkGradient(t, "ConcatColumns", []*mat64.Dense{randomDense(3, 2),
+                                                   randomDense(3, 4)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.ConcatColumns(v[0], v[1], v[0])
+                })
+  checkGradient(t, "ConcatRows", []*mat64.Dense{randomDense(2, 3),
+                                                randomDense(4, 3)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.ConcatRows(v[0], v[1])
+                })
+  checkGradient(t, "MulRow", []*mat64.Dense{randomDense(3, 4),
+                                            randomDense(1, 4)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.MulRow(v[0], v[1])
+                })
+  checkGradient(t, "Normalize", []*mat64.Dense{randomDense(3, 5)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.Normalize(v[0], 1e-5)
+                })
+  // A variable used twice accumulates both contributions.
+  checkGradient(t, "Reuse", []*mat64.Dense{randomDense(3, 3)},
+                func(v []*autodiff.Variable) *autodiff.Variable {
+                  return autodiff.MatMul(v[0], autodiff.Tanh(v[0]))
+                })
+}
+
+func TestBackwardRequiresSeed(t *testing.T) {
+  defer func() {
+    if recover() == nil {
+      t.Errorf("Backward of a 2x2 output without a seed didn't panic")
+    }
+  }()
+  tape := autodiff.NewTape()
+  tape.Backward(tape.Variable(randomDense(2, 2)), nil)
+}
@@ -0,0 +1,56 @@
+package neural
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "math/rand"
+)
+
+// Return datapoints with their features as values, replacing any labels, for
+// training autoencoders.
+func AutoencoderDatapoints(datapoints []Datapoint) []Datapoint {
+  encoded := make([]Datapoint, len(datapoints))
+  for i, datapoint := range datapoints {
+    encoded[i] = datapoint
+    encoded[i].Values = datapoint.Features
+  }
+  return encoded
+}
+
+// Return the mean squared error between features and the network's
+// reconstruction of them.
+func ReconstructionError(neuralNetwork Network, features []float64) float64 {
+  return DatapointReconstructionError(neuralNetwork,
+                                      &Datapoint{Features: features})
+}
+
+// Like ReconstructionError, but for the features of datapoint, which may be
+// sparse.
+func DatapointReconstructionError(neuralNetwork Network,
+                                  datapoint *Datapoint) float64 {
+  output := neuralNetwork.EvaluateDatapoint(datapoint)
+  features := datapoint.Features
+  if datapoint.Indices != nil {
+    features = make([]float64, len(output))
+    for i, index := range datapoint.Indices {
+      features[index] = datapoint.Features[i]
+    }
+  }
+  squareError := 0.0
+  for i, feature := range features {
+    squareError += (output[i] - feature) * (output[i] - feature)
+  }
+  return squareError / float64(len(features))
+}
+
+// Zero each element of features with probability corruption.
+func corrupt(features *mat64.Dense, corruption float64) {
+  rows, _ := features.Dims()
+  for i := 0; i < rows; i++ {
+    row := features.RawRowView(i)
+    for j := range row {
+      if rand.Float64() < corruption {
+        row[j] = 0
+      }
+    }
+  }
+}
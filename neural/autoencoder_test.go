@@ -0,0 +1,154 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+// Network encoding 6 features into 2 with a decoder tied to the encoder.
+func createTiedAutoencoder() *neural.Network {
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(6),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LINEAR.Enum(),
+        Outputs: proto.Int32(2),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LINEAR.Enum(),
+        Outputs: proto.Int32(6),
+        TiedTo: proto.Int32(0),
+      },
+    },
+  })
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+// Datapoints without values whose 6 features are a linear function of 2.
+func createLowRankDatapoints(n int) []neural.Datapoint {
+  datapoints := make([]neural.Datapoint, n)
+  for i := range datapoints {
+    a, b := rand.NormFloat64(), rand.NormFloat64()
+    datapoints[i].Features = []float64{a, b, a + b, a - b, 0.5 * a, -b}
+  }
+  return datapoints
+}
+
+func TestTiedWeights(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createTiedAutoencoder()
+  datapoints := neural.AutoencoderDatapoints(createLowRankDatapoints(5))
+  neuralNetwork.Evaluate(datapoints[0].Features)
+  encoder, decoder := neuralNetwork.Layers[0], neuralNetwork.Layers[1]
+  for i := 0; i < 6; i++ {
+    for j := 0; j < 2; j++ {
+      if decoder.Weight.At(j, i) != encoder.Weight.At(i, j) {
+        t.Fatalf("decoder weight (%v, %v) isn't the encoder's transposed", j,
+                 i)
+      }
+    }
+  }
+  maxErrors := neural.GradientCheck(
+      neuralNetwork, datapoints, new(neural.QuadraticErrorFunction))
+  if !(maxErrors[0] < 1e-4) {
+    t.Errorf("encoder relative error %v too large", maxErrors[0])
+  }
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if deserialized.Layers[1].Tied != deserialized.Layers[0] {
+    t.Errorf("tied weights lost by serialization")
+  }
+
+  var networkConfiguration neural.NetworkConfiguration
+  json.Unmarshal(neuralNetwork.Serialize(), &networkConfiguration)
+  networkConfiguration.Layer[1].Outputs = proto.Int32(5)
+  bytes, _ := json.Marshal(networkConfiguration)
+  if err := deserialized.Deserialize(bytes); err == nil {
+    t.Errorf("deserialized decoder of the wrong size tied to encoder")
+  }
+}
+
+func TestTrainAutoencoder(t *testing.T) {
+  for _, corruption := range []float64{0, 0.1} {
+    rand.Seed(1)
+    neuralNetwork := createTiedAutoencoder()
+    datapoints := createLowRankDatapoints(200)
+    learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(50),
+      Rate: proto.Float64(0.005),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(10),
+      ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+      Autoencoder: proto.Bool(true),
+      Corruption: proto.Float64(corruption),
+    }
+    initialLoss, err := neural.Loss(*neuralNetwork, datapoints,
+                                    learningConfiguration)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if err := neural.Train(
+        neuralNetwork, datapoints, learningConfiguration); err != nil {
+      t.Fatal(err)
+    }
+    loss, err := neural.Loss(*neuralNetwork, datapoints, learningConfiguration)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if loss > 0.1 * initialLoss {
+      t.Errorf("corruption %v: loss %v didn't improve enough on %v",
+               corruption, loss, initialLoss)
+    }
+    // Points off the training subspace reconstruct worse than points on it.
+    normal, anomaly := 0.0, 0.0
+    for _, datapoint := range createLowRankDatapoints(50) {
+      normal += neural.ReconstructionError(*neuralNetwork, datapoint.Features)
+      features := make([]float64, 6)
+      for j := range features {
+        features[j] = 2 * rand.NormFloat64()
+      }
+      anomaly += neural.ReconstructionError(*neuralNetwork, features)
+    }
+    if anomaly <= 2 * normal {
+      t.Errorf("corruption %v: anomaly error %v isn't well above normal " +
+               "error %v", corruption, anomaly, normal)
+    }
+  }
+}
+
+func TestAutoencoderDatapoints(t *testing.T) {
+  datapoints := neural.AutoencoderDatapoints([]neural.Datapoint{
+    {Features: []float64{1, 2}},
+    {Features: []float64{3, 4}, Values: []float64{5}},
+  })
+  if !mat64.Equal(mat64.NewDense(1, 2, datapoints[0].Values),
+                  mat64.NewDense(1, 2, []float64{1, 2})) {
+    t.Errorf("values %v aren't the features", datapoints[0].Values)
+  }
+  if len(datapoints[1].Values) != 2 {
+    t.Errorf("label %v wasn't replaced by the features",
+             datapoints[1].Values)
+  }
+}
+
+func TestDatapointReconstructionError(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createTiedAutoencoder()
+  features := []float64{0, 1.5, 0, 0, -2, 0}
+  expected := neural.ReconstructionError(*neuralNetwork, features)
+  sparse := neural.Datapoint{Indices: []int{1, 4},
+                             Features: []float64{1.5, -2}}
+  if reconstructionError := neural.DatapointReconstructionError(
+      *neuralNetwork, &sparse); !equalsApprox(reconstructionError, expected,
+                                              1e-12) {
+    t.Errorf("sparse reconstruction error %v, expected %v",
+             reconstructionError, expected)
+  }
+}
@@ -0,0 +1,282 @@
+package neural
+
+import (
+  "fmt";
+  "github.com/gonum/matrix/mat64";
+  "runtime";
+  "sync"
+)
+
+// Matrix operations that layers perform through a Backend, so that
+// implementations can be swapped and their numerics compared. Receivers are
+// already sized.
+type Backend interface {
+  // Set c to a * b.
+  Mul(c, a, b *mat64.Dense)
+  // Set c to the elementwise product of a and b.
+  MulElem(c, a, b *mat64.Dense)
+  // Float32 versions of the above.
+  Mul32(c, a, b *Dense32)
+  MulElem32(c, a, b *Dense32)
+}
+
+// Backend used by layers without their own.
+var defaultBackend Backend = new(Mat64Backend)
+
+func SetDefaultBackend(backend Backend) {
+  defaultBackend = backend
+}
+
+// Create the backend called name: "mat64", "reference" or "blocked".
+func NewBackend(name string) (Backend, error) {
+  switch name {
+  case "mat64":
+    return new(Mat64Backend), nil
+  case "reference":
+    return new(ReferenceBackend), nil
+  case "blocked":
+    return NewBlockedBackend(0, 0), nil
+  }
+  return nil, fmt.Errorf("unknown backend %v", name)
+}
+
+// mat64's own operations, which use BLAS. Float32 operations are as in
+// ReferenceBackend, since mat64 has no float32 matrices.
+type Mat64Backend struct {
+  ReferenceBackend
+}
+
+func (self *Mat64Backend) Mul(c, a, b *mat64.Dense) {
+  checkMul(c, a, b)
+  c.Mul(a, b)
+}
+
+func (self *Mat64Backend) MulElem(c, a, b *mat64.Dense) {
+  c.MulElem(a, b)
+}
+
+// Straightforward pure Go loops.
+type ReferenceBackend struct {
+}
+
+func (self *ReferenceBackend) Mul(c, a, b *mat64.Dense) {
+  checkMul(c, a, b)
+  rows, _ := c.Dims()
+  for i := 0; i < rows; i++ {
+    row := c.RawRowView(i)
+    for j := range row {
+      row[j] = 0
+    }
+    for k, aik := range a.RawRowView(i) {
+      for j, bkj := range b.RawRowView(k) {
+        row[j] += aik * bkj
+      }
+    }
+  }
+}
+
+func (self *ReferenceBackend) MulElem(c, a, b *mat64.Dense) {
+  rows, _ := c.Dims()
+  for i := 0; i < rows; i++ {
+    row, aRow, bRow := c.RawRowView(i), a.RawRowView(i), b.RawRowView(i)
+    for j := range row {
+      row[j] = aRow[j] * bRow[j]
+    }
+  }
+}
+
+func (self *ReferenceBackend) Mul32(c, a, b *Dense32) {
+  checkMul(c, a, b)
+  for i := 0; i < c.rows; i++ {
+    row := c.RawRowView(i)
+    for j := range row {
+      row[j] = 0
+    }
+    for k, aik := range a.RawRowView(i) {
+      for j, bkj := range b.RawRowView(k) {
+        row[j] += aik * bkj
+      }
+    }
+  }
+}
+
+func (self *ReferenceBackend) MulElem32(c, a, b *Dense32) {
+  for i := range c.data {
+    c.data[i] = a.data[i] * b.data[i]
+  }
+}
+
+// Multiplies blocks of rows of c in parallel, iterating over tiles of a and b
+// that fit in cache. Elementwise operations are as in ReferenceBackend. Safe
+// for concurrent use, though multiplications are serialized. Close it to stop
+// its goroutines.
+type BlockedBackend struct {
+  ReferenceBackend
+  // Side of the square tiles multiplied at once.
+  BlockSize int
+  // Number of goroutines multiplying blocks of rows.
+  Workers int
+
+  lock sync.Mutex
+  start sync.Once
+  tasks chan blockedTask
+  done sync.WaitGroup
+  closed bool
+}
+
+// Products with fewer multiply-adds than this run on the calling goroutine.
+const blockedParallelMinimum = 1 << 15
+
+// Rows [start, end) of one multiplication, at either precision.
+type blockedTask struct {
+  c, a, b *mat64.Dense
+  c32, a32, b32 *Dense32
+  start, end int
+}
+
+// Create a backend with blocks of blockSize and workers goroutines, or
+// defaults for each that is 0.
+func NewBlockedBackend(blockSize, workers int) *BlockedBackend {
+  if blockSize == 0 {
+    blockSize = 64
+  }
+  if workers == 0 {
+    workers = runtime.NumCPU()
+  }
+  return &BlockedBackend{BlockSize: blockSize, Workers: workers}
+}
+
+func (self *BlockedBackend) Mul(c, a, b *mat64.Dense) {
+  checkMul(c, a, b)
+  rows, _ := c.Dims()
+  self.run(blockedTask{c: c, a: a, b: b, end: rows}, multiplyAdds(a, b))
+}
+
+func (self *BlockedBackend) Mul32(c, a, b *Dense32) {
+  checkMul(c, a, b)
+  self.run(blockedTask{c32: c, a32: a, b32: b, end: c.rows}, multiplyAdds(a, b))
+}
+
+// Split task's rows among the workers, or multiply directly if its size in
+// multiply-adds is small.
+func (self *BlockedBackend) run(task blockedTask, size int) {
+  rows := task.end
+  if self.Workers <= 1 || rows < 2 || size < blockedParallelMinimum {
+    self.multiply(task)
+    return
+  }
+  self.start.Do(func() {
+    self.tasks = make(chan blockedTask, self.Workers)
+    for i := 0; i < self.Workers; i++ {
+      go func() {
+        for task := range self.tasks {
+          self.multiply(task)
+          self.done.Done()
+        }
+      }()
+    }
+  })
+  self.lock.Lock()
+  defer self.lock.Unlock()
+  if self.closed {
+    self.multiply(task)
+    return
+  }
+  chunk := (rows + self.Workers - 1) / self.Workers
+  for start := 0; start < rows; start += chunk {
+    task.start, task.end = start, min(start + chunk, rows)
+    self.done.Add(1)
+    self.tasks <- task
+  }
+  self.done.Wait()
+}
+
+// Stop the worker goroutines. Later multiplications run on the calling
+// goroutine.
+func (self *BlockedBackend) Close() error {
+  // Workers never start once closed.
+  self.start.Do(func() {})
+  self.lock.Lock()
+  defer self.lock.Unlock()
+  if !self.closed && self.tasks != nil {
+    close(self.tasks)
+  }
+  self.closed = true
+  return nil
+}
+
+// Multiply rows [task.start, task.end) of task one tile at a time.
+func (self *BlockedBackend) multiply(task blockedTask) {
+  if task.c32 != nil {
+    self.multiply32(task)
+    return
+  }
+  inner, cols := task.b.Dims()
+  for i := task.start; i < task.end; i++ {
+    row := task.c.RawRowView(i)
+    for j := range row {
+      row[j] = 0
+    }
+  }
+  for kk := 0; kk < inner; kk += self.BlockSize {
+    kEnd := min(kk + self.BlockSize, inner)
+    for jj := 0; jj < cols; jj += self.BlockSize {
+      jEnd := min(jj + self.BlockSize, cols)
+      for i := task.start; i < task.end; i++ {
+        row := task.c.RawRowView(i)[jj:jEnd]
+        aRow := task.a.RawRowView(i)
+        for k := kk; k < kEnd; k++ {
+          aik := aRow[k]
+          for j, bkj := range task.b.RawRowView(k)[jj:jEnd] {
+            row[j] += aik * bkj
+          }
+        }
+      }
+    }
+  }
+}
+
+// Float32 version of multiply.
+func (self *BlockedBackend) multiply32(task blockedTask) {
+  inner, cols := task.b32.Dims()
+  for i := task.start; i < task.end; i++ {
+    row := task.c32.RawRowView(i)
+    for j := range row {
+      row[j] = 0
+    }
+  }
+  for kk := 0; kk < inner; kk += self.BlockSize {
+    kEnd := min(kk + self.BlockSize, inner)
+    for jj := 0; jj < cols; jj += self.BlockSize {
+      jEnd := min(jj + self.BlockSize, cols)
+      for i := task.start; i < task.end; i++ {
+        row := task.c32.RawRowView(i)[jj:jEnd]
+        aRow := task.a32.RawRowView(i)
+        for k := kk; k < kEnd; k++ {
+          aik := aRow[k]
+          for j, bkj := range task.b32.RawRowView(k)[jj:jEnd] {
+            row[j] += aik * bkj
+          }
+        }
+      }
+    }
+  }
+}
+
+// Panic unless c can hold a * b.
+func checkMul(c, a, b mat64.Matrix) {
+  aRows, aCols := a.Dims()
+  bRows, bCols := b.Dims()
+  cRows, cCols := c.Dims()
+  if aCols != bRows || cRows != aRows || cCols != bCols {
+    panic(fmt.Sprintf("neural: can't multiply %vx%v by %vx%v into %vx%v",
+                      aRows, aCols, bRows, bCols, cRows, cCols))
+  }
+}
+
+// Number of multiply-adds in a * b.
+func multiplyAdds(a, b mat64.Matrix) int {
+  rows, inner := a.Dims()
+  _, cols := b.Dims()
+  return rows * inner * cols
+}
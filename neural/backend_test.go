@@ -0,0 +1,95 @@
+package neural_test
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+func randomDense(rows, cols int) *mat64.Dense {
+  m := mat64.NewDense(rows, cols, nil)
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      m.Set(i, j, rand.NormFloat64())
+    }
+  }
+  return m
+}
+
+func TestBlockedBackend(t *testing.T) {
+  reference := new(neural.ReferenceBackend)
+  // Sizes that aren't multiples of the block size, large enough to run in
+  // parallel.
+  blocked := neural.NewBlockedBackend(16, 3)
+  defer blocked.Close()
+  a, b := randomDense(41, 53), randomDense(53, 37)
+  expected := mat64.NewDense(41, 37, nil)
+  actual := mat64.NewDense(41, 37, nil)
+  reference.Mul(expected, a, b)
+  blocked.Mul(actual, a, b)
+  if !mat64.EqualApprox(expected, actual, 1e-9) {
+    t.Errorf("blocked product differs from reference")
+  }
+  new(neural.Mat64Backend).Mul(actual, a, b)
+  if !mat64.EqualApprox(actual, expected, 1e-9) {
+    t.Errorf("reference product differs from mat64")
+  }
+
+  a32, b32 := neural.NewDense32(41, 53, nil), neural.NewDense32(53, 37, nil)
+  a32.Copy(a)
+  b32.Copy(b)
+  expected32 := neural.NewDense32(41, 37, nil)
+  actual32 := neural.NewDense32(41, 37, nil)
+  reference.Mul32(expected32, a32, b32)
+  blocked.Mul32(actual32, a32, b32)
+  if !mat64.EqualApprox(expected32, actual32, 1e-4) ||
+     !mat64.EqualApprox(expected32, expected, 1e-4) {
+    t.Errorf("blocked float32 product differs from reference")
+  }
+
+  // Closed backends still multiply, on the calling goroutine.
+  blocked.Close()
+  actual.Scale(0, actual)
+  blocked.Mul(actual, a, b)
+  if !mat64.EqualApprox(expected, actual, 1e-9) {
+    t.Errorf("closed blocked product differs from reference")
+  }
+  unstarted := neural.NewBlockedBackend(16, 3)
+  unstarted.Close()
+  unstarted.Mul(actual, a, b)
+  if !mat64.EqualApprox(expected, actual, 1e-9) {
+    t.Errorf("product of a backend closed before use differs from reference")
+  }
+}
+
+func TestNetworkBackends(t *testing.T) {
+  features, values := createMNISTBatch(benchmarkBatchSize)
+  learningConfiguration := benchmarkLearningConfiguration()
+  // The default backend is mat64.
+  mat64Network := createMNISTNetwork()
+  reference := withPrecision(t, mat64Network, neural.Precision_FLOAT64)
+  blocked := withPrecision(t, mat64Network, neural.Precision_FLOAT64)
+  blockedBackend := neural.NewBlockedBackend(0, 4)
+  defer blockedBackend.Close()
+  for i := range reference.Layers {
+    reference.Layers[i].Backend = new(neural.ReferenceBackend)
+    blocked.Layers[i].Backend = blockedBackend
+  }
+  for _, neuralNetwork := range []*neural.Network{mat64Network, reference,
+                                                  blocked} {
+    for i := 0; i < 3; i++ {
+      neuralNetwork.Forward(features)
+      neuralNetwork.Backward(values, new(neural.CrossEntropyErrorFunction))
+      neuralNetwork.Update(learningConfiguration)
+    }
+  }
+  for i := range reference.Layers {
+    if !mat64.EqualApprox(reference.Layers[i].Weight,
+                          blocked.Layers[i].Weight, 1e-9) ||
+       !mat64.EqualApprox(reference.Layers[i].Weight,
+                          mat64Network.Layers[i].Weight, 1e-9) {
+      t.Errorf("layer %v weights differ between backends", i)
+    }
+  }
+}
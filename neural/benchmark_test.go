@@ -0,0 +1,261 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/gonum/matrix/mat64";
+  "github.com/golang/protobuf/proto";
+  "testing";
+  "time"
+  "neural";
+)
+
+// Shape of the synthetic MNIST-like data used by every benchmark.
+const (
+  benchmarkInputs = 784
+  benchmarkHidden = 100
+  benchmarkClasses = 10
+  benchmarkBatchSize = 32
+  benchmarkDatapoints = 1024
+)
+
+// MNIST-sized network with a hidden layer of benchmarkHidden units.
+func createMNISTNetwork() *neural.Network {
+  networkConfiguration := neural.NetworkConfiguration{
+    Inputs: proto.Int32(benchmarkInputs),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LOGISTIC.Enum(),
+        Outputs: proto.Int32(benchmarkHidden),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_SOFTMAX.Enum(),
+        Outputs: proto.Int32(benchmarkClasses),
+      },
+    },
+  }
+  neuralNetwork := neural.NewNetwork(networkConfiguration)
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+// createMNISTNetwork at FLOAT32 precision.
+func createMNISTNetwork32() *neural.Network {
+  neuralNetwork := createMNISTNetwork()
+  var networkConfiguration neural.NetworkConfiguration
+  json.Unmarshal(neuralNetwork.Serialize(), &networkConfiguration)
+  networkConfiguration.Precision = neural.Precision_FLOAT32.Enum()
+  return neural.NewNetwork(networkConfiguration)
+}
+
+// Deterministic datapoints with pixel-like features and one-hot values.
+func createMNISTDatapoints(examples int) []neural.Datapoint {
+  datapoints := make([]neural.Datapoint, examples)
+  for i := range datapoints {
+    features := make([]float64, benchmarkInputs)
+    for j := range features {
+      features[j] = float64((i * 7 + j) % 256) / 255
+    }
+    datapoints[i] = neural.Datapoint{
+        Features: features,
+        Values: neural.OneHot(i % benchmarkClasses, benchmarkClasses),
+    }
+  }
+  return datapoints
+}
+
+// Matrices of the features and values of createMNISTDatapoints(examples).
+func createMNISTBatch(examples int) (*mat64.Dense, *mat64.Dense) {
+  features := mat64.NewDense(examples, benchmarkInputs, nil)
+  values := mat64.NewDense(examples, benchmarkClasses, nil)
+  for i, datapoint := range createMNISTDatapoints(examples) {
+    features.SetRow(i, datapoint.Features)
+    values.SetRow(i, datapoint.Values)
+  }
+  return features, values
+}
+
+func benchmarkLearningConfiguration() neural.LearningConfiguration {
+  return neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.1),
+    Decay: proto.Float64(0.001),
+    BatchSize: proto.Int32(benchmarkBatchSize),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+}
+
+// Report throughput given that each iteration of b since start processes
+// examples.
+func reportExamples(b *testing.B, examples int, start time.Time) {
+  b.ReportMetric(float64(examples * b.N) / time.Since(start).Seconds(),
+                 "examples/s")
+}
+
+func BenchmarkLayerForward(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  features, _ := createMNISTBatch(benchmarkBatchSize)
+  neuralNetwork.Forward(features)
+  input := &neural.Layer{Output: features}
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    neuralNetwork.Layers[0].Forward(input)
+  }
+  reportExamples(b, benchmarkBatchSize, start)
+}
+
+func BenchmarkLayerBackward(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  features, values := createMNISTBatch(benchmarkBatchSize)
+  neuralNetwork.Forward(features)
+  neuralNetwork.Backward(values, new(neural.CrossEntropyErrorFunction))
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    neuralNetwork.Layers[0].Backward(neuralNetwork.Layers[1])
+  }
+  reportExamples(b, benchmarkBatchSize, start)
+}
+
+func BenchmarkNetworkUpdate(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  features, values := createMNISTBatch(benchmarkBatchSize)
+  neuralNetwork.Forward(features)
+  neuralNetwork.Backward(values, new(neural.CrossEntropyErrorFunction))
+  learningConfiguration := benchmarkLearningConfiguration()
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    neuralNetwork.Update(learningConfiguration)
+  }
+  reportExamples(b, benchmarkBatchSize, start)
+}
+
+// Forward, Backward and Update on a steady-state batch, which should not
+// allocate.
+func benchmarkNetworkBatch(b *testing.B, neuralNetwork *neural.Network) {
+  features, values := createMNISTBatch(benchmarkBatchSize)
+  errorFunction := new(neural.CrossEntropyErrorFunction)
+  learningConfiguration := benchmarkLearningConfiguration()
+  batch := func() {
+    neuralNetwork.Forward(features)
+    neuralNetwork.Backward(values, errorFunction)
+    neuralNetwork.Update(learningConfiguration)
+  }
+  batch()  // Size workspaces.
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    batch()
+  }
+  reportExamples(b, benchmarkBatchSize, start)
+}
+
+// The steady-state batch of benchmarkNetworkBatch and Evaluate reuse their
+// workspaces rather than allocating.
+func TestNetworkBatchAllocs(t *testing.T) {
+  features, values := createMNISTBatch(benchmarkBatchSize)
+  errorFunction := new(neural.CrossEntropyErrorFunction)
+  learningConfiguration := benchmarkLearningConfiguration()
+  for _, neuralNetwork := range []*neural.Network{createMNISTNetwork(),
+                                                  createMNISTNetwork32()} {
+    batch := func() {
+      neuralNetwork.Forward(features)
+      neuralNetwork.Backward(values, errorFunction)
+      neuralNetwork.Update(learningConfiguration)
+    }
+    if allocs := testing.AllocsPerRun(10, batch); allocs > 0 {
+      t.Errorf("%v allocations per %v batch", allocs,
+               neuralNetwork.Precision)
+    }
+    example := features.RawRowView(0)
+    if allocs := testing.AllocsPerRun(10, func() {
+      neuralNetwork.Evaluate(example)
+    }); allocs > 0 {
+      t.Errorf("%v allocations per %v Evaluate", allocs,
+               neuralNetwork.Precision)
+    }
+  }
+}
+
+func BenchmarkNetworkBatch(b *testing.B) {
+  benchmarkNetworkBatch(b, createMNISTNetwork())
+}
+
+func BenchmarkNetworkBatch32(b *testing.B) {
+  benchmarkNetworkBatch(b, createMNISTNetwork32())
+}
+
+func BenchmarkNetworkBatchBlocked(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  backend := neural.NewBlockedBackend(0, 0)
+  defer backend.Close()
+  for _, layer := range neuralNetwork.Layers {
+    layer.Backend = backend
+  }
+  benchmarkNetworkBatch(b, neuralNetwork)
+}
+
+// One epoch of Train per iteration.
+func BenchmarkTrain(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  datapoints := createMNISTDatapoints(benchmarkDatapoints)
+  learningConfiguration := benchmarkLearningConfiguration()
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    if err := neural.Train(
+        neuralNetwork, datapoints, learningConfiguration); err != nil {
+      b.Fatal(err)
+    }
+  }
+  reportExamples(
+      b, benchmarkDatapoints / benchmarkBatchSize * benchmarkBatchSize, start)
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  features := createMNISTDatapoints(1)[0].Features
+  neuralNetwork.Evaluate(features)  // Size workspaces.
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    neuralNetwork.Evaluate(features)
+  }
+  reportExamples(b, 1, start)
+}
+
+// Evaluate over every datapoint, one example at a time.
+func BenchmarkEvaluateBatch(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  datapoints := createMNISTDatapoints(benchmarkDatapoints)
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    if _, err := neural.Evaluate(*neuralNetwork, datapoints); err != nil {
+      b.Fatal(err)
+    }
+  }
+  reportExamples(b, benchmarkDatapoints, start)
+}
+
+// Forward over a whole batch of examples at once.
+func BenchmarkForwardBatch(b *testing.B) {
+  neuralNetwork := createMNISTNetwork()
+  features, _ := createMNISTBatch(benchmarkDatapoints)
+  neuralNetwork.Forward(features)  // Size workspaces.
+  b.ReportAllocs()
+  b.ResetTimer()
+  start := time.Now()
+  for i := 0; i < b.N; i++ {
+    neuralNetwork.Forward(features)
+  }
+  reportExamples(b, benchmarkDatapoints, start)
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+  "encoding/csv";
+  "fmt";
+  "io";
+  "os";
+  "strconv";
+  "strings";
+  "neural"
+)
+
+// Whether the first row of a CSV file names its columns.
+type CSVHeader int
+
+const (
+  // The first row is a header if any of its fields isn't a number.
+  DetectHeader CSVHeader = iota
+  Header
+  NoHeader
+)
+
+type CSVOptions struct {
+  // Field separator, ',' if 0. '\t' reads TSV.
+  Comma rune
+  Header CSVHeader
+  // Column name or 0-based index of each feature, in order. Every column that
+  // isn't a target if empty.
+  Features []string
+  // Column name or 0-based index of each value, in order. Datapoints have no
+  // values if empty.
+  Targets []string
+}
+
+// A field of a CSV file that isn't a number, or a row with too few fields.
+type ParseError struct {
+  Line int
+  Column string
+  Err error
+}
+
+func (self *ParseError) Error() string {
+  if len(self.Column) == 0 {
+    return fmt.Sprintf("line %v: %v", self.Line, self.Err)
+  }
+  return fmt.Sprintf("line %v, column %v: %v", self.Line, self.Column,
+                     self.Err)
+}
+
+// Every ParseError of a file, in order.
+type ParseErrors []*ParseError
+
+// Maximum number of ParseErrors described by their Error method.
+const maxParseErrorsShown = 10
+
+func (self ParseErrors) Error() string {
+  messages := make([]string, 0, maxParseErrorsShown + 1)
+  for i, err := range self {
+    if i == maxParseErrorsShown {
+      messages = append(messages,
+                        fmt.Sprintf("and %v more", len(self) - i))
+      break
+    }
+    messages = append(messages, err.Error())
+  }
+  return "data: " + strings.Join(messages, "; ")
+}
+
+// Return the index of column, a name in header or an index.
+func columnIndex(column string, header []string) (int, error) {
+  for i, name := range header {
+    if name == column {
+      return i, nil
+    }
+  }
+  if i, err := strconv.Atoi(column); err == nil && i >= 0 {
+    return i, nil
+  }
+  return 0, fmt.Errorf("data: no column %q", column)
+}
+
+// Read datapoints from the rows of a CSV or TSV file, which may quote fields.
+// If any fields don't parse, returns the datapoints of the other rows with
+// ParseErrors giving the line of each bad row.
+func ReadCSVDatapoints(r io.Reader,
+                       options CSVOptions) ([]neural.Datapoint, error) {
+  reader := csv.NewReader(r)
+  if options.Comma != 0 {
+    reader.Comma = options.Comma
+  }
+  reader.FieldsPerRecord = -1
+  first, err := reader.Read()
+  if err == io.EOF {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+  header := options.Header == Header
+  if options.Header == DetectHeader {
+    for _, field := range first {
+      if _, err := strconv.ParseFloat(strings.TrimSpace(field),
+                                      64); err != nil {
+        header = true
+        break
+      }
+    }
+  }
+  var names []string
+  if header {
+    names = first
+  }
+
+  targets := make([]int, len(options.Targets))
+  isTarget := make(map[int]bool)
+  for i, column := range options.Targets {
+    if targets[i], err = columnIndex(column, names); err != nil {
+      return nil, err
+    }
+    isTarget[targets[i]] = true
+  }
+  var features []int
+  for _, column := range options.Features {
+    index, err := columnIndex(column, names)
+    if err != nil {
+      return nil, err
+    }
+    features = append(features, index)
+  }
+  if len(options.Features) == 0 {
+    for i := range first {
+      if !isTarget[i] {
+        features = append(features, i)
+      }
+    }
+  }
+  // Name of each column in errors.
+  columnName := func(i int) string {
+    if i < len(names) {
+      return strconv.Quote(names[i])
+    }
+    return strconv.Itoa(i)
+  }
+  // Parse the fields of record at columns.
+  parse := func(record []string, line int,
+                columns []int) ([]float64, []*ParseError) {
+    var errs []*ParseError
+    parsed := make([]float64, len(columns))
+    for i, column := range columns {
+      if column >= len(record) {
+        errs = append(errs, &ParseError{line, "", fmt.Errorf(
+            "%v fields, expected at least %v", len(record), column + 1)})
+        return nil, errs
+      }
+      var err error
+      parsed[i], err = strconv.ParseFloat(strings.TrimSpace(record[column]),
+                                          64)
+      if err != nil {
+        errs = append(errs, &ParseError{line, columnName(column), err})
+      }
+    }
+    return parsed, errs
+  }
+
+  var datapoints []neural.Datapoint
+  var parseErrors ParseErrors
+  record := first
+  if header {
+    record, err = reader.Read()
+  }
+  for ; err == nil; record, err = reader.Read() {
+    // The line the record starts on, even after quoted fields spanning lines.
+    // Needs Go 1.17.
+    line, _ := reader.FieldPos(0)
+    var datapoint neural.Datapoint
+    var featureErrors, targetErrors []*ParseError
+    datapoint.Features, featureErrors = parse(record, line, features)
+    if len(targets) > 0 {
+      datapoint.Values, targetErrors = parse(record, line, targets)
+    }
+    if len(featureErrors) + len(targetErrors) > 0 {
+      parseErrors = append(parseErrors, featureErrors...)
+      parseErrors = append(parseErrors, targetErrors...)
+      continue
+    }
+    datapoints = append(datapoints, datapoint)
+  }
+  if err != io.EOF {
+    return nil, err
+  }
+  if len(parseErrors) > 0 {
+    return datapoints, parseErrors
+  }
+  return datapoints, nil
+}
+
+// Read datapoints from the CSV or TSV file filename, like ReadCSVDatapoints.
+func ReadCSVFile(filename string,
+                 options CSVOptions) ([]neural.Datapoint, error) {
+  f, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  datapoints, err := ReadCSVDatapoints(f, options)
+  if err != nil {
+    return datapoints, fmt.Errorf("%v: %w", filename, err)
+  }
+  return datapoints, nil
+}
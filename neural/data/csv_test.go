@@ -0,0 +1,115 @@
+package data_test
+
+import (
+  "errors";
+  "io/ioutil";
+  "os";
+  "path/filepath";
+  "reflect";
+  "strings";
+  "testing"
+  "neural/data";
+)
+
+func TestReadCSVDatapoints(t *testing.T) {
+  tests := []struct {
+    file string
+    options data.CSVOptions
+    features [][]float64
+    values [][]float64
+  }{
+    // Detected header, targets by name, remaining columns as features.
+    {"a,\"b, quoted\",label\n1,2,0\n3, 4 ,1\n",
+     data.CSVOptions{Targets: []string{"label"}},
+     [][]float64{{1, 2}, {3, 4}}, [][]float64{{0}, {1}}},
+    // No header, columns by index, reordered.
+    {"1,2,3\n4,5,6\n",
+     data.CSVOptions{Features: []string{"2", "0"}, Targets: []string{"1"}},
+     [][]float64{{3, 1}, {6, 4}}, [][]float64{{2}, {5}}},
+    // Numeric header forced, TSV, features by name and no targets.
+    {"1\t2\n3\t4\n",
+     data.CSVOptions{Comma: '\t', Header: data.Header,
+                     Features: []string{"2"}},
+     [][]float64{{4}}, [][]float64{nil}},
+    // Quoted fields may span lines.
+    {"x,y\n\"1\",\"\n2\"\n3,4\n",
+     data.CSVOptions{Targets: []string{"y"}},
+     [][]float64{{1}, {3}}, [][]float64{{2}, {4}}},
+  }
+  for _, test := range tests {
+    datapoints, err := data.ReadCSVDatapoints(strings.NewReader(test.file),
+                                              test.options)
+    if err != nil {
+      t.Errorf("%q: %v", test.file, err)
+      continue
+    }
+    if len(datapoints) != len(test.features) {
+      t.Errorf("%q: read %v datapoints, expected %v", test.file,
+               len(datapoints), len(test.features))
+      continue
+    }
+    for i, datapoint := range datapoints {
+      if !reflect.DeepEqual(datapoint.Features, test.features[i]) ||
+         !reflect.DeepEqual(datapoint.Values, test.values[i]) {
+        t.Errorf("%q: datapoint %v is %+v, expected features %v and " +
+                 "values %v", test.file, i, datapoint, test.features[i],
+                 test.values[i])
+      }
+    }
+  }
+}
+
+func TestReadCSVDatapointsErrors(t *testing.T) {
+  file := "a,b\n1,2\nx,3\n4\n\"5\n\",y\n6,7\n"
+  datapoints, err := data.ReadCSVDatapoints(
+      strings.NewReader(file), data.CSVOptions{Targets: []string{"b"}})
+  var parseErrors data.ParseErrors
+  if !errors.As(err, &parseErrors) {
+    t.Fatalf("error %v isn't ParseErrors", err)
+  }
+  lines := []int{}
+  for _, parseError := range parseErrors {
+    lines = append(lines, parseError.Line)
+  }
+  if !reflect.DeepEqual(lines, []int{3, 4, 5}) {
+    t.Errorf("errors %v on lines %v, expected 3, 4 and 5", err, lines)
+  }
+  if parseErrors[0].Column != `"a"` {
+    t.Errorf("error %v in column %v, expected \"a\"", parseErrors[0],
+             parseErrors[0].Column)
+  }
+  if len(datapoints) != 2 || datapoints[1].Features[0] != 6 {
+    t.Errorf("read good rows %+v, expected 2", datapoints)
+  }
+
+  if _, err := data.ReadCSVDatapoints(
+      strings.NewReader("a,b\n1,2\n"),
+      data.CSVOptions{Targets: []string{"c"}}); err == nil {
+    t.Errorf("read missing target column")
+  }
+  if _, err := data.ReadCSVDatapoints(
+      strings.NewReader("a,b\n1,2\n"),
+      data.CSVOptions{Header: data.NoHeader}); err == nil {
+    t.Errorf("read header as a row")
+  }
+}
+
+func TestReadCSVFile(t *testing.T) {
+  dir, err := ioutil.TempDir("", "csv")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  filename := filepath.Join(dir, "data.tsv")
+  ioutil.WriteFile(filename, []byte("a\tb\n1\tx\n"), 0666)
+  _, err = data.ReadCSVFile(filename, data.CSVOptions{Comma: '\t'})
+  var parseErrors data.ParseErrors
+  if !errors.As(err, &parseErrors) ||
+     !strings.Contains(err.Error(), filename) {
+    t.Errorf("error %v doesn't wrap ParseErrors with the file name", err)
+  }
+  if _, err := data.ReadCSVFile(filepath.Join(dir, "missing.csv"),
+                                data.CSVOptions{}); err == nil {
+    t.Errorf("read missing file")
+  }
+}
@@ -0,0 +1,297 @@
+// Readers of datasets on disk into Datapoints.
+package data
+
+import (
+  "bufio";
+  "compress/gzip";
+  "encoding/binary";
+  "fmt";
+  "io";
+  "math";
+  "os";
+  "path/filepath";
+  "neural"
+)
+
+// Sizes in bytes of the element types of IDX files, by type code.
+var idxElementSizes = map[byte]int{
+  0x08: 1,  // unsigned byte
+  0x09: 1,  // signed byte
+  0x0B: 2,  // short
+  0x0C: 4,  // int
+  0x0D: 4,  // float
+  0x0E: 8,  // double
+}
+
+// Largest item in bytes that an IDXReader buffers, so that a corrupt header
+// can't make it allocate unbounded memory.
+const maxIDXItemSize = 1 << 28
+
+// Streams the items of an IDX file, the slices of its tensor along the first
+// dimension, such as the images of an MNIST images file or the labels of a
+// labels file.
+type IDXReader struct {
+  // Dimensions of the file's tensor. The first is the number of items.
+  Shape []int
+  // Whether elements are unsigned bytes, such as pixels.
+  Bytes bool
+
+  elementType byte
+  item []byte  // Reused by Next.
+  read int  // Number of items read.
+  reader *bufio.Reader
+  closers []io.Closer
+}
+
+// Read the header of an IDX file from r, which may be gzipped.
+func NewIDXReader(r io.Reader) (*IDXReader, error) {
+  self := &IDXReader{reader: bufio.NewReader(r)}
+  if magic, err := self.reader.Peek(2); err == nil &&
+     magic[0] == 0x1f && magic[1] == 0x8b {
+    gzipReader, err := gzip.NewReader(self.reader)
+    if err != nil {
+      return nil, err
+    }
+    self.closers = append(self.closers, gzipReader)
+    self.reader = bufio.NewReader(gzipReader)
+  }
+  var header [4]byte
+  if _, err := io.ReadFull(self.reader, header[:]); err != nil {
+    return nil, fmt.Errorf("data: can't read IDX header: %v", err)
+  }
+  size, ok := idxElementSizes[header[2]]
+  if header[0] != 0 || header[1] != 0 || !ok {
+    return nil, fmt.Errorf("data: bad IDX magic number % x", header)
+  }
+  self.elementType = header[2]
+  self.Bytes = self.elementType == 0x08
+  self.Shape = make([]int, header[3])
+  itemSize := size
+  for i := range self.Shape {
+    var dimension uint32
+    if err := binary.Read(self.reader, binary.BigEndian,
+                          &dimension); err != nil {
+      return nil, fmt.Errorf("data: can't read IDX dimensions: %v", err)
+    }
+    self.Shape[i] = int(dimension)
+    if i > 0 {
+      if self.Shape[i] > 0 && itemSize > maxIDXItemSize / self.Shape[i] {
+        return nil, fmt.Errorf("data: IDX items are over %v bytes",
+                               maxIDXItemSize)
+      }
+      itemSize *= self.Shape[i]
+    }
+  }
+  if len(self.Shape) == 0 {
+    return nil, fmt.Errorf("data: IDX file has no dimensions")
+  }
+  self.item = make([]byte, itemSize)
+  return self, nil
+}
+
+// Open an IDX file, which may be gzipped. Close it when done.
+func OpenIDX(filename string) (*IDXReader, error) {
+  f, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  self, err := NewIDXReader(f)
+  if err != nil {
+    f.Close()
+    return nil, fmt.Errorf("%v: %v", filename, err)
+  }
+  self.closers = append(self.closers, f)
+  return self, nil
+}
+
+// Number of items in the file.
+func (self *IDXReader) Len() int {
+  return self.Shape[0]
+}
+
+// Return the elements of the next item, in row-major order, or io.EOF after
+// the last item.
+func (self *IDXReader) Next() ([]float64, error) {
+  if self.read == self.Len() {
+    return nil, io.EOF
+  }
+  if _, err := io.ReadFull(self.reader, self.item); err != nil {
+    if err == io.EOF {
+      err = io.ErrUnexpectedEOF
+    }
+    return nil, fmt.Errorf("data: can't read IDX item %v: %v", self.read, err)
+  }
+  self.read++
+  size := idxElementSizes[self.elementType]
+  elements := make([]float64, len(self.item) / size)
+  for i := range elements {
+    b := self.item[i * size:(i + 1) * size]
+    switch self.elementType {
+    case 0x08:
+      elements[i] = float64(b[0])
+    case 0x09:
+      elements[i] = float64(int8(b[0]))
+    case 0x0B:
+      elements[i] = float64(int16(binary.BigEndian.Uint16(b)))
+    case 0x0C:
+      elements[i] = float64(int32(binary.BigEndian.Uint32(b)))
+    case 0x0D:
+      elements[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+    case 0x0E:
+      elements[i] = math.Float64frombits(binary.BigEndian.Uint64(b))
+    }
+  }
+  return elements, nil
+}
+
+// Close any files and decompressors opened by the reader.
+func (self *IDXReader) Close() error {
+  var err error
+  for _, closer := range self.closers {
+    if closeErr := closer.Close(); err == nil {
+      err = closeErr
+    }
+  }
+  return err
+}
+
+type IDXOptions struct {
+  // Scale unsigned byte features, such as pixels, from [0, 255] to [0, 1].
+  Normalize bool
+  // Number of classes to one-hot encode each label into, or 0 to keep each
+  // label as a single value. Labels must then be from 0 to Classes - 1.
+  Classes int
+}
+
+// Streams datapoints from an IDX file of features and an optional IDX file of
+// labels with the same number of items.
+type IDXDataset struct {
+  Features *IDXReader
+  // nil if there are no labels.
+  Labels *IDXReader
+  Options IDXOptions
+}
+
+// Open featuresFile and, unless it is empty, labelsFile. Close the dataset
+// when done.
+func OpenIDXDataset(featuresFile, labelsFile string,
+                    options IDXOptions) (*IDXDataset, error) {
+  features, err := OpenIDX(featuresFile)
+  if err != nil {
+    return nil, err
+  }
+  self := &IDXDataset{Features: features, Options: options}
+  if len(labelsFile) == 0 {
+    return self, nil
+  }
+  if self.Labels, err = OpenIDX(labelsFile); err != nil {
+    features.Close()
+    return nil, err
+  }
+  if self.Labels.Len() != features.Len() {
+    self.Close()
+    return nil, fmt.Errorf("data: %v has %v items but %v has %v",
+                           featuresFile, features.Len(), labelsFile,
+                           self.Labels.Len())
+  }
+  return self, nil
+}
+
+// Return the next datapoint, or io.EOF after the last. Features of items of
+// more than one dimension keep their shape.
+func (self *IDXDataset) Next() (neural.Datapoint, error) {
+  var datapoint neural.Datapoint
+  var err error
+  if datapoint.Features, err = self.Features.Next(); err != nil {
+    return datapoint, err
+  }
+  if self.Options.Normalize && self.Features.Bytes {
+    for i := range datapoint.Features {
+      datapoint.Features[i] /= 255
+    }
+  }
+  if len(self.Features.Shape) > 2 {
+    datapoint.Shape = append([]int(nil), self.Features.Shape[1:]...)
+  }
+  if self.Labels == nil {
+    return datapoint, nil
+  }
+  if datapoint.Values, err = self.Labels.Next(); err != nil {
+    return datapoint, err
+  }
+  if self.Options.Classes > 0 && len(datapoint.Values) == 1 {
+    label := int(datapoint.Values[0])
+    if label < 0 || label >= self.Options.Classes {
+      return datapoint, fmt.Errorf("data: label %v of IDX item %v isn't one " +
+                                   "of %v classes", label,
+                                   self.Labels.read - 1, self.Options.Classes)
+    }
+    datapoint.Values = neural.OneHot(label, self.Options.Classes)
+  }
+  return datapoint, nil
+}
+
+func (self *IDXDataset) Close() error {
+  err := self.Features.Close()
+  if self.Labels != nil {
+    if labelsErr := self.Labels.Close(); err == nil {
+      err = labelsErr
+    }
+  }
+  return err
+}
+
+// Read every datapoint of featuresFile and the optional labelsFile, such as
+// the images and labels of MNIST, Fashion-MNIST or EMNIST.
+func ReadIDXDatapoints(featuresFile, labelsFile string,
+                       options IDXOptions) ([]neural.Datapoint, error) {
+  dataset, err := OpenIDXDataset(featuresFile, labelsFile, options)
+  if err != nil {
+    return nil, err
+  }
+  defer dataset.Close()
+  datapoints := make([]neural.Datapoint, 0, dataset.Features.Len())
+  for {
+    datapoint, err := dataset.Next()
+    if err == io.EOF {
+      return datapoints, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    datapoints = append(datapoints, datapoint)
+  }
+}
+
+// Read the training and testing datapoints of an MNIST-style directory of
+// IDX files, raw or gzipped, named like prefix + "train-images-idx3-ubyte"
+// and prefix + "t10k-images-idx3-ubyte" or prefix + "test-images-idx3-ubyte".
+// MNIST and Fashion-MNIST have an empty prefix and EMNIST's balanced split
+// has prefix "emnist-balanced-".
+func ReadMNIST(dir, prefix string, options IDXOptions) (
+    []neural.Datapoint, []neural.Datapoint, error) {
+  // The first existing file of names, or the first name if none exist.
+  find := func(names ...string) string {
+    for _, name := range names {
+      for _, suffix := range []string{"", ".gz"} {
+        filename := filepath.Join(dir, prefix + name + suffix)
+        if _, err := os.Stat(filename); err == nil {
+          return filename
+        }
+      }
+    }
+    return filepath.Join(dir, prefix + names[0])
+  }
+  training, err := ReadIDXDatapoints(find("train-images-idx3-ubyte"),
+                                     find("train-labels-idx1-ubyte"), options)
+  if err != nil {
+    return nil, nil, err
+  }
+  testing, err := ReadIDXDatapoints(
+      find("t10k-images-idx3-ubyte", "test-images-idx3-ubyte"),
+      find("t10k-labels-idx1-ubyte", "test-labels-idx1-ubyte"), options)
+  if err != nil {
+    return nil, nil, err
+  }
+  return training, testing, nil
+}
@@ -0,0 +1,181 @@
+package data_test
+
+import (
+  "bytes";
+  "compress/gzip";
+  "encoding/binary";
+  "io";
+  "io/ioutil";
+  "math";
+  "os";
+  "path/filepath";
+  "reflect";
+  "testing"
+  "neural/data";
+)
+
+// IDX file of elementType with shape and big-endian elements.
+func idxFile(elementType byte, shape []int, elements interface{}) []byte {
+  var buffer bytes.Buffer
+  buffer.Write([]byte{0, 0, elementType, byte(len(shape))})
+  for _, dimension := range shape {
+    binary.Write(&buffer, binary.BigEndian, uint32(dimension))
+  }
+  binary.Write(&buffer, binary.BigEndian, elements)
+  return buffer.Bytes()
+}
+
+func gzipped(b []byte) []byte {
+  var buffer bytes.Buffer
+  writer := gzip.NewWriter(&buffer)
+  writer.Write(b)
+  writer.Close()
+  return buffer.Bytes()
+}
+
+func TestIDXReader(t *testing.T) {
+  tests := []struct {
+    file []byte
+    shape []int
+    items [][]float64
+  }{
+    {idxFile(0x08, []int{2, 1, 3}, []uint8{0, 1, 255, 3, 4, 5}), []int{2, 1, 3},
+     [][]float64{{0, 1, 255}, {3, 4, 5}}},
+    {idxFile(0x09, []int{2}, []int8{-1, 7}), []int{2},
+     [][]float64{{-1}, {7}}},
+    {idxFile(0x0B, []int{1, 2}, []int16{-300, 300}), []int{1, 2},
+     [][]float64{{-300, 300}}},
+    {idxFile(0x0C, []int{1, 1}, []int32{-70000}), []int{1, 1},
+     [][]float64{{-70000}}},
+    {idxFile(0x0D, []int{1, 1}, []float32{0.5}), []int{1, 1},
+     [][]float64{{0.5}}},
+    {idxFile(0x0E, []int{1, 2, 1, 1}, []float64{math.Pi, -1}),
+     []int{1, 2, 1, 1}, [][]float64{{math.Pi, -1}}},
+  }
+  for _, test := range tests {
+    for _, file := range [][]byte{test.file, gzipped(test.file)} {
+      reader, err := data.NewIDXReader(bytes.NewReader(file))
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !reflect.DeepEqual(reader.Shape, test.shape) {
+        t.Errorf("shape %v, expected %v", reader.Shape, test.shape)
+      }
+      for _, expected := range test.items {
+        if item, err := reader.Next();
+           err != nil || !reflect.DeepEqual(item, expected) {
+          t.Errorf("item %v (error %v), expected %v", item, err, expected)
+        }
+      }
+      if _, err := reader.Next(); err != io.EOF {
+        t.Errorf("error %v after the last item, expected EOF", err)
+      }
+      reader.Close()
+    }
+  }
+}
+
+func TestIDXReaderErrors(t *testing.T) {
+  if _, err := data.NewIDXReader(
+      bytes.NewReader([]byte{0, 0, 0x42, 1, 0, 0, 0, 0})); err == nil {
+    t.Errorf("read header with an unknown element type")
+  }
+  if _, err := data.NewIDXReader(bytes.NewReader([]byte{0, 0})); err == nil {
+    t.Errorf("read truncated header")
+  }
+  huge := []byte{0, 0, 0x0E, 3, 0, 0, 0, 1}
+  huge = append(huge, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+  if _, err := data.NewIDXReader(bytes.NewReader(huge)); err == nil {
+    t.Errorf("read header with huge dimensions")
+  }
+  file := idxFile(0x08, []int{2, 2}, []uint8{1, 2, 3})
+  reader, err := data.NewIDXReader(bytes.NewReader(file))
+  if err != nil {
+    t.Fatal(err)
+  }
+  reader.Next()
+  if _, err := reader.Next(); err == nil || err == io.EOF {
+    t.Errorf("error %v reading a truncated item", err)
+  }
+}
+
+func TestReadIDXDatapoints(t *testing.T) {
+  dir, err := ioutil.TempDir("", "idx")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  images := filepath.Join(dir, "images.gz")
+  labels := filepath.Join(dir, "labels")
+  fewerLabels := filepath.Join(dir, "fewer_labels")
+  ioutil.WriteFile(images, gzipped(idxFile(
+      0x08, []int{2, 2, 2}, []uint8{0, 51, 102, 255, 255, 0, 0, 0})), 0666)
+  ioutil.WriteFile(labels, idxFile(0x08, []int{2}, []uint8{2, 0}), 0666)
+  ioutil.WriteFile(fewerLabels, idxFile(0x08, []int{1}, []uint8{2}), 0666)
+
+  datapoints, err := data.ReadIDXDatapoints(
+      images, labels, data.IDXOptions{Normalize: true, Classes: 3})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(datapoints) != 2 {
+    t.Fatalf("read %v datapoints, expected 2", len(datapoints))
+  }
+  if !reflect.DeepEqual(datapoints[0].Features, []float64{0, 0.2, 0.4, 1}) ||
+     !reflect.DeepEqual(datapoints[0].Values, []float64{0, 0, 1}) ||
+     !reflect.DeepEqual(datapoints[0].Shape, []int{2, 2}) {
+    t.Errorf("read datapoint %+v", datapoints[0])
+  }
+
+  datapoints, err = data.ReadIDXDatapoints(images, "", data.IDXOptions{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(datapoints[1].Features, []float64{255, 0, 0, 0}) ||
+     datapoints[1].Values != nil {
+    t.Errorf("read unnormalized datapoint %+v without labels", datapoints[1])
+  }
+
+  if _, err := data.ReadIDXDatapoints(
+      images, fewerLabels, data.IDXOptions{}); err == nil {
+    t.Errorf("read images with fewer labels")
+  }
+  // Labels from 1, like EMNIST letters, don't fit classes from 0.
+  if _, err := data.ReadIDXDatapoints(
+      images, labels, data.IDXOptions{Classes: 2}); err == nil {
+    t.Errorf("read label 2 of 2 classes")
+  }
+}
+
+func TestReadMNIST(t *testing.T) {
+  dir, err := ioutil.TempDir("", "mnist")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  files := map[string][]byte{
+    "emnist-train-images-idx3-ubyte.gz": gzipped(idxFile(
+        0x08, []int{2, 1, 1}, []uint8{0, 255})),
+    "emnist-train-labels-idx1-ubyte": idxFile(0x08, []int{2}, []uint8{1, 0}),
+    "emnist-test-images-idx3-ubyte": idxFile(
+        0x08, []int{1, 1, 1}, []uint8{255}),
+    "emnist-test-labels-idx1-ubyte.gz": gzipped(idxFile(
+        0x08, []int{1}, []uint8{1})),
+  }
+  for name, file := range files {
+    ioutil.WriteFile(filepath.Join(dir, name), file, 0666)
+  }
+  training, testing, err := data.ReadMNIST(
+      dir, "emnist-", data.IDXOptions{Normalize: true})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(training) != 2 || len(testing) != 1 ||
+     !reflect.DeepEqual(testing[0].Features, []float64{1}) ||
+     !reflect.DeepEqual(testing[0].Values, []float64{1}) {
+    t.Errorf("read training %+v and testing %+v", training, testing)
+  }
+  if _, _, err := data.ReadMNIST(dir, "", data.IDXOptions{}); err == nil {
+    t.Errorf("read MNIST from a directory without its files")
+  }
+}
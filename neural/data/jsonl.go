@@ -0,0 +1,68 @@
+package data
+
+import (
+  "bufio";
+  "bytes";
+  "encoding/json";
+  "fmt";
+  "io";
+  "os";
+  "path/filepath";
+  "neural"
+)
+
+// A JSON-lines file of datapoints, one JSON-formatted Datapoint per line,
+// read a batch at a time on each pass. Blank lines are skipped.
+type JSONLinesFile string
+
+func (self JSONLinesFile) Batches(batchSize int) (neural.BatchIterator,
+                                                  error) {
+  if batchSize <= 0 {
+    return nil, fmt.Errorf("data: batch size %v", batchSize)
+  }
+  f, err := os.Open(string(self))
+  if err != nil {
+    return nil, err
+  }
+  reader := bufio.NewReader(f)
+  line := 0
+  next := func() (neural.Datapoint, error) {
+    var datapoint neural.Datapoint
+    for {
+      text, err := reader.ReadBytes('\n')
+      if err != nil && err != io.EOF {
+        return datapoint, fmt.Errorf("%v: %w", self, err)
+      }
+      if len(text) == 0 {
+        return datapoint, io.EOF
+      }
+      line++
+      if len(bytes.TrimSpace(text)) == 0 {
+        continue
+      }
+      if err := json.Unmarshal(text, &datapoint); err != nil {
+        return datapoint, fmt.Errorf("%v: %w", self,
+                                     &ParseError{Line: line, Err: err})
+      }
+      return datapoint, nil
+    }
+  }
+  return neural.NewStreamIterator(batchSize, next, f.Close), nil
+}
+
+// Return the JSON-lines files matching the glob pattern as one dataset, whose
+// passes visit the files in a random order.
+func JSONLinesFiles(pattern string) (neural.ShardedDataset, error) {
+  filenames, err := filepath.Glob(pattern)
+  if err != nil {
+    return nil, err
+  }
+  if len(filenames) == 0 {
+    return nil, fmt.Errorf("data: no files match %v", pattern)
+  }
+  shards := make(neural.ShardedDataset, len(filenames))
+  for i, filename := range filenames {
+    shards[i] = JSONLinesFile(filename)
+  }
+  return shards, nil
+}
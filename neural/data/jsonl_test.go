@@ -0,0 +1,102 @@
+package data_test
+
+import (
+  "encoding/json";
+  "errors";
+  "io/ioutil";
+  "os";
+  "path/filepath";
+  "reflect";
+  "strings";
+  "testing"
+  "neural";
+  "neural/data";
+)
+
+func TestJSONLinesFile(t *testing.T) {
+  dir, err := ioutil.TempDir("", "jsonl")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  filename := filepath.Join(dir, "data.jsonl")
+  ioutil.WriteFile(filename, []byte(
+      "{\"Features\": [1, 2], \"Values\": [1]}\n" +
+      "\n" +
+      "{\"Features\": [3], \"Indices\": [4], \"Values\": [0]}"), 0666)
+  datapoints, err := neural.Collect(data.JSONLinesFile(filename))
+  if err != nil {
+    t.Fatal(err)
+  }
+  expected := []neural.Datapoint{
+    {Features: []float64{1, 2}, Values: []float64{1}},
+    {Features: []float64{3}, Indices: []int{4}, Values: []float64{0}},
+  }
+  if !reflect.DeepEqual(datapoints, expected) {
+    t.Errorf("read %+v, expected %+v", datapoints, expected)
+  }
+
+  ioutil.WriteFile(filename, []byte("{}\n\n{\"Features\": [\"x\"]}\n"), 0666)
+  _, err = neural.Collect(data.JSONLinesFile(filename))
+  var parseError *data.ParseError
+  if !errors.As(err, &parseError) || parseError.Line != 3 ||
+     !strings.Contains(err.Error(), filename) {
+    t.Errorf("error %v doesn't give line 3 of %v", err, filename)
+  }
+
+  // Sparse datapoints whose features are all 0 stay sparse.
+  written := []neural.Datapoint{
+    {Features: []float64{}, Indices: []int{}, Values: []float64{1}},
+    {Features: []float64{2}, Values: []float64{0}},
+  }
+  var lines []byte
+  for _, datapoint := range written {
+    line, err := json.Marshal(datapoint)
+    if err != nil {
+      t.Fatal(err)
+    }
+    lines = append(append(lines, line...), '\n')
+  }
+  ioutil.WriteFile(filename, lines, 0666)
+  datapoints, err = neural.Collect(data.JSONLinesFile(filename))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(datapoints, written) {
+    t.Errorf("read %+v back, expected %+v", datapoints, written)
+  }
+  if _, err := data.JSONLinesFile(filepath.Join(dir, "missing.jsonl")).Batches(
+      1); err == nil {
+    t.Errorf("read missing file")
+  }
+}
+
+func TestJSONLinesFiles(t *testing.T) {
+  dir, err := ioutil.TempDir("", "jsonl")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  for i, name := range []string{"a.jsonl", "b.jsonl", "c.txt"} {
+    ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat(
+        "{\"Values\": [" + string('0' + rune(i)) + "]}\n", 3)), 0666)
+  }
+  dataset, err := data.JSONLinesFiles(filepath.Join(dir, "*.jsonl"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  datapoints, err := neural.Collect(dataset)
+  if err != nil {
+    t.Fatal(err)
+  }
+  counts := map[float64]int{}
+  for _, datapoint := range datapoints {
+    counts[datapoint.Values[0]]++
+  }
+  if !reflect.DeepEqual(counts, map[float64]int{0: 3, 1: 3}) {
+    t.Errorf("read values %v from shards, expected 3 of 0 and of 1", counts)
+  }
+  if _, err := data.JSONLinesFiles(filepath.Join(dir, "*.csv")); err == nil {
+    t.Errorf("made a dataset of no files")
+  }
+}
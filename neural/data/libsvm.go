@@ -0,0 +1,121 @@
+package data
+
+import (
+  "bufio";
+  "fmt";
+  "io";
+  "os";
+  "strconv";
+  "strings";
+  "neural"
+)
+
+type LIBSVMOptions struct {
+  // Whether feature indices start at 0 rather than LIBSVM's usual 1.
+  ZeroBased bool
+}
+
+// Read sparse datapoints from lines of LIBSVM format such as
+// "1 3:0.5 10:1 # comment", a label followed by ascending index:value pairs of
+// nonzero features. Comma-separated labels give a value each and qid pairs
+// are ignored. Also returns the number of features, one more than the largest
+// index. If any lines don't parse, returns the datapoints of the other lines
+// with ParseErrors giving the line of each.
+func ReadLIBSVMDatapoints(r io.Reader, options LIBSVMOptions) (
+    []neural.Datapoint, int, error) {
+  reader := bufio.NewReader(r)
+  offset := 1
+  if options.ZeroBased {
+    offset = 0
+  }
+  var datapoints []neural.Datapoint
+  var parseErrors ParseErrors
+  features := 0
+  for line := 1; ; line++ {
+    text, err := reader.ReadString('\n')
+    if err != nil && err != io.EOF {
+      return nil, 0, err
+    }
+    if comment := strings.IndexByte(text, '#'); comment >= 0 {
+      text = text[:comment]
+    }
+    if fields := strings.Fields(text); len(fields) > 0 {
+      datapoint, parseError := parseLIBSVMLine(fields, offset)
+      if parseError != nil {
+        parseError.Line = line
+        parseErrors = append(parseErrors, parseError)
+      } else {
+        datapoints = append(datapoints, datapoint)
+        if n := len(datapoint.Indices); n > 0 &&
+           datapoint.Indices[n - 1] >= features {
+          features = datapoint.Indices[n - 1] + 1
+        }
+      }
+    }
+    if err == io.EOF {
+      break
+    }
+  }
+  if len(parseErrors) > 0 {
+    return datapoints, features, parseErrors
+  }
+  return datapoints, features, nil
+}
+
+// Parse the fields of a line, returning an error without its line.
+func parseLIBSVMLine(fields []string, offset int) (neural.Datapoint,
+                                                  *ParseError) {
+  datapoint := neural.Datapoint{Indices: []int{}, Features: []float64{}}
+  for _, label := range strings.Split(fields[0], ",") {
+    value, err := strconv.ParseFloat(label, 64)
+    if err != nil {
+      return datapoint, &ParseError{Column: "label", Err: err}
+    }
+    datapoint.Values = append(datapoint.Values, value)
+  }
+  for _, field := range fields[1:] {
+    colon := strings.IndexByte(field, ':')
+    if colon < 0 {
+      return datapoint, &ParseError{Err: fmt.Errorf("%q isn't index:value",
+                                                    field)}
+    }
+    if field[:colon] == "qid" {
+      continue
+    }
+    index, err := strconv.Atoi(field[:colon])
+    if err == nil && index < offset {
+      err = fmt.Errorf("index %v less than %v", index, offset)
+    }
+    if err != nil {
+      return datapoint, &ParseError{Column: field[:colon], Err: err}
+    }
+    index -= offset
+    if n := len(datapoint.Indices); n > 0 && index <= datapoint.Indices[n - 1] {
+      return datapoint, &ParseError{Column: field[:colon],
+                                    Err: fmt.Errorf("indices don't ascend")}
+    }
+    value, err := strconv.ParseFloat(field[colon + 1:], 64)
+    if err != nil {
+      return datapoint, &ParseError{Column: field[:colon], Err: err}
+    }
+    datapoint.Indices = append(datapoint.Indices, index)
+    datapoint.Features = append(datapoint.Features, value)
+  }
+  return datapoint, nil
+}
+
+// Read sparse datapoints from the LIBSVM file filename, like
+// ReadLIBSVMDatapoints.
+func ReadLIBSVMFile(filename string, options LIBSVMOptions) (
+    []neural.Datapoint, int, error) {
+  f, err := os.Open(filename)
+  if err != nil {
+    return nil, 0, err
+  }
+  defer f.Close()
+  datapoints, features, err := ReadLIBSVMDatapoints(f, options)
+  if err != nil {
+    return datapoints, features, fmt.Errorf("%v: %w", filename, err)
+  }
+  return datapoints, features, nil
+}
@@ -0,0 +1,81 @@
+package data_test
+
+import (
+  "errors";
+  "reflect";
+  "strings";
+  "testing"
+  "neural/data";
+)
+
+func TestReadLIBSVMDatapoints(t *testing.T) {
+  file := "1 3:0.5 10:1 # comment\n" +
+          "\n" +
+          "-1 qid:4 1:2\n" +
+          "# only a comment\n" +
+          "0,2\n" +
+          "1 2:3"
+  datapoints, features, err := data.ReadLIBSVMDatapoints(
+      strings.NewReader(file), data.LIBSVMOptions{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if features != 10 {
+    t.Errorf("%v features, expected 10", features)
+  }
+  expected := []struct {
+    indices []int
+    features []float64
+    values []float64
+  }{
+    {[]int{2, 9}, []float64{0.5, 1}, []float64{1}},
+    {[]int{0}, []float64{2}, []float64{-1}},
+    {[]int{}, []float64{}, []float64{0, 2}},
+    {[]int{1}, []float64{3}, []float64{1}},
+  }
+  if len(datapoints) != len(expected) {
+    t.Fatalf("read %v datapoints, expected %v", len(datapoints),
+             len(expected))
+  }
+  for i, datapoint := range datapoints {
+    if !reflect.DeepEqual(datapoint.Indices, expected[i].indices) ||
+       !reflect.DeepEqual(datapoint.Features, expected[i].features) ||
+       !reflect.DeepEqual(datapoint.Values, expected[i].values) {
+      t.Errorf("datapoint %v is %+v, expected %+v", i, datapoint, expected[i])
+    }
+  }
+
+  datapoints, features, err = data.ReadLIBSVMDatapoints(
+      strings.NewReader("1 0:1 4:2\n"), data.LIBSVMOptions{ZeroBased: true})
+  if err != nil || features != 5 ||
+     !reflect.DeepEqual(datapoints[0].Indices, []int{0, 4}) {
+    t.Errorf("read zero-based %+v with %v features (error %v)", datapoints,
+             features, err)
+  }
+}
+
+func TestReadLIBSVMDatapointsErrors(t *testing.T) {
+  file := "1 1:1\n" +
+          "x 1:1\n" +
+          "1 0:1\n" +
+          "1 3:1 2:1\n" +
+          "1 1:y\n" +
+          "1 1\n" +
+          "0 2:1\n"
+  datapoints, _, err := data.ReadLIBSVMDatapoints(strings.NewReader(file),
+                                                  data.LIBSVMOptions{})
+  var parseErrors data.ParseErrors
+  if !errors.As(err, &parseErrors) {
+    t.Fatalf("error %v isn't ParseErrors", err)
+  }
+  lines := []int{}
+  for _, parseError := range parseErrors {
+    lines = append(lines, parseError.Line)
+  }
+  if !reflect.DeepEqual(lines, []int{2, 3, 4, 5, 6}) {
+    t.Errorf("errors %v on lines %v, expected 2 to 6", err, lines)
+  }
+  if len(datapoints) != 2 {
+    t.Errorf("read good lines %+v, expected 2", datapoints)
+  }
+}
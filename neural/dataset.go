@@ -0,0 +1,210 @@
+package neural
+
+import (
+  "fmt";
+  "io";
+  "math/rand"
+)
+
+// A source of datapoints that may be too large to hold in memory, read a
+// batch at a time.
+type Dataset interface {
+  // Start a pass over the datapoints in batches of at most batchSize.
+  Batches(batchSize int) (BatchIterator, error)
+}
+
+type BatchIterator interface {
+  // Return the next batch, or io.EOF after the last. The batch is only valid
+  // until the next call.
+  Next() ([]Datapoint, error)
+  // Release any files held by the iterator.
+  Close() error
+}
+
+// In-memory datapoints, which every pass shuffles. Each batch is exactly the
+// batch size, so passes skip the last len % batchSize shuffled datapoints.
+type SliceDataset []Datapoint
+
+func (self SliceDataset) Batches(batchSize int) (BatchIterator, error) {
+  if batchSize <= 0 {
+    return nil, fmt.Errorf("neural: batch size %v", batchSize)
+  }
+  return &sliceIterator{self, rand.Perm(len(self)), 0,
+                        make([]Datapoint, batchSize)}, nil
+}
+
+type sliceIterator struct {
+  datapoints []Datapoint
+  perm []int
+  next int  // Index in perm of the next batch.
+  batch []Datapoint  // Reused by Next.
+}
+
+func (self *sliceIterator) Next() ([]Datapoint, error) {
+  batchSize := len(self.batch)
+  if self.next + batchSize > len(self.perm) {
+    return nil, io.EOF
+  }
+  for k := range self.batch {
+    self.batch[k] = self.datapoints[self.perm[self.next + k]]
+  }
+  self.next += batchSize
+  return self.batch, nil
+}
+
+func (self *sliceIterator) Close() error {
+  return nil
+}
+
+// Iterator over batches of the datapoints returned by next, such as those of a
+// file read as it goes.
+type StreamIterator struct {
+  // Return the next datapoint, or io.EOF after the last.
+  next func() (Datapoint, error)
+  close func() error
+  batch []Datapoint  // Reused by Next.
+}
+
+// Create an iterator over batches of at most batchSize datapoints returned by
+// next, calling close, if not nil, when closed.
+func NewStreamIterator(batchSize int, next func() (Datapoint, error),
+                       close func() error) *StreamIterator {
+  return &StreamIterator{next, close, make([]Datapoint, 0, batchSize)}
+}
+
+func (self *StreamIterator) Next() ([]Datapoint, error) {
+  self.batch = self.batch[:0]
+  for len(self.batch) < cap(self.batch) {
+    datapoint, err := self.next()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return nil, err
+    }
+    self.batch = append(self.batch, datapoint)
+  }
+  if len(self.batch) == 0 {
+    return nil, io.EOF
+  }
+  return self.batch, nil
+}
+
+func (self *StreamIterator) Close() error {
+  if self.close == nil {
+    return nil
+  }
+  return self.close()
+}
+
+// Datapoints split across datasets, such as files, which every pass visits in
+// a random order, one after another.
+type ShardedDataset []Dataset
+
+func (self ShardedDataset) Batches(batchSize int) (BatchIterator, error) {
+  perm := rand.Perm(len(self))
+  var shard BatchIterator
+  next := func() (Datapoint, error) {
+    for {
+      if shard == nil {
+        if len(perm) == 0 {
+          return Datapoint{}, io.EOF
+        }
+        var err error
+        if shard, err = self[perm[0]].Batches(1); err != nil {
+          return Datapoint{}, err
+        }
+        perm = perm[1:]
+      }
+      batch, err := shard.Next()
+      if err == io.EOF {
+        err = shard.Close()
+        shard = nil
+        if err != nil {
+          return Datapoint{}, err
+        }
+        continue
+      }
+      if err != nil {
+        return Datapoint{}, err
+      }
+      return batch[0], nil
+    }
+  }
+  close := func() error {
+    if shard == nil {
+      return nil
+    }
+    return shard.Close()
+  }
+  return NewStreamIterator(batchSize, next, close), nil
+}
+
+// Shuffles a dataset read in order, such as a file, by drawing each datapoint
+// at random from a buffer of the next Size datapoints. Larger buffers shuffle
+// better but take more memory.
+type ShuffleBuffer struct {
+  Dataset Dataset
+  Size int
+}
+
+func (self *ShuffleBuffer) Batches(batchSize int) (BatchIterator, error) {
+  if self.Size <= 0 {
+    return nil, fmt.Errorf("neural: shuffle buffer of size %v", self.Size)
+  }
+  source, err := self.Dataset.Batches(self.Size)
+  if err != nil {
+    return nil, err
+  }
+  buffer := make([]Datapoint, 0, self.Size)
+  // Datapoints of source's last batch not yet in buffer.
+  var pending []Datapoint
+  exhausted := false
+  next := func() (Datapoint, error) {
+    for !exhausted && len(buffer) < self.Size {
+      if len(pending) == 0 {
+        batch, err := source.Next()
+        if err == io.EOF {
+          exhausted = true
+          break
+        }
+        if err != nil {
+          return Datapoint{}, err
+        }
+        pending = batch
+      }
+      buffer = append(buffer, pending[0])
+      pending = pending[1:]
+    }
+    if len(buffer) == 0 {
+      return Datapoint{}, io.EOF
+    }
+    i := rand.Intn(len(buffer))
+    datapoint := buffer[i]
+    buffer[i] = buffer[len(buffer) - 1]
+    buffer = buffer[:len(buffer) - 1]
+    return datapoint, nil
+  }
+  return NewStreamIterator(batchSize, next, source.Close), nil
+}
+
+// Return the datapoints of one pass over dataset, in the order it gives them.
+func Collect(dataset Dataset) ([]Datapoint, error) {
+  const batchSize = 1024
+  batches, err := dataset.Batches(batchSize)
+  if err != nil {
+    return nil, err
+  }
+  defer batches.Close()
+  var datapoints []Datapoint
+  for {
+    batch, err := batches.Next()
+    if err == io.EOF {
+      return datapoints, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    datapoints = append(datapoints, batch...)
+  }
+}
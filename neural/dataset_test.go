@@ -0,0 +1,271 @@
+package neural_test
+
+import (
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "io";
+  "math/rand";
+  "sort";
+  "testing"
+  "neural";
+)
+
+// Streams datapoints whose single feature counts from start to end - 1.
+type countingDataset struct {
+  start, end int
+}
+
+func (self countingDataset) Batches(batchSize int) (neural.BatchIterator,
+                                                    error) {
+  i := self.start
+  next := func() (neural.Datapoint, error) {
+    if i == self.end {
+      return neural.Datapoint{}, io.EOF
+    }
+    i++
+    return neural.Datapoint{Features: []float64{float64(i - 1)}}, nil
+  }
+  return neural.NewStreamIterator(batchSize, next, nil), nil
+}
+
+// Return the features of every datapoint of a pass over dataset, checking
+// batch sizes.
+func collectFeatures(t *testing.T, dataset neural.Dataset,
+                     batchSize int) []int {
+  iterator, err := dataset.Batches(batchSize)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer iterator.Close()
+  features := []int{}
+  for {
+    batch, err := iterator.Next()
+    if err == io.EOF {
+      return features
+    }
+    if err != nil {
+      t.Fatal(err)
+    }
+    if len(batch) == 0 || len(batch) > batchSize {
+      t.Errorf("batch of %v datapoints, expected at most %v", len(batch),
+               batchSize)
+    }
+    for _, datapoint := range batch {
+      features = append(features, int(datapoint.Features[0]))
+    }
+  }
+}
+
+// Whether features are each of start to end - 1 exactly once.
+func isRange(features []int, start, end int) bool {
+  sorted := append([]int{}, features...)
+  sort.Ints(sorted)
+  if len(sorted) != end - start {
+    return false
+  }
+  for i, feature := range sorted {
+    if feature != start + i {
+      return false
+    }
+  }
+  return true
+}
+
+func TestSliceDataset(t *testing.T) {
+  datapoints, err := neural.Collect(countingDataset{0, 10})
+  if err != nil {
+    t.Fatal(err)
+  }
+  dataset := neural.SliceDataset(datapoints)
+  features := collectFeatures(t, dataset, 3)
+  if len(features) != 9 {
+    t.Errorf("pass read %v, expected 3 full batches", features)
+  }
+  sorted := append([]int{}, features...)
+  sort.Ints(sorted)
+  for i := 1; i < len(sorted); i++ {
+    if sorted[i] == sorted[i - 1] {
+      t.Errorf("pass read %v twice in %v", sorted[i], features)
+    }
+  }
+  if _, err := dataset.Batches(0); err == nil {
+    t.Errorf("made batches of size 0")
+  }
+}
+
+func TestShardedDataset(t *testing.T) {
+  rand.Seed(1)
+  dataset := neural.ShardedDataset{
+    countingDataset{0, 5}, countingDataset{5, 6}, countingDataset{6, 13},
+  }
+  features := collectFeatures(t, dataset, 4)
+  if !isRange(features, 0, 13) {
+    t.Errorf("pass read %v, expected 0 to 12", features)
+  }
+  // Each shard is read in order.
+  for i := 1; i < len(features); i++ {
+    if features[i - 1] + 1 != features[i] && features[i] != 0 &&
+       features[i] != 5 && features[i] != 6 {
+      t.Errorf("shards not read in order in %v", features)
+    }
+  }
+}
+
+func TestShuffleBuffer(t *testing.T) {
+  rand.Seed(1)
+  buffer := &neural.ShuffleBuffer{Dataset: countingDataset{0, 100}, Size: 10}
+  features := collectFeatures(t, buffer, 7)
+  if !isRange(features, 0, 100) {
+    t.Errorf("pass read %v, expected 0 to 99", features)
+  }
+  shuffled := false
+  for i, feature := range features {
+    if feature != i {
+      shuffled = true
+    }
+    // Nothing moves earlier than the buffer allows.
+    if feature > i + 9 {
+      t.Errorf("read %v at %v with a buffer of 10", feature, i)
+    }
+  }
+  if !shuffled {
+    t.Errorf("buffer didn't shuffle %v", features)
+  }
+  empty := &neural.ShuffleBuffer{Dataset: countingDataset{0, 1}}
+  if _, err := empty.Batches(1); err == nil {
+    t.Errorf("made an empty shuffle buffer")
+  }
+}
+
+// Streams copies of datapoint, once per pass.
+type repeatedDataset struct {
+  datapoint neural.Datapoint
+  count int
+}
+
+func (self repeatedDataset) Batches(batchSize int) (neural.BatchIterator,
+                                                    error) {
+  read := 0
+  next := func() (neural.Datapoint, error) {
+    if read == self.count {
+      return neural.Datapoint{}, io.EOF
+    }
+    read++
+    return self.datapoint, nil
+  }
+  return neural.NewStreamIterator(batchSize, next, nil), nil
+}
+
+func TestTrainDataset(t *testing.T) {
+  datapoint := neural.Datapoint{Features: []float64{0.05, 0.10},
+                                Values: []float64{0.01, 0.99}}
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(20),
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(2),
+      ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+      BalanceClasses: proto.Bool(true),
+  }
+  neuralNetwork := CreateSimpleNetwork(t)
+  datapoints := []neural.Datapoint{datapoint}
+  before, err := neural.Loss(*neuralNetwork, datapoints, learningConfiguration)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // Passes end with a partial batch.
+  if err := neural.TrainDataset(neuralNetwork, repeatedDataset{datapoint, 5},
+                                learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  after, err := neural.Loss(*neuralNetwork, datapoints, learningConfiguration)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if after > before / 2 {
+    t.Errorf("loss %v after streaming training, %v before", after, before)
+  }
+
+  learningConfiguration.BatchSize = proto.Int32(0)
+  if err := neural.TrainDataset(neuralNetwork, repeatedDataset{datapoint, 5},
+                                learningConfiguration); err == nil {
+    t.Errorf("trained a stream without a batch size")
+  }
+}
+
+// Streams datapoints in order.
+type streamedDataset []neural.Datapoint
+
+func (self streamedDataset) Batches(batchSize int) (neural.BatchIterator,
+                                                    error) {
+  read := 0
+  next := func() (neural.Datapoint, error) {
+    if read == len(self) {
+      return neural.Datapoint{}, io.EOF
+    }
+    read++
+    return self[read - 1], nil
+  }
+  return neural.NewStreamIterator(batchSize, next, nil), nil
+}
+
+// Network of 2 features with a single LOGISTIC output.
+func createLogisticNetwork() *neural.Network {
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(2),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LOGISTIC.Enum(),
+        Outputs: proto.Int32(1),
+      },
+    },
+  })
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+func TestTrainDatasetClassWeights(t *testing.T) {
+  datapoints := []neural.Datapoint{
+    {Features: []float64{0.1, 0.2}, Values: []float64{0}},
+    {Features: []float64{0.3, 0.1}, Values: []float64{0}},
+    {Features: []float64{0.5, 0.9}, Values: []float64{0}, Weight: 2},
+    {Features: []float64{0.7, 0.4}, Values: []float64{1}},
+  }
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(1),
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(int32(len(datapoints))),
+      ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+  train := func(dataset neural.Dataset, balance bool) *neural.Network {
+    rand.Seed(1)
+    neuralNetwork := createLogisticNetwork()
+    learningConfiguration.BalanceClasses = proto.Bool(balance)
+    if err := neural.TrainDataset(neuralNetwork, dataset,
+                                  learningConfiguration); err != nil {
+      t.Fatal(err)
+    }
+    return neuralNetwork
+  }
+  // Streamed datapoints are counted a batch at a time.
+  sliceWeight := train(neural.SliceDataset(datapoints), true).Layers[0].Weight
+  streamedWeight := train(streamedDataset(datapoints), true).Layers[0].Weight
+  if !mat64.EqualApprox(streamedWeight, sliceWeight, 1e-12) {
+    t.Errorf("balanced training on a stream gave %v, expected %v",
+             streamedWeight, sliceWeight)
+  }
+  unbalancedWeight :=
+      train(streamedDataset(datapoints), false).Layers[0].Weight
+  if mat64.EqualApprox(streamedWeight, unbalancedWeight, 1e-6) {
+    t.Errorf("balanced training gave the unbalanced weights %v",
+             unbalancedWeight)
+  }
+
+  datapoints[0].Features = nil
+  neuralNetwork := createLogisticNetwork()
+  if err := neural.TrainDataset(neuralNetwork, streamedDataset(datapoints),
+                                learningConfiguration); err == nil {
+    t.Errorf("trained on a dense datapoint without features")
+  }
+}
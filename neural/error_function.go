@@ -5,34 +5,81 @@ package neural
 
 import (
   "github.com/gonum/matrix/mat64";
-  // "math"
+  "math"
 )
 
+// Keep logarithms in cross entropy finite when outputs saturate.
+const crossEntropyEpsilon = 1e-15
+
 type ErrorFunction interface {
+  // Total cost over every example (row) of values and outputs.
   Cost(values mat64.Matrix, outputs mat64.Matrix) float64
+  // Derivative of Cost with respect to each output, examples x outputs. The
+  // result is only valid until the next call.
   Deltas(values mat64.Matrix, outputs mat64.Matrix) mat64.Matrix
 }
 
 type QuadraticErrorFunction struct {
+  deltas mat64.Dense  // Reused by Deltas.
 }
 func (m* QuadraticErrorFunction) Cost(
     values mat64.Matrix, outputs mat64.Matrix) float64 {
-  return 0
+  cost := 0.0
+  r, c := outputs.Dims()
+  for i := 0; i < r; i++ {
+    for j := 0; j < c; j++ {
+      diff := outputs.At(i, j) - values.At(i, j)
+      cost += 0.5 * diff * diff
+    }
+  }
+  return cost
 }
 func (m* QuadraticErrorFunction) Deltas(
     values mat64.Matrix, outputs mat64.Matrix) mat64.Matrix {
-  return outputs
+  resizeDeltas(&m.deltas, outputs)
+  m.deltas.Sub(outputs, values)
+  return &m.deltas
 }
 
 type CrossEntropyErrorFunction struct {
+  deltas mat64.Dense  // Reused by Deltas.
 }
 func (m* CrossEntropyErrorFunction) Cost(
     values mat64.Matrix, outputs mat64.Matrix) float64 {
-  return 0
+  cost := 0.0
+  r, c := outputs.Dims()
+  for i := 0; i < r; i++ {
+    for j := 0; j < c; j++ {
+      output := clampProbability(outputs.At(i, j))
+      value := values.At(i, j)
+      cost -= value * math.Log(output) + (1 - value) * math.Log(1 - output)
+    }
+  }
+  return cost
 }
 func (m* CrossEntropyErrorFunction) Deltas(
     values mat64.Matrix, outputs mat64.Matrix) mat64.Matrix {
-  return outputs
+  resizeDeltas(&m.deltas, outputs)
+  r, c := outputs.Dims()
+  for i := 0; i < r; i++ {
+    for j := 0; j < c; j++ {
+      output := clampProbability(outputs.At(i, j))
+      m.deltas.Set(i, j, (output - values.At(i, j)) / (output * (1 - output)))
+    }
+  }
+  return &m.deltas
+}
+
+// Size deltas like outputs, keeping its storage when the size already matches.
+func resizeDeltas(deltas *mat64.Dense, outputs mat64.Matrix) {
+  r, c := outputs.Dims()
+  if rows, cols := deltas.Dims(); rows != r || cols != c {
+    *deltas = *mat64.NewDense(r, c, nil)
+  }
+}
+
+func clampProbability(p float64) float64 {
+  return math.Max(crossEntropyEpsilon, math.Min(1 - crossEntropyEpsilon, p))
 }
 
 func NewErrorFunction(name ErrorName) ErrorFunction {
@@ -44,4 +91,3 @@ func NewErrorFunction(name ErrorName) ErrorFunction {
   }
   return nil
 }
-
@@ -0,0 +1,143 @@
+package neural
+
+import (
+  "fmt";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math/rand"
+)
+
+// Return examples x size noise drawn from the standard normal distribution.
+func Noise(examples, size int) *mat64.Dense {
+  noise := mat64.NewDense(examples, size, nil)
+  noise.Apply(func(i, j int, v float64) float64 {
+    return rand.NormFloat64()
+  }, noise)
+  return noise
+}
+
+// Return examples generated by generator from random noise.
+func Generate(generator *Network, examples int) [][]float64 {
+  generator.Forward(Noise(examples, generator.Layers[0].Inputs()))
+  output := generator.Layers[len(generator.Layers) - 1].Output
+  generated := make([][]float64, examples)
+  for i := range generated {
+    generated[i] = append([]float64(nil), output.RawRowView(i)...)
+  }
+  return generated
+}
+
+// Train generator, whose inputs are noise, to produce examples that
+// discriminator can't tell from the features of datapoints, while training
+// discriminator's single LOGISTIC output to give the probability that its
+// input is real. Both use the cross entropy of discriminator's output, and
+// the generator's loss is non-saturating unless learningConfiguration says
+// minimax. Weights are checked after each generator update, when ROLLBACK
+// restores both networks. snapshot, if not nil, is called after every epoch.
+func TrainAdversarial(generator, discriminator *Network,
+                      datapoints []Datapoint,
+                      learningConfiguration LearningConfiguration,
+                      snapshot func(epoch int)) error {
+  last := generator.Layers[len(generator.Layers) - 1]
+  if last.Outputs() != discriminator.Layers[0].Inputs() {
+    return fmt.Errorf("neural: generator has %v outputs but discriminator " +
+                      "has %v inputs", last.Outputs(),
+                      discriminator.Layers[0].Inputs())
+  }
+  if outputs := discriminator.Layers[len(discriminator.Layers) - 1].Outputs();
+     outputs != 1 {
+    return fmt.Errorf("neural: discriminator has %v outputs, not 1", outputs)
+  }
+  batchSize := int(learningConfiguration.GetBatchSize())
+  if batchSize == 0 {
+    batchSize = len(datapoints)
+  }
+  if batchSize <= 0 {
+    return fmt.Errorf("neural: batch size %v of %v datapoints", batchSize,
+                      len(datapoints))
+  }
+  discriminatorSteps := int(learningConfiguration.GetDiscriminatorSteps())
+  if discriminatorSteps < 1 {
+    return fmt.Errorf("neural: %v discriminator steps, not at least 1",
+                      discriminatorSteps)
+  }
+  layers := append(append([]*Layer(nil), generator.Layers...),
+                   discriminator.Layers...)
+  nonFiniteAction := learningConfiguration.GetNonFiniteAction()
+  var lastFiniteWeights []*mat64.Dense
+  if nonFiniteAction == NonFiniteAction_ROLLBACK {
+    lastFiniteWeights = copyWeights(layers, nil)
+  }
+  errorFunction := new(CrossEntropyErrorFunction)
+  // Real examples followed by generated examples.
+  features := mat64.NewDense(2 * batchSize, last.Outputs(), nil)
+  labels := mat64.NewDense(2 * batchSize, 1, nil)
+  for k := 0; k < batchSize; k++ {
+    labels.Set(k, 0, 1)
+  }
+  // The generator's targets for its generated examples.
+  targets := mat64.NewDense(batchSize, 1, nil)
+  targetWeights := make([]float64, batchSize)
+  for k := range targetWeights {
+    if learningConfiguration.GetMinimax() {
+      // Ascend the discriminator's cross entropy on generated examples.
+      targetWeights[k] = -1
+    } else {
+      targets.Set(k, 0, 1)
+      targetWeights[k] = 1
+    }
+  }
+  batches := 0
+  for i := 0; i < int(learningConfiguration.GetEpochs()); i++ {
+    perm := rand.Perm(len(datapoints))
+    for j := 0; j <= len(perm) - batchSize; j += batchSize {
+      generator.Forward(Noise(batchSize, generator.Layers[0].Inputs()))
+      for k := 0; k < batchSize; k++ {
+        features.SetRow(k, datapoints[perm[j + k]].Features)
+        features.SetRow(batchSize + k, last.Output.RawRowView(k))
+      }
+      discriminator.Forward(features)
+      discriminator.Backward(labels, errorFunction)
+      discriminator.Update(learningConfiguration)
+      batches++
+      if batches % discriminatorSteps != 0 {
+        continue
+      }
+      // The discriminator's gradients are discarded, only its deltas reach
+      // the generator.
+      generator.Forward(Noise(batchSize, generator.Layers[0].Inputs()))
+      discriminator.Forward(last.Output)
+      discriminator.BackwardWeighted(targets, targetWeights, errorFunction)
+      // Negative minimax weights don't apply to any KL divergence.
+      generator.backwardLayers(len(generator.Layers), discriminator.Layers[0],
+                               nil)
+      generator.Update(learningConfiguration)
+      if nonFiniteAction == NonFiniteAction_IGNORE {
+        continue
+      }
+      err := checkFinite(discriminator, 0)
+      if err != nil {
+        err = fmt.Errorf("%v of the discriminator", err)
+      } else if err = checkFinite(generator, 0); err != nil {
+        err = fmt.Errorf("%v of the generator", err)
+      }
+      if err == nil {
+        if nonFiniteAction == NonFiniteAction_ROLLBACK {
+          copyWeights(layers, lastFiniteWeights)
+        }
+        continue
+      }
+      if nonFiniteAction == NonFiniteAction_ABORT {
+        return fmt.Errorf("%v in epoch %v batch %v", err, i, j / batchSize)
+      }
+      restoreWeights(layers, lastFiniteWeights)
+      learningConfiguration.Rate = proto.Float64(
+          *learningConfiguration.Rate *
+          learningConfiguration.GetRollbackRateScale())
+    }
+    if snapshot != nil {
+      snapshot(i)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,162 @@
+package neural_test
+
+import (
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "strings";
+  "testing"
+  "neural";
+)
+
+// Generator of 1 feature from 1 noise input, and a discriminator of 1
+// feature.
+func createAdversarialNetworks() (*neural.Network, *neural.Network) {
+  generator := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(1),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_TANH.Enum(),
+        Outputs: proto.Int32(8),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LINEAR.Enum(),
+        Outputs: proto.Int32(1),
+      },
+    },
+  })
+  generator.RandomizeSynapses()
+  discriminator := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(1),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_TANH.Enum(),
+        Outputs: proto.Int32(8),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LOGISTIC.Enum(),
+        Outputs: proto.Int32(1),
+      },
+    },
+  })
+  discriminator.RandomizeSynapses()
+  return generator, discriminator
+}
+
+func TestTrainAdversarial(t *testing.T) {
+  datapoints := make([]neural.Datapoint, 200)
+  for _, minimax := range []bool{false, true} {
+    rand.Seed(1)
+    for i := range datapoints {
+      datapoints[i].Features = []float64{3 + 0.5 * rand.NormFloat64()}
+    }
+    generator, discriminator := createAdversarialNetworks()
+    learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(20),
+      Rate: proto.Float64(0.01),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(10),
+      ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+      Minimax: proto.Bool(minimax),
+    }
+    snapshots := 0
+    err := neural.TrainAdversarial(
+        generator, discriminator, datapoints, learningConfiguration,
+        func(epoch int) {
+          if epoch != snapshots {
+            t.Errorf("snapshot of epoch %v, expected %v", epoch, snapshots)
+          }
+          snapshots++
+        })
+    if err != nil {
+      t.Fatal(err)
+    }
+    if snapshots != 20 {
+      t.Errorf("%v snapshots, expected 20", snapshots)
+    }
+    generated := neural.Generate(generator, 500)
+    mean := 0.0
+    for _, example := range generated {
+      mean += example[0] / float64(len(generated))
+    }
+    if math.Abs(mean - 3) > 0.5 {
+      t.Errorf("minimax %v: generated mean %v, expected about 3", minimax,
+               mean)
+    }
+  }
+}
+
+func TestTrainAdversarialErrors(t *testing.T) {
+  generator, discriminator := createAdversarialNetworks()
+  datapoints := []neural.Datapoint{{Features: []float64{1}}}
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.01),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+    DiscriminatorSteps: proto.Int32(0),
+  }
+  if err := neural.TrainAdversarial(generator, discriminator, datapoints,
+                                    learningConfiguration, nil); err == nil {
+    t.Errorf("trained with 0 discriminator steps")
+  }
+  learningConfiguration.DiscriminatorSteps = proto.Int32(1)
+  learningConfiguration.BatchSize = proto.Int32(0)
+  if err := neural.TrainAdversarial(generator, discriminator, nil,
+                                    learningConfiguration, nil); err == nil {
+    t.Errorf("trained in full batches of no datapoints")
+  }
+}
+
+func TestTrainAdversarialNonFinite(t *testing.T) {
+  rand.Seed(1)
+  generator, discriminator := createAdversarialNetworks()
+  before := mat64.DenseCopyOf(generator.Layers[0].Weight)
+  datapoints := []neural.Datapoint{{Features: []float64{1}}}
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(math.Inf(1)),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(1),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+    NonFiniteAction: neural.NonFiniteAction_ROLLBACK.Enum(),
+  }
+  if err := neural.TrainAdversarial(generator, discriminator, datapoints,
+                                    learningConfiguration, nil); err != nil {
+    t.Fatal(err)
+  }
+  if !mat64.Equal(generator.Layers[0].Weight, before) {
+    t.Errorf("generator weights not rolled back")
+  }
+  learningConfiguration.NonFiniteAction = neural.NonFiniteAction_ABORT.Enum()
+  if err := neural.TrainAdversarial(generator, discriminator, datapoints,
+                                    learningConfiguration, nil);
+     err == nil || !strings.Contains(err.Error(), "discriminator") {
+    t.Errorf("expected error naming the discriminator, got %v", err)
+  }
+}
+
+func TestTrainAdversarialShapes(t *testing.T) {
+  generator, discriminator := createAdversarialNetworks()
+  datapoints := []neural.Datapoint{{Features: []float64{1}}}
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.01),
+  }
+  generator.Layers[1] = neural.NewLayer(neural.ActivationName_LINEAR, 8, 2,
+                                        nil)
+  if err := neural.TrainAdversarial(generator, discriminator, datapoints,
+                                    learningConfiguration, nil); err == nil {
+    t.Errorf("trained a generator of 2 outputs with a discriminator of 1 " +
+             "input")
+  }
+  generator, discriminator = createAdversarialNetworks()
+  discriminator.Layers[1] = neural.NewLayer(neural.ActivationName_SOFTMAX, 8,
+                                            2, nil)
+  if err := neural.TrainAdversarial(generator, discriminator, datapoints,
+                                    learningConfiguration, nil); err == nil {
+    t.Errorf("trained a discriminator of 2 outputs")
+  }
+}
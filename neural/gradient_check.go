@@ -0,0 +1,60 @@
+package neural
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "math"
+)
+
+// Step used for central finite differences.
+const gradientCheckStep = 1e-5
+// Smallest denominator used when computing relative error, so that weights
+// with near-zero gradients don't report spurious errors.
+const gradientCheckFloor = 1e-6
+
+// Compare the analytic gradients computed into each layer's Gradient by
+// Backward against central finite differences of errorFunction's cost for
+// every weight (including biases) of neuralNetwork on datapoints. Returns the
+// maximum relative error per layer. The network's weights are left unchanged.
+func GradientCheck(neuralNetwork *Network, datapoints []Datapoint,
+                   errorFunction ErrorFunction) []float64 {
+  features := mat64.NewDense(
+      len(datapoints), len(datapoints[0].Features), nil)
+  values := mat64.NewDense(len(datapoints), len(datapoints[0].Values), nil)
+  for i, datapoint := range datapoints {
+    features.SetRow(i, datapoint.Features)
+    values.SetRow(i, datapoint.Values)
+  }
+
+  neuralNetwork.Forward(features)
+  neuralNetwork.Backward(values, errorFunction)
+
+  cost := func() float64 {
+    neuralNetwork.Forward(features)
+    output := neuralNetwork.Layers[len(neuralNetwork.Layers) - 1].Output
+    return errorFunction.Cost(values, output)
+  }
+  maxErrors := make([]float64, len(neuralNetwork.Layers))
+  for l, layer := range neuralNetwork.Layers {
+    weights, gradients := layer.Weights(), layer.Gradients()
+    rows, cols := weights.Dims()
+    for i := 0; i < rows; i++ {
+      for j := 0; j < cols; j++ {
+        weight := weights.At(i, j)
+        weights.Set(i, j, weight + gradientCheckStep)
+        costPlus := cost()
+        weights.Set(i, j, weight - gradientCheckStep)
+        costMinus := cost()
+        weights.Set(i, j, weight)
+        numerical := (costPlus - costMinus) / (2 * gradientCheckStep)
+        analytic := gradients.At(i, j)
+        relativeError := math.Abs(analytic - numerical) / math.Max(
+            math.Abs(analytic) + math.Abs(numerical), gradientCheckFloor)
+        // Propagate NaNs rather than letting math.Max hide them.
+        if math.IsNaN(relativeError) || relativeError > maxErrors[l] {
+          maxErrors[l] = relativeError
+        }
+      }
+    }
+  }
+  return maxErrors
+}
@@ -0,0 +1,78 @@
+package neural_test
+
+import (
+  "fmt";
+  "github.com/golang/protobuf/proto";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+func TestGradientCheck(t *testing.T) {
+  activations := []neural.ActivationName{
+      neural.ActivationName_LINEAR,
+      neural.ActivationName_RELU,
+      neural.ActivationName_LOGISTIC,
+      neural.ActivationName_TANH,
+      neural.ActivationName_SOFTMAX,
+  }
+  // Cross entropy is only defined for outputs in (0, 1).
+  errorOutputs := map[neural.ErrorName][]neural.ActivationName{
+      neural.ErrorName_QUADRATIC: activations,
+      neural.ErrorName_CROSS_ENTROPY: []neural.ActivationName{
+          neural.ActivationName_LOGISTIC,
+          neural.ActivationName_SOFTMAX,
+      },
+  }
+  type testCase struct {
+    hidden neural.ActivationName
+    output neural.ActivationName
+    errorName neural.ErrorName
+  }
+  var testCases []testCase
+  for errorName, outputs := range errorOutputs {
+    for _, hidden := range activations {
+      for _, output := range outputs {
+        testCases = append(testCases, testCase{hidden, output, errorName})
+      }
+    }
+  }
+
+  for _, tc := range testCases {
+    name := fmt.Sprintf("%v/%v/%v", tc.hidden, tc.output, tc.errorName)
+    rand.Seed(1)
+    neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+        Inputs: proto.Int32(3),
+        Layer: []*neural.LayerConfiguration{
+            &neural.LayerConfiguration{
+                Name: tc.hidden.Enum(),
+                Outputs: proto.Int32(4),
+            },
+            &neural.LayerConfiguration{
+                Name: tc.output.Enum(),
+                Outputs: proto.Int32(3),
+            },
+        },
+    })
+    neuralNetwork.RandomizeSynapses()
+    datapoints := make([]neural.Datapoint, 5)
+    for i := range datapoints {
+      for j := 0; j < 3; j++ {
+        datapoints[i].Features = append(
+            datapoints[i].Features, rand.NormFloat64())
+        datapoints[i].Values = append(datapoints[i].Values, rand.Float64())
+      }
+    }
+    maxErrors := neural.GradientCheck(
+        neuralNetwork, datapoints, neural.NewErrorFunction(tc.errorName))
+    if len(maxErrors) != 2 {
+      t.Fatalf("%v: got %v layer errors, expected 2", name, len(maxErrors))
+    }
+    for i, maxError := range maxErrors {
+      if !(maxError < 1e-4) {
+        t.Errorf("%v: layer %v relative error %v too large", name, i,
+                 maxError)
+      }
+    }
+  }
+}
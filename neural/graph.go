@@ -0,0 +1,588 @@
+package neural
+
+import (
+  "encoding/json";
+  "fmt";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math"
+)
+
+// Network whose layers form a directed acyclic graph, with any number of named
+// inputs and of output heads that are trained together.
+type Graph struct {
+  // Every node, each after the nodes it consumes.
+  Nodes []*Node
+  Heads []*Head
+
+  nodes map[string]*Node
+}
+
+type Node struct {
+  Name string
+  Type NodeType
+  // Names of the nodes whose outputs this node consumes.
+  Inputs []string
+  // Number of outputs per example.
+  Size int
+  // Only for LAYER nodes.
+  Layer *Layer
+  Output *mat64.Dense  // examples x Size
+  // Gradient of the total cost with respect to Output, examples x Size. Not
+  // computed for INPUT nodes.
+  Deltas *mat64.Dense
+
+  sources []*Node
+  inputDeltas *mat64.Dense  // LAYER nodes' input gradient, inputs x examples
+}
+
+type Head struct {
+  // Name of the node whose output this head predicts.
+  Node string
+  ErrorName ErrorName
+  ErrorFunction ErrorFunction
+  // Multiplier on this head's cost in the total cost.
+  LossWeight float64
+
+  node *Node
+}
+
+// Inputs and values of one example for a Graph, by node name.
+type GraphDatapoint struct {
+  // Features of each INPUT node.
+  Features map[string][]float64
+  // Values of each head's node.
+  Values map[string][]float64
+  // Relative importance of this example in training. Zero (the default) is
+  // treated as 1, as for Datapoint.Weight.
+  Weight float64
+}
+
+func NewGraph(networkConfiguration NetworkConfiguration) (*Graph, error) {
+  graph := new(Graph)
+  if err := graph.init(networkConfiguration); err != nil {
+    return nil, err
+  }
+  return graph, nil
+}
+
+// The node called name, or nil if there is none.
+func (self *Graph) Node(name string) *Node {
+  return self.nodes[name]
+}
+
+// Layer of every LAYER node, in order.
+func (self *Graph) Layers() []*Layer {
+  var layers []*Layer
+  for _, node := range self.Nodes {
+    if node.Layer != nil {
+      layers = append(layers, node.Layer)
+    }
+  }
+  return layers
+}
+
+func (self *Graph) RandomizeSynapses() {
+  for _, layer := range self.Layers() {
+    layer.randomizeWeights()
+  }
+}
+
+// Compute every node's output from inputs, examples x size, for each INPUT
+// node. Returns an error, computing nothing, if an INPUT node's input is
+// missing or mis-sized.
+func (self *Graph) Forward(inputs map[string]*mat64.Dense) error {
+  examples := -1
+  for _, node := range self.Nodes {
+    if node.Type != NodeType_INPUT {
+      continue
+    }
+    input, ok := inputs[node.Name]
+    if !ok {
+      return fmt.Errorf("neural: no input for node %v", node.Name)
+    }
+    rows, cols := input.Dims()
+    if cols != node.Size {
+      return fmt.Errorf("neural: %v inputs for node %v of size %v", cols,
+                        node.Name, node.Size)
+    }
+    if examples >= 0 && rows != examples {
+      return fmt.Errorf("neural: %v examples for node %v, but %v for others",
+                        rows, node.Name, examples)
+    }
+    examples = rows
+  }
+  for _, node := range self.Nodes {
+    switch node.Type {
+    case NodeType_INPUT:
+      node.Output = inputs[node.Name]
+    case NodeType_LAYER:
+      node.Layer.forward(node.sources[0].Output)
+      node.Output = node.Layer.Output
+    case NodeType_CONCAT:
+      examples, _ := node.sources[0].Output.Dims()
+      node.Output = resizeDense(node.Output, examples, node.Size)
+      for i := 0; i < examples; i++ {
+        row := node.Output.RawRowView(i)
+        for _, source := range node.sources {
+          row = row[copy(row, source.Output.RawRowView(i)):]
+        }
+      }
+    case NodeType_ADD:
+      examples, _ := node.sources[0].Output.Dims()
+      node.Output = resizeDense(node.Output, examples, node.Size)
+      node.Output.Copy(node.sources[0].Output)
+      for _, source := range node.sources[1:] {
+        node.Output.Add(node.Output, source.Output)
+      }
+    }
+  }
+  return nil
+}
+
+// Output of the node called name from the last Forward.
+func (self *Graph) Output(name string) *mat64.Dense {
+  return self.nodes[name].Output
+}
+
+// Compute every layer's Gradient of the total weighted cost of the heads given
+// values, examples x size, for each head's node. Scales each example's
+// contribution by weights unless nil.
+func (self *Graph) Backward(values map[string]*mat64.Dense,
+                            weights []float64) {
+  for _, node := range self.Nodes {
+    if node.Type != NodeType_INPUT {
+      examples, _ := node.Output.Dims()
+      node.Deltas = resizeDense(node.Deltas, examples, node.Size)
+      for k := 0; k < examples; k++ {
+        row := node.Deltas.RawRowView(k)
+        for j := range row {
+          row[j] = 0
+        }
+      }
+    }
+  }
+  for _, head := range self.Heads {
+    deltas := head.ErrorFunction.Deltas(values[head.Node], head.node.Output)
+    examples, outputs := deltas.Dims()
+    for k := 0; k < examples; k++ {
+      weight := head.LossWeight
+      if weights != nil {
+        weight *= weights[k]
+      }
+      row := head.node.Deltas.RawRowView(k)
+      for j := 0; j < outputs; j++ {
+        row[j] += weight * deltas.At(k, j)
+      }
+    }
+  }
+  for i := len(self.Nodes) - 1; i >= 0; i-- {
+    node := self.Nodes[i]
+    switch node.Type {
+    case NodeType_LAYER:
+      node.backwardLayer()
+    case NodeType_CONCAT:
+      examples, _ := node.Deltas.Dims()
+      for k := 0; k < examples; k++ {
+        row := node.Deltas.RawRowView(k)
+        for _, source := range node.sources {
+          if source.Type != NodeType_INPUT {
+            addTo(source.Deltas.RawRowView(k), row[:source.Size])
+          }
+          row = row[source.Size:]
+        }
+      }
+    case NodeType_ADD:
+      for _, source := range node.sources {
+        if source.Type != NodeType_INPUT {
+          source.Deltas.Add(source.Deltas, node.Deltas)
+        }
+      }
+    }
+  }
+}
+
+// Backpropagate Deltas through a LAYER node into its source's Deltas.
+func (self *Node) backwardLayer() {
+  layer := self.Layer
+  examples, _ := self.Deltas.Dims()
+  for k := 0; k < examples; k++ {
+    for j, delta := range self.Deltas.RawRowView(k) {
+      layer.Deltas.Set(j, k, delta)
+    }
+  }
+  layer.backwardDeltas()
+  source := self.sources[0]
+  if source.Type == NodeType_INPUT {
+    return
+  }
+  self.inputDeltas = resizeDense(self.inputDeltas, source.Size, examples)
+  layer.inputDeltas(self.inputDeltas)
+  for k := 0; k < examples; k++ {
+    row := source.Deltas.RawRowView(k)
+    for j := range row {
+      row[j] += self.inputDeltas.At(j, k)
+    }
+  }
+}
+
+// Total weighted cost of the heads given values from the last Forward.
+func (self *Graph) Cost(values map[string]*mat64.Dense) float64 {
+  cost := 0.0
+  for _, head := range self.Heads {
+    cost += head.LossWeight * head.ErrorFunction.Cost(
+        values[head.Node], head.node.Output)
+  }
+  return cost
+}
+
+// Sum each layer's Gradient from the current batch into the gradients applied
+// by the next Update, without changing any weights.
+func (self *Graph) Accumulate() {
+  accumulateLayers(self.Layers())
+}
+
+func (self *Graph) Update(learningConfiguration LearningConfiguration) {
+  updateLayers(self.Layers(), learningConfiguration)
+}
+
+// Total regularization penalty over every layer, with L2 weight decay decay.
+func (self *Graph) Penalty(decay float64) float64 {
+  penalty := 0.0
+  for _, layer := range self.Layers() {
+    penalty += layer.Penalty(decay)
+  }
+  return penalty
+}
+
+// Return a copy of each head's output for one example, by node name, or an
+// error like Forward's.
+func (self *Graph) Evaluate(
+    features map[string][]float64) (map[string][]float64, error) {
+  inputs := make(map[string]*mat64.Dense)
+  for name, values := range features {
+    inputs[name] = mat64.NewDense(1, len(values), values)
+  }
+  if err := self.Forward(inputs); err != nil {
+    return nil, err
+  }
+  outputs := make(map[string][]float64)
+  for _, head := range self.Heads {
+    outputs[head.Node] = append(
+        []float64(nil), head.node.Output.RawRowView(0)...)
+  }
+  return outputs, nil
+}
+
+// Return datapoint as a Datapoint for TrainGraphDataset, whose Features are
+// those of each INPUT node and whose Values are those of each head's node, in
+// order. Returns an error if any are missing or mis-sized.
+func (self *Graph) Datapoint(datapoint GraphDatapoint) (Datapoint, error) {
+  flat := Datapoint{Weight: datapoint.Weight}
+  for _, node := range self.Nodes {
+    if node.Type != NodeType_INPUT {
+      continue
+    }
+    features := datapoint.Features[node.Name]
+    if len(features) != node.Size {
+      return flat, fmt.Errorf("neural: %v features for node %v of size %v",
+                              len(features), node.Name, node.Size)
+    }
+    flat.Features = append(flat.Features, features...)
+  }
+  for _, head := range self.Heads {
+    values := datapoint.Values[head.Node]
+    if len(values) != head.node.Size {
+      return flat, fmt.Errorf("neural: %v values for head %v of size %v",
+                              len(values), head.Node, head.node.Size)
+    }
+    flat.Values = append(flat.Values, values...)
+  }
+  return flat, nil
+}
+
+func (self *Graph) Serialize() []byte {
+  var networkConfiguration NetworkConfiguration
+  for _, node := range self.Nodes {
+    nodeConfiguration := &NodeConfiguration{
+      Name: proto.String(node.Name),
+      Type: node.Type.Enum(),
+      Input: node.Inputs,
+    }
+    if node.Type == NodeType_INPUT {
+      nodeConfiguration.Size = proto.Int32(int32(node.Size))
+    }
+    if node.Layer != nil {
+      nodeConfiguration.Layer = newLayerConfiguration(node.Layer)
+    }
+    networkConfiguration.Node = append(
+        networkConfiguration.Node, nodeConfiguration)
+  }
+  for _, head := range self.Heads {
+    networkConfiguration.Head = append(
+        networkConfiguration.Head, &HeadConfiguration{
+          Node: proto.String(head.Node),
+          ErrorName: head.ErrorName.Enum(),
+          LossWeight: proto.Float64(head.LossWeight),
+        })
+  }
+  byteNetwork, _ := json.Marshal(networkConfiguration)
+  return byteNetwork
+}
+
+func (self *Graph) Deserialize(byteNetwork []byte) error {
+  var networkConfiguration NetworkConfiguration
+  if err := json.Unmarshal(byteNetwork, &networkConfiguration); err != nil {
+    return err
+  }
+  return self.init(networkConfiguration)
+}
+
+func (self *Graph) init(networkConfiguration NetworkConfiguration) error {
+  if networkConfiguration.GetPrecision() != Precision_FLOAT64 {
+    return fmt.Errorf("graph networks must be FLOAT64")
+  }
+  self.Nodes = nil
+  self.Heads = nil
+  self.nodes = make(map[string]*Node)
+  var nodes []*Node
+  configurations := make(map[string]*NodeConfiguration)
+  for _, nodeConfiguration := range networkConfiguration.Node {
+    name := nodeConfiguration.GetName()
+    if name == "" {
+      return fmt.Errorf("graph node without a name")
+    }
+    if configurations[name] != nil {
+      return fmt.Errorf("graph has several nodes called %v", name)
+    }
+    configurations[name] = nodeConfiguration
+    node := &Node{
+      Name: name,
+      Type: nodeConfiguration.GetType(),
+      Inputs: nodeConfiguration.Input,
+    }
+    nodes = append(nodes, node)
+    self.nodes[name] = node
+  }
+  for _, node := range nodes {
+    for _, input := range node.Inputs {
+      source := self.nodes[input]
+      if source == nil {
+        return fmt.Errorf("node %v has unknown input %v", node.Name, input)
+      }
+      node.sources = append(node.sources, source)
+    }
+  }
+  // Order nodes after their sources, sizing and building each once its
+  // sources are.
+  const (
+    unvisited = iota
+    visiting
+    visited
+  )
+  state := make(map[*Node]int)
+  var visit func(node *Node) error
+  visit = func(node *Node) error {
+    switch state[node] {
+    case visiting:
+      return fmt.Errorf("graph has a cycle through node %v", node.Name)
+    case visited:
+      return nil
+    }
+    state[node] = visiting
+    for _, source := range node.sources {
+      if err := visit(source); err != nil {
+        return err
+      }
+    }
+    state[node] = visited
+    if err := node.init(configurations[node.Name]); err != nil {
+      return err
+    }
+    self.Nodes = append(self.Nodes, node)
+    return nil
+  }
+  for _, node := range nodes {
+    if err := visit(node); err != nil {
+      return err
+    }
+  }
+  if len(networkConfiguration.Head) == 0 {
+    return fmt.Errorf("graph has no heads")
+  }
+  for _, headConfiguration := range networkConfiguration.Head {
+    head := &Head{
+      Node: headConfiguration.GetNode(),
+      ErrorName: headConfiguration.GetErrorName(),
+      ErrorFunction: NewErrorFunction(headConfiguration.GetErrorName()),
+      LossWeight: headConfiguration.GetLossWeight(),
+      node: self.nodes[headConfiguration.GetNode()],
+    }
+    if head.node == nil {
+      return fmt.Errorf("head of unknown node %v", head.Node)
+    }
+    self.Heads = append(self.Heads, head)
+  }
+  return nil
+}
+
+// Set Size and Layer from nodeConfiguration, given sized sources.
+func (self *Node) init(nodeConfiguration *NodeConfiguration) error {
+  switch self.Type {
+  case NodeType_INPUT:
+    if len(self.sources) > 0 || nodeConfiguration.GetSize() <= 0 {
+      return fmt.Errorf("input node %v needs a size and no inputs", self.Name)
+    }
+    self.Size = int(nodeConfiguration.GetSize())
+  case NodeType_LAYER:
+    layerConfiguration := nodeConfiguration.Layer
+    if len(self.sources) != 1 || layerConfiguration == nil {
+      return fmt.Errorf("layer node %v needs a layer and one input", self.Name)
+    }
+    if layerConfiguration.GetType() != LayerType_DENSE {
+      return fmt.Errorf("layer node %v isn't DENSE", self.Name)
+    }
+    self.Layer = newLayerFromConfiguration(
+        Precision_FLOAT64, []int{self.sources[0].Size}, layerConfiguration)
+    self.Size = int(layerConfiguration.GetOutputs())
+  case NodeType_CONCAT, NodeType_ADD:
+    if len(self.sources) == 0 {
+      return fmt.Errorf("node %v has no inputs", self.Name)
+    }
+    for _, source := range self.sources {
+      if self.Type == NodeType_CONCAT {
+        self.Size += source.Size
+      } else if source.Size != self.sources[0].Size {
+        return fmt.Errorf("add node %v has inputs of sizes %v and %v",
+                          self.Name, self.sources[0].Size, source.Size)
+      }
+    }
+    if self.Type == NodeType_ADD {
+      self.Size = self.sources[0].Size
+    }
+  }
+  return nil
+}
+
+// Train graph on datapoints, minimizing the total cost of its heads, like
+// TrainGraphDataset. Batch size 0 means full batch learning. Returns an error
+// if a datapoint doesn't fit the graph.
+func TrainGraph(graph *Graph, datapoints []GraphDatapoint,
+                learningConfiguration LearningConfiguration) error {
+  flat := make([]Datapoint, len(datapoints))
+  for i, datapoint := range datapoints {
+    var err error
+    if flat[i], err = graph.Datapoint(datapoint); err != nil {
+      return fmt.Errorf("%v in datapoint %v", err, i)
+    }
+  }
+  if learningConfiguration.GetBatchSize() == 0 {
+    learningConfiguration.BatchSize = proto.Int32(int32(len(datapoints)))
+  }
+  return TrainGraphDataset(graph, SliceDataset(flat), learningConfiguration)
+}
+
+// Train graph on the datapoints of dataset, from Graph.Datapoint, as
+// TrainDataset trains a Network. learningConfiguration's error_name,
+// balance_classes, autoencoder and corruption are unused.
+func TrainGraphDataset(graph *Graph, dataset Dataset,
+                       learningConfiguration LearningConfiguration) error {
+  var inputNodes []*Node
+  features := 0
+  for _, node := range graph.Nodes {
+    if node.Type == NodeType_INPUT {
+      inputNodes = append(inputNodes, node)
+      features += node.Size
+    }
+  }
+  values := 0
+  for _, head := range graph.Heads {
+    values += head.node.Size
+  }
+  computeLoss :=
+      learningConfiguration.GetNonFiniteAction() != NonFiniteAction_IGNORE
+  inputMatrices := make(map[string]*mat64.Dense)
+  valueMatrices := make(map[string]*mat64.Dense)
+  var weights []float64
+  step := func(batch []Datapoint) (float64, error) {
+    for _, node := range inputNodes {
+      inputMatrices[node.Name] = resizeDense(
+          inputMatrices[node.Name], len(batch), node.Size)
+    }
+    for _, head := range graph.Heads {
+      valueMatrices[head.Node] = resizeDense(
+          valueMatrices[head.Node], len(batch), head.node.Size)
+    }
+    if len(weights) != len(batch) {
+      weights = make([]float64, len(batch))
+    }
+    for k := range batch {
+      datapoint := &batch[k]
+      if datapoint.Indices != nil || len(datapoint.Features) != features ||
+         len(datapoint.Values) != values {
+        return 0, fmt.Errorf("neural: datapoint with %v features and %v " +
+                             "values for a graph of %v and %v",
+                             len(datapoint.Features), len(datapoint.Values),
+                             features, values)
+      }
+      row := datapoint.Features
+      for _, node := range inputNodes {
+        inputMatrices[node.Name].SetRow(k, row[:node.Size])
+        row = row[node.Size:]
+      }
+      row = datapoint.Values
+      for _, head := range graph.Heads {
+        valueMatrices[head.Node].SetRow(k, row[:head.node.Size])
+        row = row[head.node.Size:]
+      }
+      weights[k] = datapoint.weight()
+    }
+    if err := graph.Forward(inputMatrices); err != nil {
+      return 0, err
+    }
+    loss := 0.0
+    if computeLoss {
+      loss = graph.Cost(valueMatrices)
+    }
+    graph.Backward(valueMatrices, weights)
+    return loss, nil
+  }
+  return trainBatches(graph.Layers(), dataset, learningConfiguration, step,
+                      graph.checkFinite)
+}
+
+// Return an error naming the first node with NaN or infinite weights or, if
+// loss is NaN or infinite, outputs.
+func (self *Graph) checkFinite(loss float64) error {
+  for _, node := range self.Nodes {
+    if node.Layer != nil && nonFinite(node.Layer.Weights()) {
+      return fmt.Errorf("neural: non-finite weights in node %v", node.Name)
+    }
+  }
+  if !math.IsNaN(loss) && !math.IsInf(loss, 0) {
+    return nil
+  }
+  for _, node := range self.Nodes {
+    if node.Type != NodeType_INPUT && nonFinite(node.Output) {
+      return fmt.Errorf("neural: non-finite loss %v from outputs of node %v",
+                        loss, node.Name)
+    }
+  }
+  return fmt.Errorf("neural: non-finite loss %v", loss)
+}
+
+// m if it is already rows x cols, otherwise a new matrix of that size.
+func resizeDense(m *mat64.Dense, rows, cols int) *mat64.Dense {
+  if m != nil {
+    if r, c := m.Dims(); r == rows && c == cols {
+      return m
+    }
+  }
+  return mat64.NewDense(rows, cols, nil)
+}
+
+func addTo(dst, src []float64) {
+  for i, v := range src {
+    dst[i] += v
+  }
+}
@@ -0,0 +1,497 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "reflect";
+  "strings";
+  "testing"
+  "neural";
+)
+
+func layerNode(name, input string, activation neural.ActivationName,
+               outputs int32) *neural.NodeConfiguration {
+  return &neural.NodeConfiguration{
+    Name: proto.String(name),
+    Input: []string{input},
+    Layer: &neural.LayerConfiguration{
+      Name: activation.Enum(),
+      Outputs: proto.Int32(outputs),
+    },
+  }
+}
+
+// Two inputs, each through its own layer, concatenated and added to a
+// residual, with a regression head and a classification head.
+func createMultiTaskGraph(t *testing.T) *neural.Graph {
+  graph, err := neural.NewGraph(neural.NetworkConfiguration{
+    Node: []*neural.NodeConfiguration{
+      // Out of order, to check sorting.
+      layerNode("class", "shared", neural.ActivationName_LOGISTIC, 2),
+      &neural.NodeConfiguration{
+        Name: proto.String("a"),
+        Type: neural.NodeType_INPUT.Enum(),
+        Size: proto.Int32(3),
+      },
+      &neural.NodeConfiguration{
+        Name: proto.String("b"),
+        Type: neural.NodeType_INPUT.Enum(),
+        Size: proto.Int32(2),
+      },
+      layerNode("a_hidden", "a", neural.ActivationName_TANH, 3),
+      layerNode("b_hidden", "b", neural.ActivationName_TANH, 2),
+      &neural.NodeConfiguration{
+        Name: proto.String("joined"),
+        Type: neural.NodeType_CONCAT.Enum(),
+        Input: []string{"a_hidden", "b_hidden"},
+      },
+      layerNode("mixed", "joined", neural.ActivationName_TANH, 5),
+      &neural.NodeConfiguration{
+        Name: proto.String("shared"),
+        Type: neural.NodeType_ADD.Enum(),
+        Input: []string{"joined", "mixed"},
+      },
+      layerNode("regression", "shared", neural.ActivationName_LINEAR, 1),
+    },
+    Head: []*neural.HeadConfiguration{
+      &neural.HeadConfiguration{
+        Node: proto.String("regression"),
+        LossWeight: proto.Float64(0.5),
+      },
+      &neural.HeadConfiguration{
+        Node: proto.String("class"),
+        ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+      },
+    },
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  return graph
+}
+
+// Examples for createMultiTaskGraph, both of whose heads depend on both
+// inputs.
+func createGraphDatapoints(n int) []neural.GraphDatapoint {
+  datapoints := make([]neural.GraphDatapoint, n)
+  for i := range datapoints {
+    a := []float64{rand.NormFloat64(), rand.NormFloat64(), rand.NormFloat64()}
+    b := []float64{rand.NormFloat64(), rand.NormFloat64()}
+    class := []float64{0, 0}
+    if a[0] + b[0] > 0 {
+      class[0] = 1
+    }
+    if a[1] - b[1] > 0 {
+      class[1] = 1
+    }
+    datapoints[i] = neural.GraphDatapoint{
+      Features: map[string][]float64{"a": a, "b": b},
+      Values: map[string][]float64{
+        "regression": []float64{a[2] + 2 * b[1]},
+        "class": class,
+      },
+    }
+  }
+  return datapoints
+}
+
+// Inputs and values of datapoints for createMultiTaskGraph, by node name.
+func graphBatch(datapoints []neural.GraphDatapoint) (map[string]*mat64.Dense,
+                                                     map[string]*mat64.Dense) {
+  inputs := map[string]*mat64.Dense{
+    "a": mat64.NewDense(len(datapoints), 3, nil),
+    "b": mat64.NewDense(len(datapoints), 2, nil),
+  }
+  values := map[string]*mat64.Dense{
+    "regression": mat64.NewDense(len(datapoints), 1, nil),
+    "class": mat64.NewDense(len(datapoints), 2, nil),
+  }
+  for i, datapoint := range datapoints {
+    for name, input := range inputs {
+      input.SetRow(i, datapoint.Features[name])
+    }
+    for name, value := range values {
+      value.SetRow(i, datapoint.Values[name])
+    }
+  }
+  return inputs, values
+}
+
+func TestGraphGradientCheck(t *testing.T) {
+  rand.Seed(1)
+  graph := createMultiTaskGraph(t)
+  graph.RandomizeSynapses()
+  inputs, values := graphBatch(createGraphDatapoints(4))
+  graph.Forward(inputs)
+  graph.Backward(values, []float64{1, 2, 0.5, 1})
+  weightedCost := func() float64 {
+    graph.Forward(inputs)
+    cost := 0.0
+    for k, weight := range []float64{1, 2, 0.5, 1} {
+      example := make(map[string]*mat64.Dense)
+      for name, value := range values {
+        _, cols := value.Dims()
+        example[name] = mat64.NewDense(1, cols, value.RawRowView(k))
+      }
+      for _, head := range graph.Heads {
+        output := graph.Output(head.Node)
+        _, cols := output.Dims()
+        cost += weight * head.LossWeight * head.ErrorFunction.Cost(
+            example[head.Node],
+            mat64.NewDense(1, cols, output.RawRowView(k)))
+      }
+    }
+    return cost
+  }
+  const step = 1e-5
+  for _, node := range graph.Nodes {
+    if node.Layer == nil {
+      continue
+    }
+    weights, gradients := node.Layer.Weights(), node.Layer.Gradients()
+    rows, cols := weights.Dims()
+    for i := 0; i < rows; i++ {
+      for j := 0; j < cols; j++ {
+        if i == rows - 1 {
+          continue  // Biases aren't trained.
+        }
+        w := weights.At(i, j)
+        weights.Set(i, j, w + step)
+        plus := weightedCost()
+        weights.Set(i, j, w - step)
+        minus := weightedCost()
+        weights.Set(i, j, w)
+        expected := (plus - minus) / (2 * step)
+        if math.Abs(gradients.At(i, j) - expected) >
+           1e-5 * math.Max(1, math.Abs(expected)) {
+          t.Errorf("node %v gradient at (%v, %v) %v, expected %v", node.Name,
+                   i, j, gradients.At(i, j), expected)
+        }
+      }
+    }
+  }
+}
+
+func TestGraphMatchesNetwork(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(3),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_RELU.Enum(),
+        Outputs: proto.Int32(4),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_SOFTMAX.Enum(),
+        Outputs: proto.Int32(2),
+      },
+    },
+  })
+  neuralNetwork.RandomizeSynapses()
+  var networkConfiguration neural.NetworkConfiguration
+  if err := json.Unmarshal(
+      neuralNetwork.Serialize(), &networkConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  graph, err := neural.NewGraph(neural.NetworkConfiguration{
+    Node: []*neural.NodeConfiguration{
+      &neural.NodeConfiguration{
+        Name: proto.String("features"),
+        Type: neural.NodeType_INPUT.Enum(),
+        Size: proto.Int32(3),
+      },
+      &neural.NodeConfiguration{
+        Name: proto.String("hidden"),
+        Input: []string{"features"},
+        Layer: networkConfiguration.Layer[0],
+      },
+      &neural.NodeConfiguration{
+        Name: proto.String("output"),
+        Input: []string{"hidden"},
+        Layer: networkConfiguration.Layer[1],
+      },
+    },
+    Head: []*neural.HeadConfiguration{
+      &neural.HeadConfiguration{
+        Node: proto.String("output"),
+        ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+      },
+    },
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  features := mat64.NewDense(5, 3, nil)
+  features.Apply(func(i, j int, v float64) float64 {
+    return rand.NormFloat64()
+  }, features)
+  values := mat64.NewDense(5, 2, []float64{1, 0, 0, 1, 1, 0, 1, 0, 0, 1})
+  neuralNetwork.Forward(features)
+  neuralNetwork.Backward(values, new(neural.CrossEntropyErrorFunction))
+  graph.Forward(map[string]*mat64.Dense{"features": features})
+  graph.Backward(map[string]*mat64.Dense{"output": values}, nil)
+  if !mat64.EqualApprox(graph.Output("output"),
+                        neuralNetwork.Layers[1].Output, 1e-12) {
+    t.Errorf("graph output differs from network output")
+  }
+  for i, layer := range graph.Layers() {
+    if !mat64.EqualApprox(layer.Gradients(),
+                          neuralNetwork.Layers[i].Gradients(), 1e-12) {
+      t.Errorf("layer %v gradient differs from network gradient", i)
+    }
+  }
+}
+
+func TestTrainGraph(t *testing.T) {
+  rand.Seed(1)
+  graph := createMultiTaskGraph(t)
+  graph.RandomizeSynapses()
+  datapoints := createGraphDatapoints(200)
+  inputs, values := graphBatch(datapoints)
+  graph.Forward(inputs)
+  initialCost := graph.Cost(values)
+  if err := neural.TrainGraph(graph, datapoints, neural.LearningConfiguration{
+    Epochs: proto.Int32(50),
+    Rate: proto.Float64(0.01),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+  }); err != nil {
+    t.Fatal(err)
+  }
+  graph.Forward(inputs)
+  if cost := graph.Cost(values); cost > 0.5 * initialCost {
+    t.Errorf("cost %v didn't improve enough on %v", cost, initialCost)
+  }
+}
+
+func TestTrainGraphNonFinite(t *testing.T) {
+  rand.Seed(1)
+  graph := createMultiTaskGraph(t)
+  graph.RandomizeSynapses()
+  before := new(neural.Graph)
+  if err := before.Deserialize(graph.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  datapoints := createGraphDatapoints(2)
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(math.Inf(1)),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(1),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+    NonFiniteAction: neural.NonFiniteAction_ROLLBACK.Enum(),
+  }
+  if err := neural.TrainGraph(
+      graph, datapoints, learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  for i, layer := range graph.Layers() {
+    if !mat64.Equal(layer.Weight, before.Layers()[i].Weight) {
+      t.Errorf("layer %v weights not rolled back", i)
+    }
+  }
+  learningConfiguration.NonFiniteAction = neural.NonFiniteAction_ABORT.Enum()
+  if err := neural.TrainGraph(graph, datapoints, learningConfiguration);
+     err == nil || !strings.Contains(err.Error(), "node") {
+    t.Errorf("expected error naming a node, got %v", err)
+  }
+}
+
+func TestTrainGraphErrors(t *testing.T) {
+  rand.Seed(1)
+  graph := createMultiTaskGraph(t)
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.01),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(0),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+  }
+  if err := neural.TrainGraph(graph, nil, learningConfiguration); err == nil {
+    t.Errorf("trained in full batches of no datapoints")
+  }
+  if err := neural.TrainGraphDataset(graph, neural.SliceDataset(nil),
+                                     learningConfiguration); err == nil {
+    t.Errorf("trained a dataset without a batch size")
+  }
+  datapoints := createGraphDatapoints(2)
+  datapoints[1].Features["b"] = datapoints[1].Features["b"][:1]
+  if err := neural.TrainGraph(
+      graph, datapoints, learningConfiguration); err == nil {
+    t.Errorf("trained on a datapoint with 1 feature for an input of size 2")
+  }
+  delete(datapoints[1].Values, "class")
+  if _, err := graph.Datapoint(datapoints[1]); err == nil {
+    t.Errorf("converted a datapoint without class values")
+  }
+}
+
+func TestGraphDatapoint(t *testing.T) {
+  graph := createMultiTaskGraph(t)
+  datapoint, err := graph.Datapoint(neural.GraphDatapoint{
+    Features: map[string][]float64{"b": {4, 5}, "a": {1, 2, 3}},
+    Values: map[string][]float64{"class": {1, 0}, "regression": {7}},
+    Weight: 2,
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  // Inputs in node order and values in head order.
+  expected := neural.Datapoint{
+    Features: []float64{1, 2, 3, 4, 5},
+    Values: []float64{7, 1, 0},
+    Weight: 2,
+  }
+  if !reflect.DeepEqual(datapoint, expected) {
+    t.Errorf("converted to %+v, expected %+v", datapoint, expected)
+  }
+}
+
+func TestGraphForwardErrors(t *testing.T) {
+  graph := createMultiTaskGraph(t)
+  testCases := map[string]map[string]*mat64.Dense{
+    "missing input": {"a": mat64.NewDense(1, 3, nil)},
+    "mis-sized input": {
+      "a": mat64.NewDense(1, 3, nil),
+      "b": mat64.NewDense(1, 3, nil),
+    },
+    "mismatched examples": {
+      "a": mat64.NewDense(1, 3, nil),
+      "b": mat64.NewDense(2, 2, nil),
+    },
+  }
+  for name, inputs := range testCases {
+    if err := graph.Forward(inputs); err == nil {
+      t.Errorf("%v: no error", name)
+    }
+  }
+  if _, err := graph.Evaluate(map[string][]float64{"a": {1, 2, 3}});
+     err == nil {
+    t.Errorf("evaluated without input b")
+  }
+}
+
+func TestTrainGraphPartialAccumulation(t *testing.T) {
+  rand.Seed(1)
+  trainedGraph := createMultiTaskGraph(t)
+  trainedGraph.RandomizeSynapses()
+  expectedGraph := new(neural.Graph)
+  if err := expectedGraph.Deserialize(trainedGraph.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  datapoint := createGraphDatapoints(1)[0]
+  inputs, values := graphBatch([]neural.GraphDatapoint{datapoint})
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.1),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(1),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+    AccumulationSteps: proto.Int32(2),
+  }
+  if err := neural.TrainGraph(
+      trainedGraph, []neural.GraphDatapoint{datapoint, datapoint, datapoint},
+      learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  // An update from the first two batches, then one from the last alone.
+  for _, accumulate := range []bool{true, false, false} {
+    expectedGraph.Forward(inputs)
+    expectedGraph.Backward(values, nil)
+    if accumulate {
+      expectedGraph.Accumulate()
+    } else {
+      expectedGraph.Update(learningConfiguration)
+    }
+  }
+  compare := func(when string) {
+    for i, layer := range expectedGraph.Layers() {
+      if !mat64.EqualApprox(layer.Weight, trainedGraph.Layers()[i].Weight,
+                            1e-12) {
+        t.Errorf("layer %v weights differ %v", i, when)
+      }
+    }
+  }
+  compare("after training")
+  // Nothing accumulated is left over for the next update.
+  for _, graph := range []*neural.Graph{trainedGraph, expectedGraph} {
+    graph.Forward(inputs)
+    graph.Backward(values, nil)
+    graph.Update(learningConfiguration)
+  }
+  compare("after a later update")
+}
+
+func TestSerializeGraph(t *testing.T) {
+  rand.Seed(1)
+  graph := createMultiTaskGraph(t)
+  graph.RandomizeSynapses()
+  deserialized := new(neural.Graph)
+  if err := deserialized.Deserialize(graph.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  inputs, _ := graphBatch(createGraphDatapoints(3))
+  graph.Forward(inputs)
+  deserialized.Forward(inputs)
+  for _, head := range graph.Heads {
+    if !mat64.Equal(graph.Output(head.Node),
+                    deserialized.Output(head.Node)) {
+      t.Errorf("head %v output changed by serialization", head.Node)
+    }
+  }
+  if deserialized.Heads[0].LossWeight != 0.5 {
+    t.Errorf("loss weight %v changed by serialization",
+             deserialized.Heads[0].LossWeight)
+  }
+  if err := new(neural.Network).Deserialize(graph.Serialize()); err == nil {
+    t.Errorf("Network deserialized a graph")
+  }
+}
+
+func TestGraphErrors(t *testing.T) {
+  input := &neural.NodeConfiguration{
+    Name: proto.String("input"),
+    Type: neural.NodeType_INPUT.Enum(),
+    Size: proto.Int32(2),
+  }
+  head := []*neural.HeadConfiguration{
+    &neural.HeadConfiguration{Node: proto.String("output")},
+  }
+  testCases := map[string][]*neural.NodeConfiguration{
+    "cycle": {
+      input,
+      layerNode("loop", "output", neural.ActivationName_TANH, 2),
+      layerNode("output", "loop", neural.ActivationName_TANH, 2),
+    },
+    "unknown input": {
+      input,
+      layerNode("output", "missing", neural.ActivationName_TANH, 2),
+    },
+    "mismatched add": {
+      input,
+      layerNode("wide", "input", neural.ActivationName_TANH, 3),
+      &neural.NodeConfiguration{
+        Name: proto.String("output"),
+        Type: neural.NodeType_ADD.Enum(),
+        Input: []string{"input", "wide"},
+      },
+    },
+    "duplicate": {
+      input,
+      layerNode("output", "input", neural.ActivationName_TANH, 2),
+      layerNode("output", "input", neural.ActivationName_TANH, 2),
+    },
+  }
+  for name, nodes := range testCases {
+    if _, err := neural.NewGraph(neural.NetworkConfiguration{
+      Node: nodes,
+      Head: head,
+    }); err == nil {
+      t.Errorf("%v: no error", name)
+    }
+  }
+}
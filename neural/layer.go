@@ -2,7 +2,9 @@ package neural
 
 import (
   "fmt";
-  "github.com/gonum/matrix/mat64"
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand"
 )
 
 func NewLayer(name ActivationName, inputs int, outputs int,
@@ -13,87 +15,563 @@ func NewLayer(name ActivationName, inputs int, outputs int,
   layer.DActivationFunction = NewDActivationFunction(layer.Name)
 
   layer.Weight = mat64.NewDense(inputs + 1, outputs, weight)
+  layer.Gradient = mat64.NewDense(inputs + 1, outputs, nil)
+  layer.Trainable = true
+  layer.RateMultiplier = 1
+  layer.InputShape = []int{inputs}
+  layer.OutputShape = []int{outputs}
   layer.Output = &mat64.Dense{}
   layer.Deltas = &mat64.Dense{}
   layer.Derivatives = &mat64.Dense{}
+
+  // Views and workspaces reused by every batch. mat64 has no views of 0 rows,
+  // so layers without weights, such as GAUSSIAN_SAMPLE layers, made with 0
+  // inputs, get empty ones.
+  layer.weight, layer.weightGradient = &mat64.Dense{}, &mat64.Dense{}
+  if inputs > 0 {
+    layer.weight = layer.Weight.View(0, 0, inputs, outputs).(*mat64.Dense)
+    layer.weightGradient =
+        layer.Gradient.View(0, 0, inputs, outputs).(*mat64.Dense)
+    layer.transposedGradient = mat64.NewDense(outputs, inputs, nil)
+  }
+  layer.bias = layer.Weight.RawRowView(inputs)
+  layer.biasGradient = layer.Gradient.RawRowView(inputs)
+  layer.outputT = layer.Output.T()
   return layer
 }
 
 type Layer struct {
+  Type LayerType
   Name ActivationName
   ActivationFunction ActivationFunction
   DActivationFunction DActivationFunction
+  // Views into Weight are kept across batches, so change its contents rather
+  // than replacing it.
   Weight *mat64.Dense  // (inputs + 1) x outputs
+  // Gradient of the cost with respect to Weight from the last Backward, which
+  // Update applies. Not computed for layers that aren't Trainable.
+  Gradient *mat64.Dense  // (inputs + 1) x outputs
+  // Regularization and constraints on non-bias weights, as in
+  // LayerConfiguration.
+  L1 float64
+  L2 float64
+  MaxNorm float64
+  NonNegative bool
+  UnitNorm bool
+  // Whether Update changes this layer's weights.
+  Trainable bool
+  // Multiplier on the learning rate for this layer.
+  RateMultiplier float64
+  // FLOAT32 layers keep their weights and gradients in Weight32 and Gradient32
+  // instead of Weight and Gradient, and leave Input, Deltas and Derivatives
+  // unset. Output is float64 for both.
+  Precision Precision
+  Weight32 *Dense32  // (inputs + 1) x outputs
+  Gradient32 *Dense32  // (inputs + 1) x outputs
+  // Backend for this layer's matrix operations, or nil for the default.
+  Backend Backend
+  // Forward pass replacing the dense layer, differentiated automatically, or
+  // nil. Deltas then holds the gradient with respect to Output rather than
+  // the weighted input. Only FLOAT64 layers use it.
+  Function LayerFunction
+  // Number of attention heads, whether attention is causal, size of the
+  // feed-forward sublayer and whether padding tokens are masked, for sequence
+  // layers.
+  Heads int
+  Causal bool
+  FeedForward int
+  MaskPadding bool
+  // Multiplier on the KL divergence of GAUSSIAN_SAMPLE layers.
+  KLWeight float64
+  // Earlier layer whose transposed weights this layer uses instead of its
+  // own, or nil. Only for FLOAT64 layers.
+  Tied *Layer
+  // Shape of each example's inputs and outputs, for layers that consume
+  // richer shapes than vectors. Dense layers flatten them. Input and Output
+  // hold each example's elements in a row, in row-major order.
+  InputShape []int
+  OutputShape []int
 
-  Input *mat64.Dense  // examples x (inputs + 1)
-  Ones *mat64.Dense  // examples x 1
+  Input *mat64.Dense  // examples x inputs
   Output *mat64.Dense  // examples x outputs
   Deltas *mat64.Dense  // outputs x examples
   Derivatives *mat64.Dense  // outputs x examples
+
+  weight *mat64.Dense  // View of Weight without biases, inputs x outputs
+  bias []float64  // View of Weight's bias row
+  weightGradient *mat64.Dense  // View of Gradient without biases
+  biasGradient []float64  // View of Gradient's bias row
+  outputT mat64.Matrix  // Output.T()
+  transposedGradient *mat64.Dense  // outputs x inputs
+  // Sum of Gradient over batches accumulated since the last update.
+  accumulatedGradient *mat64.Dense  // (inputs + 1) x outputs
+  accumulated int
+  state32 *layer32  // Only for FLOAT32 layers.
+  tape *layerTape  // Only for layers with a Function.
+  sparseInput *Sparse  // Replaces Input after a sparse forward pass.
+  // Whether weightGradient is 0 outside sparseRows, the distinct rows set by
+  // the last sparse backward pass.
+  sparseGradient bool
+  sparseRows []int
+  sparseRowSet []bool  // Marks rows while collecting sparseRows.
+  outputData []float64  // Backs Output.
+  // Weight of each example in the current backward pass, scaling the KL
+  // divergence gradient of GAUSSIAN_SAMPLE layers. nil weights each by 1.
+  exampleWeights []float64
+  // Parameter matrices packed in order into weight, for sequence layers.
+  blocks []parameterBlock
+  // Whether each row of weight is regularized, or nil if every row is.
+  regularized []bool
+}
+
+func (self* Layer) backend() Backend {
+  if self.Backend != nil {
+    return self.Backend
+  }
+  return defaultBackend
+}
+
+// Weight or Weight32, depending on Precision.
+func (self* Layer) Weights() mat64.Mutable {
+  if self.Precision == Precision_FLOAT32 {
+    return self.Weight32
+  }
+  return self.Weight
+}
+
+// Number of inputs per example.
+func (self* Layer) Inputs() int {
+  return product(self.InputShape)
+}
+
+// Number of outputs per example.
+func (self* Layer) Outputs() int {
+  return product(self.OutputShape)
+}
+
+// Output as an examples x OutputShape tensor sharing its data.
+func (self* Layer) OutputTensor() *Tensor {
+  examples, _ := self.Output.Dims()
+  return NewTensor(append([]int{examples}, self.OutputShape...),
+                   self.outputData)
+}
+
+// Gradient or Gradient32, depending on Precision.
+func (self* Layer) Gradients() mat64.Mutable {
+  if self.Precision == Precision_FLOAT32 {
+    return self.Gradient32
+  }
+  return self.Gradient
 }
 
-// TODO(ariw): Delete any matrix creation in layer operations.
 func (self* Layer) Forward(previous *Layer) {
-  self.resetForExamples(previous)
-  self.Input = previous.Output
-  var inputAndBias mat64.Dense
-  inputAndBias.Augment(self.Input, self.Ones)  // Add bias to input.
-  self.Output.Mul(&inputAndBias, self.Weight)
-  self.DActivationFunction(self.Output.T(), self.Derivatives)
+  if self.Precision == Precision_FLOAT32 && previous.state32 != nil {
+    self.forward32(previous.state32.output)
+    return
+  }
+  self.forward(previous.Output)
+}
+
+func (self* Layer) forward(input *mat64.Dense) {
+  if self.Precision == Precision_FLOAT32 {
+    self.forwardConverted(input)
+    return
+  }
+  if self.Tied != nil {
+    self.weight.Copy(self.Tied.weight.T())
+  }
+  if self.Function != nil {
+    self.forwardTape(input)
+    return
+  }
+  examples, _ := input.Dims()
+  self.resetForExamples(examples)
+  self.Input = input
+  self.sparseInput = nil
+  self.backend().Mul(self.Output, self.Input, self.weight)
+  for i := 0; i < examples; i++ {
+    row := self.Output.RawRowView(i)
+    for j, bias := range self.bias {
+      row[j] += bias
+    }
+  }
+  self.DActivationFunction(self.outputT, self.Derivatives)
   self.ActivationFunction(self.Output, self.Output)
 }
 
 func (self* Layer) Backward(next *Layer) {
-  rows, cols := next.Weight.Dims()
-  // Don't look at bias weights from next layer when backpropagating.
-  self.Deltas.Mul(next.Weight.View(0, 0, rows - 1, cols), next.Deltas)
-  self.Deltas.MulElem(self.Deltas, self.Derivatives)
+  if self.Precision == Precision_FLOAT32 {
+    self.backward32(next)
+    return
+  }
+  next.inputDeltas(self.Deltas)
+  self.backwardDeltas()
 }
 
-func (self* Layer) BackwardOutput(values *mat64.Dense,
+// weights scales each example's contribution to Deltas. nil weights every
+// example equally.
+func (self* Layer) BackwardOutput(values *mat64.Dense, weights []float64,
                                   error_function ErrorFunction) {
-  // TODO(ariw): ErrorFunction Delta use goes here.
-  values.Sub(self.Output, values)
-  self.Deltas.MulElem(values.T(), self.Derivatives)
+  if self.Precision == Precision_FLOAT32 {
+    self.backwardOutput32(values, weights, error_function)
+    return
+  }
+  deltas := error_function.Deltas(values, self.Output)
+  outputs, examples := self.Deltas.Dims()
+  for k := 0; k < examples; k++ {
+    weight := 1.0
+    if weights != nil {
+      weight = weights[k]
+    }
+    for j := 0; j < outputs; j++ {
+      self.Deltas.Set(j, k, weight * deltas.At(k, j))
+    }
+  }
+  self.backwardDeltas()
+}
+
+// Given Deltas holding the gradient of the cost with respect to Output,
+// compute Gradient.
+func (self* Layer) backwardDeltas() {
+  if self.Function != nil {
+    self.backwardTape()
+    if self.Type == LayerType_GAUSSIAN_SAMPLE {
+      self.backwardDivergence()
+    }
+    return
+  }
+  self.backwardActivation()
+  self.computeGradient()
+}
+
+// Turn Deltas from the gradient of the cost with respect to this layer's
+// output into the gradient with respect to its weighted input.
+func (self* Layer) backwardActivation() {
+  if self.Name != ActivationName_SOFTMAX {
+    self.backend().MulElem(self.Deltas, self.Deltas, self.Derivatives)
+    return
+  }
+  // Each softmax output depends on every weighted input, so apply the full
+  // Jacobian: delta_i = output_i * (gradient_i - sum_j gradient_j * output_j).
+  outputs, examples := self.Deltas.Dims()
+  for k := 0; k < examples; k++ {
+    dot := 0.0
+    for j := 0; j < outputs; j++ {
+      dot += self.Deltas.At(j, k) * self.Output.At(k, j)
+    }
+    for i := 0; i < outputs; i++ {
+      self.Deltas.Set(i, k, self.Output.At(k, i) * (self.Deltas.At(i, k) - dot))
+    }
+  }
+}
+
+// Compute Gradient from Input and Deltas.
+func (self* Layer) computeGradient() {
+  if !self.Trainable {
+    return
+  }
+  if self.sparseInput != nil {
+    self.computeSparseGradient()
+    return
+  }
+  self.sparseGradient = false
+  // Multiply without transposes, then transpose into Gradient.
+  self.backend().Mul(self.transposedGradient, self.Deltas, self.Input)
+  inputs, outputs := self.weightGradient.Dims()
+  for i := 0; i < inputs; i++ {
+    row := self.weightGradient.RawRowView(i)
+    for j := range row {
+      row[j] = self.transposedGradient.At(j, i)
+    }
+  }
+  for j := 0; j < outputs; j++ {
+    bias := 0.0
+    for _, delta := range self.Deltas.RawRowView(j) {
+      bias += delta
+    }
+    self.biasGradient[j] = bias
+  }
 }
 
 func (self* Layer) Update(learningConfiguration LearningConfiguration) {
-  var deltas mat64.Dense
-  deltas.Mul(self.Deltas, self.Input)
-  rows, cols := self.Weight.Dims()
-  weight := self.Weight.View(0, 0, rows - 1, cols).(*mat64.Dense)
-  if *learningConfiguration.Decay > 0 {
-    var decay mat64.Dense
-    decay.Scale(*learningConfiguration.Decay, weight)
-    deltas.Sub(&deltas, decay.T())
+  if !self.Trainable {
+    return
+  }
+  self.clipGradient(learningConfiguration.GetClipValue())
+  self.applyGradient(learningConfiguration, 1)
+}
+
+// Add Gradient to the gradient applied by the next update.
+func (self* Layer) Accumulate() {
+  if self.Precision == Precision_FLOAT32 {
+    self.accumulate32()
+    return
+  }
+  if self.accumulatedGradient == nil {
+    rows, cols := self.Gradient.Dims()
+    self.accumulatedGradient = mat64.NewDense(rows, cols, nil)
+  }
+  if self.accumulated == 0 {
+    self.accumulatedGradient.Copy(self.Gradient)
+  } else {
+    self.accumulatedGradient.Add(self.accumulatedGradient, self.Gradient)
+  }
+  self.accumulated++
+}
+
+// Set Gradient, or Gradient32, to zeros.
+func (self* Layer) zeroGradient() {
+  if self.Precision == Precision_FLOAT32 {
+    for i := range self.Gradient32.data {
+      self.Gradient32.data[i] = 0
+    }
+    return
+  }
+  rows, _ := self.Gradient.Dims()
+  for i := 0; i < rows; i++ {
+    row := self.Gradient.RawRowView(i)
+    for j := range row {
+      row[j] = 0
+    }
+  }
+}
+
+// Add any accumulated gradients to Gradient, then clip each element to
+// [-clipValue, clipValue] if clipValue is positive. Returns the squared norm
+// of the (clipped) gradient.
+func (self* Layer) clipGradient(clipValue float64) float64 {
+  if self.Precision == Precision_FLOAT32 {
+    return self.clipGradient32(clipValue)
+  }
+  if self.accumulated > 0 {
+    self.Gradient.Add(self.Gradient, self.accumulatedGradient)
+    self.accumulated = 0
+    self.sparseGradient = false
+  }
+  squaredNorm := 0.0
+  clip := func(row []float64) {
+    for j, v := range row {
+      if clipValue > 0 {
+        v = math.Max(-clipValue, math.Min(clipValue, v))
+        row[j] = v
+      }
+      squaredNorm += v * v
+    }
+  }
+  if self.sparseGradient {
+    // Every other row is 0.
+    for _, i := range self.sparseRows {
+      clip(self.weightGradient.RawRowView(i))
+    }
+    clip(self.biasGradient)
+    return squaredNorm
+  }
+  rows, _ := self.Gradient.Dims()
+  for i := 0; i < rows; i++ {
+    clip(self.Gradient.RawRowView(i))
+  }
+  return squaredNorm
+}
+
+// Apply Gradient, scaled by scale, along with regularization to the weights,
+// then enforce weight constraints. Biases are not updated.
+func (self* Layer) applyGradient(learningConfiguration LearningConfiguration,
+                                 scale float64) {
+  if self.Precision == Precision_FLOAT32 {
+    self.applyGradient32(learningConfiguration, scale)
+    return
+  }
+  rate := *learningConfiguration.Rate * self.RateMultiplier
+  decay := *learningConfiguration.Decay + self.L2
+  applyRow := func(i int) {
+    l1, l2 := self.L1, decay
+    if self.regularized != nil && !self.regularized[i] {
+      l1, l2 = 0, 0
+    }
+    gradient := self.weightGradient.RawRowView(i)
+    row := self.weight.RawRowView(i)
+    for j, w := range row {
+      update := scale * gradient[j] + l2 * w
+      if w > 0 {
+        update += l1
+      } else if w < 0 {
+        update -= l1
+      }
+      row[j] = w - rate * update
+    }
+  }
+  if self.sparseGradient && self.L1 == 0 && decay == 0 &&
+     !self.NonNegative && self.MaxNorm <= 0 && !self.UnitNorm {
+    // Without regularization or constraints, weights of rows without
+    // gradients don't change.
+    for _, i := range self.sparseRows {
+      applyRow(i)
+    }
+    return
+  }
+  inputs, _ := self.weight.Dims()
+  for i := 0; i < inputs; i++ {
+    applyRow(i)
+  }
+  self.constrain()
+}
+
+// Enforce NonNegative, MaxNorm and UnitNorm on non-bias weights, which for
+// sequence layers are each of their weight matrices.
+func (self* Layer) constrain() {
+  if !self.NonNegative && self.MaxNorm <= 0 && !self.UnitNorm {
+    return
+  }
+  if self.blocks == nil {
+    rows, cols := self.weight.Dims()
+    self.constrainWeights(rows, cols, func(i, j int) (int, int) {
+      return i, j
+    })
+    return
+  }
+  offset := 0
+  for _, block := range self.blocks {
+    if block.regularized() {
+      // A sequence layer's one column of weights holds each block by rows.
+      self.constrainWeights(block.rows, block.cols,
+                            func(i, j int) (int, int) {
+                              return offset + i * block.cols + j, 0
+                            })
+    }
+    offset += block.rows * block.cols
+  }
+}
+
+// Enforce the constraints on a rows x cols matrix of weights, whose columns
+// are each neuron's incoming weights and whose element (i, j) is at index(i,
+// j) of weight.
+func (self* Layer) constrainWeights(rows, cols int,
+                                    index func(i, j int) (int, int)) {
+  weight := self.weight
+  at := func(i, j int) float64 {
+    return weight.At(index(i, j))
+  }
+  set := func(i, j int, v float64) {
+    r, c := index(i, j)
+    weight.Set(r, c, v)
+  }
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      if self.NonNegative && at(i, j) < 0 {
+        set(i, j, 0)
+      }
+    }
+  }
+  if self.MaxNorm <= 0 && !self.UnitNorm {
+    return
+  }
+  for j := 0; j < cols; j++ {
+    norm := 0.0
+    for i := 0; i < rows; i++ {
+      norm += at(i, j) * at(i, j)
+    }
+    norm = math.Sqrt(norm)
+    target := norm
+    if self.UnitNorm {
+      target = 1
+    } else if norm > self.MaxNorm {
+      target = self.MaxNorm
+    }
+    if norm == 0 || target == norm {
+      continue
+    }
+    for i := 0; i < rows; i++ {
+      set(i, j, at(i, j) * target / norm)
+    }
+  }
+}
+
+// Regularization penalty on this layer's weights, including L2 weight decay.
+func (self* Layer) Penalty(decay float64) float64 {
+  if self.Tied != nil {
+    return 0  // Counted by the tied layer.
+  }
+  weight := self.Weights()
+  penalty := 0.0
+  rows, cols := weight.Dims()
+  for i := 0; i < rows - 1; i++ {  // Skip biases.
+    if self.regularized != nil && !self.regularized[i] {
+      continue
+    }
+    for j := 0; j < cols; j++ {
+      w := weight.At(i, j)
+      penalty += self.L1 * math.Abs(w) + 0.5 * (decay + self.L2) * w * w
+    }
+  }
+  return penalty
+}
+
+// Set every weight to a sample from the standard normal distribution, or for
+// sequence layers to each parameter's initial value.
+func (self* Layer) randomizeWeights() {
+  switch self.Type {
+  case LayerType_DENSE:
+  case LayerType_GAUSSIAN_SAMPLE:
+    return  // No parameters.
+  default:
+    self.initializeParameters()
+    return
+  }
+  weight := self.Weights()
+  rows, cols := weight.Dims()
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      weight.Set(i, j, rand.NormFloat64())
+    }
   }
-  deltas.Scale(*learningConfiguration.Rate, &deltas)
-  weight.Sub(weight, deltas.T())
 }
 
 func (self* Layer) DebugString() string {
+  if self.Precision == Precision_FLOAT32 {
+    return fmt.Sprintf(
+        "name: %v\nweight: %v\ngradient: %v\noutput: %v\n", self.Name,
+        mat64.Formatted(self.Weight32, mat64.Prefix("        ")),
+        mat64.Formatted(self.Gradient32, mat64.Prefix("          ")),
+        mat64.Formatted(self.Output, mat64.Prefix("        ")))
+  }
   return fmt.Sprintf(
-      "name: %v\nweight: %v\ninput: %v\noutput: %v\ndeltas: %v\nderivatives: " +
-      "%v\n", self.Name,
+      "name: %v\nweight: %v\ngradient: %v\ninput: %v\noutput: %v\ndeltas: " +
+      "%v\nderivatives: %v\n", self.Name,
       mat64.Formatted(self.Weight, mat64.Prefix("        ")),
+      mat64.Formatted(self.Gradient, mat64.Prefix("          ")),
       mat64.Formatted(self.Input, mat64.Prefix("        ")),
       mat64.Formatted(self.Output, mat64.Prefix("        ")),
       mat64.Formatted(self.Deltas, mat64.Prefix("        ")),
       mat64.Formatted(self.Derivatives, mat64.Prefix("             ")))
 }
 
-// Check if we need to reset internal state for this activation of the network.
-func (self* Layer) resetForExamples(previous *Layer) {
+// Check if we need to resize internal state for this activation of the
+// network, which only allocates when the number of examples changes.
+func (self* Layer) resetForExamples(examples int) {
   previousExamples, _ := self.Output.Dims()
-  examples, _ := previous.Output.Dims()
   if previousExamples != examples {
-    self.Output.Reset()
-    self.Deltas.Reset()
-    self.Derivatives.Reset()
-    ones := make([]float64, examples)
-    for i, _ := range ones {
-      ones[i] = 1.0
+    outputs := self.Outputs()
+    self.resizeOutput(examples, outputs)
+    *self.Deltas = *mat64.NewDense(outputs, examples, nil)
+    *self.Derivatives = *mat64.NewDense(outputs, examples, nil)
+  }
+}
+
+func (self* Layer) resizeOutput(examples, outputs int) {
+  self.outputData = make([]float64, examples * outputs)
+  // Replace contents rather than pointers, since outputT refers to Output.
+  *self.Output = *mat64.NewDense(examples, outputs, self.outputData)
+}
+
+// Whether any element of m is NaN or infinite.
+func nonFinite(m mat64.Matrix) bool {
+  rows, cols := m.Dims()
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      if v := m.At(i, j); math.IsNaN(v) || math.IsInf(v, 0) {
+        return true
+      }
     }
-    self.Ones = mat64.NewDense(examples, 1, ones)
   }
+  return false
 }
@@ -0,0 +1,359 @@
+package neural
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "math"
+)
+
+// Row-major float32 matrix holding the weights and intermediate results of
+// FLOAT32 layers.
+type Dense32 struct {
+  rows, cols int
+  data []float32
+}
+
+// Create a rows x cols matrix backed by data, or by zeros if data is nil.
+func NewDense32(rows, cols int, data []float32) *Dense32 {
+  if data == nil {
+    data = make([]float32, rows * cols)
+  }
+  if len(data) != rows * cols {
+    panic("neural: Dense32 dimension mismatch")
+  }
+  return &Dense32{rows, cols, data}
+}
+
+func (self *Dense32) Dims() (int, int) {
+  return self.rows, self.cols
+}
+
+func (self *Dense32) At(i, j int) float64 {
+  return float64(self.data[i * self.cols + j])
+}
+
+func (self *Dense32) Set(i, j int, v float64) {
+  self.data[i * self.cols + j] = float32(v)
+}
+
+func (self *Dense32) T() mat64.Matrix {
+  return mat64.Transpose{Matrix: self}
+}
+
+func (self *Dense32) RawRowView(i int) []float32 {
+  return self.data[i * self.cols : (i + 1) * self.cols]
+}
+
+// Copy as much of a as fits into the matrix, returning the rows and columns
+// copied.
+func (self *Dense32) Copy(a mat64.Matrix) (int, int) {
+  rows, cols := a.Dims()
+  rows, cols = min(rows, self.rows), min(cols, self.cols)
+  for i := 0; i < rows; i++ {
+    for j := 0; j < cols; j++ {
+      self.Set(i, j, a.At(i, j))
+    }
+  }
+  return rows, cols
+}
+
+// The first rows rows of the matrix, sharing its data.
+func (self *Dense32) topRows(rows int) *Dense32 {
+  return &Dense32{rows, self.cols, self.data[:rows * self.cols]}
+}
+
+// Resize to rows x cols, only allocating if the size changes.
+func (self *Dense32) resize(rows, cols int) {
+  if self.rows != rows || self.cols != cols {
+    *self = *NewDense32(rows, cols, nil)
+  }
+}
+
+// Intermediate results and workspaces of FLOAT32 layers, in the same layout as
+// the float64 fields of Layer.
+type layer32 struct {
+  input *Dense32  // examples x inputs
+  output *Dense32  // examples x outputs
+  deltas *Dense32  // outputs x examples
+  derivatives *Dense32  // outputs x examples
+  // Input converted from float64, used by the first layer.
+  converted *Dense32  // examples x inputs
+
+  weight *Dense32  // View of Weight32 without biases, inputs x outputs
+  bias []float32  // View of Weight32's bias row
+  weightGradient *Dense32  // View of Gradient32 without biases
+  biasGradient []float32  // View of Gradient32's bias row
+  transposedGradient *Dense32  // outputs x inputs
+  accumulatedGradient *Dense32  // (inputs + 1) x outputs
+}
+
+// Create a layer that trains and evaluates at float32 precision.
+func NewLayer32(name ActivationName, inputs int, outputs int,
+                weight []float32) *Layer {
+  layer := new(Layer)
+  layer.Name = name
+  layer.Precision = Precision_FLOAT32
+  layer.Weight32 = NewDense32(inputs + 1, outputs, weight)
+  layer.Gradient32 = NewDense32(inputs + 1, outputs, nil)
+  layer.Trainable = true
+  layer.RateMultiplier = 1
+  layer.InputShape = []int{inputs}
+  layer.OutputShape = []int{outputs}
+  layer.Output = &mat64.Dense{}
+
+  layer.state32 = &layer32{
+    output: &Dense32{},
+    deltas: &Dense32{},
+    derivatives: &Dense32{},
+    converted: &Dense32{},
+    weight: layer.Weight32.topRows(inputs),
+    bias: layer.Weight32.RawRowView(inputs),
+    weightGradient: layer.Gradient32.topRows(inputs),
+    biasGradient: layer.Gradient32.RawRowView(inputs),
+    transposedGradient: NewDense32(outputs, inputs, nil),
+  }
+  return layer
+}
+
+func (self* Layer) forward32(input *Dense32) {
+  state := self.state32
+  examples, _ := input.Dims()
+  _, outputs := self.Weight32.Dims()
+  if previousExamples, _ := state.output.Dims(); previousExamples != examples {
+    self.resizeOutput(examples, outputs)
+    state.output.resize(examples, outputs)
+    state.deltas.resize(outputs, examples)
+    state.derivatives.resize(outputs, examples)
+  }
+  state.input = input
+  self.backend().Mul32(state.output, input, state.weight)
+  for i := 0; i < examples; i++ {
+    row := state.output.RawRowView(i)
+    for j, bias := range state.bias {
+      row[j] += bias
+    }
+  }
+  activate32(self.Name, state.output, state.derivatives)
+  // Keep a float64 copy of the output for error functions and callers.
+  for i := 0; i < examples; i++ {
+    row := self.Output.RawRowView(i)
+    for j, v := range state.output.RawRowView(i) {
+      row[j] = float64(v)
+    }
+  }
+}
+
+// Convert input to float32 and run forward32 on it.
+func (self* Layer) forwardConverted(input *mat64.Dense) {
+  converted := self.state32.converted
+  examples, inputs := input.Dims()
+  converted.resize(examples, inputs)
+  for i := 0; i < examples; i++ {
+    row := converted.RawRowView(i)
+    for j, v := range input.RawRowView(i) {
+      row[j] = float32(v)
+    }
+  }
+  self.forward32(converted)
+}
+
+// Apply the activation function to x, a weighted input of examples x outputs,
+// in place, first storing its derivatives into derivatives, outputs x
+// examples. SOFTMAX derivatives are left for backwardActivation32.
+func activate32(name ActivationName, x *Dense32, derivatives *Dense32) {
+  examples, _ := x.Dims()
+  for i := 0; i < examples; i++ {
+    row := x.RawRowView(i)
+    if name == ActivationName_SOFTMAX {
+      sum := 0.0
+      for j, v := range row {
+        exp := math.Exp(float64(v))
+        row[j] = float32(exp)
+        sum += exp
+      }
+      for j, v := range row {
+        row[j] = float32(float64(v) / sum)
+      }
+      continue
+    }
+    for j, v := range row {
+      value, derivative := v, float32(1)
+      switch name {
+      case ActivationName_RELU:
+        if v <= 0 {
+          value, derivative = 0, 0
+        }
+      case ActivationName_LOGISTIC:
+        logistic := float32(1 / (1 + math.Exp(-float64(v))))
+        value, derivative = logistic, logistic * (1 - logistic)
+      case ActivationName_TANH:
+        tanh := float32(math.Tanh(float64(v)))
+        value, derivative = tanh, 1 - tanh * tanh
+      }
+      row[j] = value
+      derivatives.data[j * examples + i] = derivative
+    }
+  }
+}
+
+func (self* Layer) backward32(next *Layer) {
+  // Don't look at bias weights from next layer when backpropagating.
+  self.backend().Mul32(
+      self.state32.deltas, next.state32.weight, next.state32.deltas)
+  self.backwardActivation32()
+  self.computeGradient32()
+}
+
+func (self* Layer) backwardOutput32(values *mat64.Dense, weights []float64,
+                                    error_function ErrorFunction) {
+  deltas := error_function.Deltas(values, self.Output)
+  state := self.state32
+  outputs, examples := state.deltas.Dims()
+  for k := 0; k < examples; k++ {
+    weight := 1.0
+    if weights != nil {
+      weight = weights[k]
+    }
+    for j := 0; j < outputs; j++ {
+      state.deltas.data[j * examples + k] =
+          float32(weight * deltas.At(k, j))
+    }
+  }
+  self.backwardActivation32()
+  self.computeGradient32()
+}
+
+// Float32 version of backwardActivation.
+func (self* Layer) backwardActivation32() {
+  state := self.state32
+  if self.Name != ActivationName_SOFTMAX {
+    self.backend().MulElem32(state.deltas, state.deltas, state.derivatives)
+    return
+  }
+  outputs, examples := state.deltas.Dims()
+  for k := 0; k < examples; k++ {
+    output := state.output.RawRowView(k)
+    dot := float32(0)
+    for j := 0; j < outputs; j++ {
+      dot += state.deltas.data[j * examples + k] * output[j]
+    }
+    for i := 0; i < outputs; i++ {
+      delta := &state.deltas.data[i * examples + k]
+      *delta = output[i] * (*delta - dot)
+    }
+  }
+}
+
+// Float32 version of computeGradient.
+func (self* Layer) computeGradient32() {
+  if !self.Trainable {
+    return
+  }
+  state := self.state32
+  self.backend().Mul32(state.transposedGradient, state.deltas, state.input)
+  inputs, outputs := state.weightGradient.Dims()
+  for i := 0; i < inputs; i++ {
+    row := state.weightGradient.RawRowView(i)
+    for j := range row {
+      row[j] = state.transposedGradient.data[j * inputs + i]
+    }
+  }
+  for j := 0; j < outputs; j++ {
+    bias := float32(0)
+    for _, delta := range state.deltas.RawRowView(j) {
+      bias += delta
+    }
+    state.biasGradient[j] = bias
+  }
+}
+
+// Float32 version of Accumulate.
+func (self* Layer) accumulate32() {
+  state := self.state32
+  if state.accumulatedGradient == nil {
+    rows, cols := self.Gradient32.Dims()
+    state.accumulatedGradient = NewDense32(rows, cols, nil)
+  }
+  for i, gradient := range self.Gradient32.data {
+    if self.accumulated == 0 {
+      state.accumulatedGradient.data[i] = gradient
+    } else {
+      state.accumulatedGradient.data[i] += gradient
+    }
+  }
+  self.accumulated++
+}
+
+// Float32 version of clipGradient.
+func (self* Layer) clipGradient32(clipValue float64) float64 {
+  gradient := self.Gradient32.data
+  if self.accumulated > 0 {
+    for i, accumulated := range self.state32.accumulatedGradient.data {
+      gradient[i] += accumulated
+    }
+    self.accumulated = 0
+  }
+  squaredNorm := 0.0
+  for i, v := range gradient {
+    if clipValue > 0 {
+      v = float32(math.Max(-clipValue, math.Min(clipValue, float64(v))))
+      gradient[i] = v
+    }
+    squaredNorm += float64(v) * float64(v)
+  }
+  return squaredNorm
+}
+
+// Float32 version of applyGradient.
+func (self* Layer) applyGradient32(learningConfiguration LearningConfiguration,
+                                   scale float64) {
+  state := self.state32
+  rate := float32(*learningConfiguration.Rate * self.RateMultiplier)
+  l2 := float32(*learningConfiguration.Decay + self.L2)
+  l1 := float32(self.L1)
+  scale32 := float32(scale)
+  for i, w := range state.weight.data {
+    update := scale32 * state.weightGradient.data[i] + l2 * w
+    if w > 0 {
+      update += l1
+    } else if w < 0 {
+      update -= l1
+    }
+    state.weight.data[i] = w - rate * update
+  }
+  self.constrain32()
+}
+
+// Float32 version of constrain.
+func (self* Layer) constrain32() {
+  weight := self.state32.weight
+  if self.NonNegative {
+    for i, w := range weight.data {
+      if w < 0 {
+        weight.data[i] = 0
+      }
+    }
+  }
+  if self.MaxNorm <= 0 && !self.UnitNorm {
+    return
+  }
+  rows, cols := weight.Dims()
+  for j := 0; j < cols; j++ {
+    norm := 0.0
+    for i := 0; i < rows; i++ {
+      norm += weight.At(i, j) * weight.At(i, j)
+    }
+    norm = math.Sqrt(norm)
+    target := norm
+    if self.UnitNorm {
+      target = 1
+    } else if norm > self.MaxNorm {
+      target = self.MaxNorm
+    }
+    if norm == 0 || target == norm {
+      continue
+    }
+    for i := 0; i < rows; i++ {
+      weight.Set(i, j, weight.At(i, j) * target / norm)
+    }
+  }
+}
@@ -0,0 +1,110 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "io/ioutil";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+func readCircleDatapoints(t *testing.T) []neural.Datapoint {
+  bytes, err := ioutil.ReadFile("../examples/circle/training.txt")
+  if err != nil {
+    t.Fatal(err)
+  }
+  var datapoints []neural.Datapoint
+  if err = json.Unmarshal(bytes, &datapoints); err != nil {
+    t.Fatal(err)
+  }
+  return datapoints
+}
+
+// Copy of neuralNetwork at precision.
+func withPrecision(t *testing.T, neuralNetwork *neural.Network,
+                   precision neural.Precision) *neural.Network {
+  var networkConfiguration neural.NetworkConfiguration
+  if err := json.Unmarshal(
+      neuralNetwork.Serialize(), &networkConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  networkConfiguration.Precision = precision.Enum()
+  return neural.NewNetwork(networkConfiguration)
+}
+
+// Parity is checked on the circle example only, since tests don't have MNIST.
+func TestFloat32MatchesFloat64(t *testing.T) {
+  datapoints := readCircleDatapoints(t)
+  bytes, err := ioutil.ReadFile("../examples/circle/network.txt")
+  if err != nil {
+    t.Fatal(err)
+  }
+  network64 := new(neural.Network)
+  if err = network64.Deserialize(bytes); err != nil {
+    t.Fatal(err)
+  }
+  rand.Seed(1)
+  network64.RandomizeSynapses()
+  network32 := withPrecision(t, network64, neural.Precision_FLOAT32)
+  if network32.Layers[0].Weight32 == nil || network32.Layers[0].Weight != nil {
+    t.Fatalf("FLOAT32 network has unexpected weights")
+  }
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(10),
+    Rate: proto.Float64(0.001),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+  }
+  initialError, err := neural.Evaluate(*network64, datapoints)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, neuralNetwork := range []*neural.Network{network64, network32} {
+    rand.Seed(2)
+    if err = neural.Train(
+        neuralNetwork, datapoints, learningConfiguration); err != nil {
+      t.Fatal(err)
+    }
+  }
+  error64, err := neural.Evaluate(*network64, datapoints)
+  if err != nil {
+    t.Fatal(err)
+  }
+  error32, err := neural.Evaluate(*network32, datapoints)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if error64 >= initialError {
+    t.Errorf("float64 error %v didn't improve on %v", error64, initialError)
+  }
+  if !equalsApprox(error64, error32, 0.01 * error64) {
+    t.Errorf("float32 error %v differs from float64 error %v", error32,
+             error64)
+  }
+}
+
+func TestSerializeFloat32(t *testing.T) {
+  neuralNetwork := createMNISTNetwork()
+  network32 := withPrecision(t, neuralNetwork, neural.Precision_FLOAT32)
+  serialized := network32.Serialize()
+  // JSON float32 weights have fewer digits, but aren't half the size.
+  if len(serialized) > len(neuralNetwork.Serialize()) * 6 / 10 {
+    t.Errorf("float32 network is %v bytes, float64 network %v bytes",
+             len(serialized), len(neuralNetwork.Serialize()))
+  }
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(serialized); err != nil {
+    t.Fatal(err)
+  }
+  if deserialized.Precision != neural.Precision_FLOAT32 {
+    t.Errorf("precision %v unexpected", deserialized.Precision)
+  }
+  for i, layer := range deserialized.Layers {
+    if !mat64.Equal(layer.Weights(), network32.Layers[i].Weights()) {
+      t.Errorf("layer %v weights changed by serialization", i)
+    }
+  }
+}
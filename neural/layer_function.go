@@ -0,0 +1,122 @@
+package neural
+
+import (
+  "github.com/gonum/matrix/mat64";
+  "neural/autodiff"
+)
+
+// Forward pass of a layer from input, examples x inputs, weight, inputs x
+// outputs or nil for layers without weights, and bias, 1 x outputs, to output,
+// examples x outputs. Layers with a LayerFunction compute their gradients by
+// automatic differentiation, so new layer types need no hand-written backward
+// pass.
+type LayerFunction func(tape *autodiff.Tape,
+                        input, weight, bias *autodiff.Variable) *autodiff.Variable
+
+// The dense layer with activation name, as computed by Layer without a
+// Function.
+func DenseFunction(name ActivationName) LayerFunction {
+  return func(tape *autodiff.Tape,
+              input, weight, bias *autodiff.Variable) *autodiff.Variable {
+    weighted := autodiff.AddRow(autodiff.MatMul(input, weight), bias)
+    switch name {
+    case ActivationName_RELU:
+      return autodiff.ReLU(weighted)
+    case ActivationName_LOGISTIC:
+      return autodiff.Sigmoid(weighted)
+    case ActivationName_TANH:
+      return autodiff.Tanh(weighted)
+    case ActivationName_SOFTMAX:
+      return autodiff.Softmax(weighted)
+    }
+    return weighted
+  }
+}
+
+// Variables recorded by the forward pass of a layer with a Function.
+type layerTape struct {
+  tape *autodiff.Tape
+  input, weight, bias, output *autodiff.Variable
+}
+
+func (self* Layer) forwardTape(input *mat64.Dense) {
+  examples, _ := input.Dims()
+  self.resetForExamples(examples)
+  self.Input = input
+  if self.tape == nil {
+    self.tape = &layerTape{tape: autodiff.NewTape()}
+  }
+  state := self.tape
+  state.tape.Reset()
+  state.input = state.tape.Variable(input)
+  state.weight = nil
+  if rows, _ := self.weight.Dims(); rows > 0 {
+    state.weight = state.tape.Variable(self.weight)
+  }
+  state.bias = state.tape.Variable(
+      mat64.NewDense(1, len(self.bias), self.bias))
+  state.output = self.Function(state.tape, state.input, state.weight,
+                               state.bias)
+  self.Output.Copy(state.output.Value)
+}
+
+// Backpropagate Deltas, the gradient of the cost with respect to Output
+// transposed, through the recorded forward pass.
+func (self* Layer) backwardTape() {
+  state := self.tape
+  var seed mat64.Dense
+  seed.Clone(self.Deltas.T())
+  state.tape.Backward(state.output, &seed)
+  if !self.Trainable {
+    return
+  }
+  if state.weight != nil {
+    self.weightGradient.Copy(state.weight.Gradient)
+  }
+  copy(self.biasGradient, state.bias.Gradient.RawRowView(0))
+}
+
+// Set deltas, outputs x examples, to the gradient of the cost with respect to
+// this layer's input after its backward pass, for the previous layer.
+func (self* Layer) inputDeltas(deltas *mat64.Dense) {
+  if self.Function == nil {
+    // Don't look at bias weights when backpropagating.
+    self.backend().Mul(deltas, self.weight, self.Deltas)
+    return
+  }
+  deltas.Copy(self.tape.input.Gradient.T())
+}
+
+// An ErrorFunction defined by its cost alone, whose deltas are computed by
+// automatic differentiation.
+type AutodiffErrorFunction struct {
+  // Total cost, 1 x 1, of outputs given values, both examples x outputs.
+  Loss func(tape *autodiff.Tape,
+            values, outputs *autodiff.Variable) *autodiff.Variable
+}
+
+func (m* AutodiffErrorFunction) Cost(
+    values mat64.Matrix, outputs mat64.Matrix) float64 {
+  tape := autodiff.NewTape()
+  cost := m.Loss(tape, tape.Variable(denseOf(values)),
+                 tape.Variable(denseOf(outputs)))
+  return cost.Value.At(0, 0)
+}
+
+func (m* AutodiffErrorFunction) Deltas(
+    values mat64.Matrix, outputs mat64.Matrix) mat64.Matrix {
+  tape := autodiff.NewTape()
+  output := tape.Variable(denseOf(outputs))
+  tape.Backward(m.Loss(tape, tape.Variable(denseOf(values)), output), nil)
+  return output.Gradient
+}
+
+// m as a *mat64.Dense, copying it if necessary.
+func denseOf(m mat64.Matrix) *mat64.Dense {
+  if dense, ok := m.(*mat64.Dense); ok {
+    return dense
+  }
+  var dense mat64.Dense
+  dense.Clone(m)
+  return &dense
+}
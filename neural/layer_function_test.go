@@ -0,0 +1,175 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math/rand";
+  "testing"
+  "neural";
+  "neural/autodiff";
+)
+
+// Copy of neuralNetwork whose layers compute gradients by automatic
+// differentiation.
+func withAutodiff(t *testing.T, neuralNetwork *neural.Network) *neural.Network {
+  var networkConfiguration neural.NetworkConfiguration
+  if err := json.Unmarshal(
+      neuralNetwork.Serialize(), &networkConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  for _, layerConfiguration := range networkConfiguration.Layer {
+    layerConfiguration.Autodiff = proto.Bool(true)
+  }
+  return neural.NewNetwork(networkConfiguration)
+}
+
+func TestAutodiffMatchesDense(t *testing.T) {
+  datapoints := readCircleDatapoints(t)
+  for _, output := range []neural.ActivationName{
+      neural.ActivationName_LOGISTIC, neural.ActivationName_SOFTMAX} {
+    rand.Seed(1)
+    dense := neural.NewNetwork(neural.NetworkConfiguration{
+        Inputs: proto.Int32(2),
+        Layer: []*neural.LayerConfiguration{
+            &neural.LayerConfiguration{
+                Name: neural.ActivationName_RELU.Enum(),
+                Outputs: proto.Int32(8),
+            },
+            &neural.LayerConfiguration{
+                Name: neural.ActivationName_TANH.Enum(),
+                Outputs: proto.Int32(4),
+            },
+            &neural.LayerConfiguration{
+                Name: output.Enum(),
+                Outputs: proto.Int32(1),
+            },
+        },
+    })
+    dense.RandomizeSynapses()
+    automatic := withAutodiff(t, dense)
+    if automatic.Layers[0].Function == nil {
+      t.Fatalf("%v: autodiff layer has no Function", output)
+    }
+    learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(3),
+      Rate: proto.Float64(0.01),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(10),
+      ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+    }
+    for _, neuralNetwork := range []*neural.Network{dense, automatic} {
+      rand.Seed(2)
+      if err := neural.Train(
+          neuralNetwork, datapoints, learningConfiguration); err != nil {
+        t.Fatal(err)
+      }
+    }
+    for i, layer := range automatic.Layers {
+      if !mat64.EqualApprox(layer.Weights(), dense.Layers[i].Weights(),
+                            1e-9) {
+        t.Errorf("%v: layer %v weights differ from dense layer", output, i)
+      }
+      if !mat64.EqualApprox(layer.Gradients(), dense.Layers[i].Gradients(),
+                            1e-9) {
+        t.Errorf("%v: layer %v gradients differ from dense layer", output, i)
+      }
+    }
+    automaticError, err := neural.Evaluate(*automatic, datapoints)
+    if err != nil {
+      t.Fatal(err)
+    }
+    denseError, err := neural.Evaluate(*dense, datapoints)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !equalsApprox(automaticError, denseError, 1e-9) {
+      t.Errorf("%v: autodiff error differs from dense error", output)
+    }
+  }
+}
+
+func TestLayerFunctionGradientCheck(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+      Inputs: proto.Int32(3),
+      Layer: []*neural.LayerConfiguration{
+          &neural.LayerConfiguration{
+              Name: neural.ActivationName_LINEAR.Enum(),
+              Outputs: proto.Int32(4),
+          },
+          &neural.LayerConfiguration{
+              Name: neural.ActivationName_LOGISTIC.Enum(),
+              Outputs: proto.Int32(2),
+          },
+      },
+  })
+  neuralNetwork.RandomizeSynapses()
+  // A gated layer defined by its forward pass alone.
+  neuralNetwork.Layers[0].Function = func(
+      tape *autodiff.Tape,
+      input, weight, bias *autodiff.Variable) *autodiff.Variable {
+    weighted := autodiff.AddRow(autodiff.MatMul(input, weight), bias)
+    return autodiff.MulElem(autodiff.Tanh(weighted),
+                            autodiff.Sigmoid(weighted))
+  }
+  datapoints := make([]neural.Datapoint, 5)
+  for i := range datapoints {
+    for j := 0; j < 3; j++ {
+      datapoints[i].Features = append(
+          datapoints[i].Features, rand.NormFloat64())
+    }
+    datapoints[i].Values = []float64{rand.Float64(), rand.Float64()}
+  }
+  for i, maxError := range neural.GradientCheck(
+      neuralNetwork, datapoints, new(neural.QuadraticErrorFunction)) {
+    if !(maxError < 1e-4) {
+      t.Errorf("layer %v relative error %v too large", i, maxError)
+    }
+  }
+}
+
+func TestAutodiffErrorFunction(t *testing.T) {
+  quadratic := &neural.AutodiffErrorFunction{
+    Loss: func(tape *autodiff.Tape,
+               values, outputs *autodiff.Variable) *autodiff.Variable {
+      return autodiff.Scale(0.5, autodiff.Sum(
+          autodiff.Square(autodiff.Sub(outputs, values))))
+    },
+  }
+  crossEntropy := &neural.AutodiffErrorFunction{
+    Loss: func(tape *autodiff.Tape,
+               values, outputs *autodiff.Variable) *autodiff.Variable {
+      rows, cols := values.Value.Dims()
+      ones := mat64.NewDense(rows, cols, nil)
+      ones.Apply(func(i, j int, v float64) float64 { return 1 }, ones)
+      one := tape.Variable(ones)
+      return autodiff.Scale(-1, autodiff.Sum(autodiff.Add(
+          autodiff.MulElem(values, autodiff.Log(outputs)),
+          autodiff.MulElem(autodiff.Sub(one, values),
+                           autodiff.Log(autodiff.Sub(one, outputs))))))
+    },
+  }
+  rand.Seed(1)
+  values, outputs := mat64.NewDense(4, 3, nil), mat64.NewDense(4, 3, nil)
+  for i := 0; i < 4; i++ {
+    for j := 0; j < 3; j++ {
+      values.Set(i, j, rand.Float64())
+      outputs.Set(i, j, 0.05 + 0.9 * rand.Float64())
+    }
+  }
+  for name, pair := range map[string][2]neural.ErrorFunction{
+      "quadratic": {quadratic, new(neural.QuadraticErrorFunction)},
+      "cross entropy": {crossEntropy, new(neural.CrossEntropyErrorFunction)},
+  } {
+    cost, expectedCost := pair[0].Cost(values, outputs),
+                          pair[1].Cost(values, outputs)
+    if !equalsApprox(cost, expectedCost, 1e-9) {
+      t.Errorf("%v: cost %v, expected %v", name, cost, expectedCost)
+    }
+    if !mat64.EqualApprox(pair[0].Deltas(values, outputs),
+                          pair[1].Deltas(values, outputs), 1e-9) {
+      t.Errorf("%v: deltas differ", name)
+    }
+  }
+}
@@ -0,0 +1,71 @@
+package neural_test
+
+import (
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "testing"
+  "neural";
+)
+
+func TestConstraints(t *testing.T) {
+  neuralNetwork := new(neural.Network)
+  if err := neuralNetwork.Deserialize([]byte(
+      "{\"inputs\":2,\"layer\":[{\"name\":0,\"outputs\":2,\"weight\":[3,-1," +
+      "4,0.5,7,7],\"max_norm\":2,\"non_negative\":true}]}")); err != nil {
+    t.Fatal(err)
+  }
+  neuralNetwork.Forward(mat64.NewDense(1, 2, []float64{0, 0}))
+  neuralNetwork.Backward(mat64.NewDense(1, 2, []float64{0, 0}),
+                         new(neural.QuadraticErrorFunction))
+  // With no inputs the gradient is zero, so only constraints apply.
+  neuralNetwork.Update(neural.LearningConfiguration{
+      Rate: proto.Float64(1),
+      Decay: proto.Float64(0),
+  })
+  // Column 0 has norm 5 and is scaled to 2, column 1 loses its negative
+  // weight. Biases are unconstrained.
+  expected := mat64.NewDense(3, 2, []float64{1.2, 0, 1.6, 0.5, 7, 7})
+  if !mat64.EqualApprox(neuralNetwork.Layers[0].Weight, expected, 0.0001) {
+    t.Errorf("constrained weights unexpected:\n%v",
+             mat64.Formatted(neuralNetwork.Layers[0].Weight))
+  }
+
+  // Constraints survive serialization.
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if deserialized.Layers[0].MaxNorm != 2 ||
+     !deserialized.Layers[0].NonNegative {
+    t.Errorf("constraints not serialized")
+  }
+}
+
+func TestPenalty(t *testing.T) {
+  neuralNetwork := new(neural.Network)
+  if err := neuralNetwork.Deserialize([]byte(
+      "{\"inputs\":1,\"layer\":[{\"name\":0,\"outputs\":2,\"weight\":[2,-1," +
+      "5,5],\"l1\":0.5,\"l2\":1}]}")); err != nil {
+    t.Fatal(err)
+  }
+  // L1: 0.5 * (2 + 1). L2 with decay: 0.5 * (1 + 1) * (4 + 1).
+  if penalty := neuralNetwork.Penalty(1);
+     !equalsApprox(1.5 + 5, penalty, 0.0001) {
+    t.Errorf("penalty %v unexpected", penalty)
+  }
+
+  // L1 pulls weights towards zero.
+  neuralNetwork.Forward(mat64.NewDense(1, 1, []float64{0}))
+  neuralNetwork.Backward(mat64.NewDense(1, 2, []float64{5, 5}),
+                         new(neural.QuadraticErrorFunction))
+  neuralNetwork.Layers[0].L2 = 0
+  neuralNetwork.Update(neural.LearningConfiguration{
+      Rate: proto.Float64(0.1),
+      Decay: proto.Float64(0),
+  })
+  weight := neuralNetwork.Layers[0].Weight
+  if !equalsApprox(1.95, weight.At(0, 0), 0.0001) ||
+     !equalsApprox(-0.95, weight.At(0, 1), 0.0001) {
+    t.Errorf("L1 weights unexpected:\n%v", mat64.Formatted(weight))
+  }
+}
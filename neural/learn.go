@@ -1,13 +1,166 @@
 package neural
 
 import (
+  "fmt";
+  "github.com/golang/protobuf/proto";
   "github.com/gonum/matrix/mat64";
-  "math/rand"
+  "io";
+  "math"
 )
 
 type Datapoint struct {
   Features []float64
   Values []float64
+  // Relative importance of this example in training and evaluation. Zero
+  // (the default) is treated as 1, so datapoints read without a weight count
+  // fully; leave an example out of the datapoints to exclude it.
+  Weight float64
+  // Shape of Features, such as channels x height x width, whose product is
+  // len(Features). nil for a vector.
+  Shape []int
+  // For sparse features, the index of each of Features, every other feature
+  // being 0: empty, not nil, if every feature is 0. nil for dense features.
+  // Training on sparse features only touches the first layer's weights for
+  // features in the batch, unless the layer is regularized or constrained or
+  // gradients are accumulated, which touch every weight.
+  Indices []int
+}
+
+// Features as a tensor of Shape.
+func (self *Datapoint) FeatureTensor() *Tensor {
+  if self.Shape == nil {
+    return NewTensor([]int{len(self.Features)}, self.Features)
+  }
+  return NewTensor(self.Shape, self.Features)
+}
+
+// Features of every datapoint stacked into an examples x Shape tensor, using
+// the first datapoint's shape.
+func FeatureTensor(datapoints []Datapoint) *Tensor {
+  shape := append([]int{len(datapoints)},
+                  datapoints[0].FeatureTensor().Shape...)
+  tensor := NewTensor(shape, nil)
+  size := len(datapoints[0].Features)
+  for i, datapoint := range datapoints {
+    copy(tensor.Data[i * size:(i + 1) * size], datapoint.Features)
+  }
+  return tensor
+}
+
+func (self *Datapoint) weight() float64 {
+  return ExampleWeight(self.Weight)
+}
+
+// Weight of an example whose Weight field is weight, treating 0 as 1.
+func ExampleWeight(weight float64) float64 {
+  if weight == 0 {
+    return 1
+  }
+  return weight
+}
+
+func (self *Datapoint) class() int {
+  return Label(self.Values)
+}
+
+// Class of an example with values: the index of the largest value if there are
+// several, otherwise the single value rounded to the nearest integer.
+func Label(values []float64) int {
+  if len(values) == 1 {
+    return int(math.Floor(values[0] + 0.5))
+  }
+  return Argmax(values)
+}
+
+// Weight for each class in datapoints, inversely proportional to the class's
+// total example weight, such that every class contributes equally.
+func ClassWeights(datapoints []Datapoint) map[int]float64 {
+  totals := newClassTotals()
+  totals.add(datapoints)
+  return totals.classWeights()
+}
+
+// Total example weight of each class, and of every class, counted a batch at a
+// time.
+type classTotals struct {
+  totals map[int]float64
+  total float64
+}
+
+func newClassTotals() *classTotals {
+  return &classTotals{totals: make(map[int]float64)}
+}
+
+func (self *classTotals) add(datapoints []Datapoint) {
+  for i := range datapoints {
+    self.totals[datapoints[i].class()] += datapoints[i].weight()
+    self.total += datapoints[i].weight()
+  }
+}
+
+// ClassWeights of the datapoints added so far.
+func (self *classTotals) classWeights() map[int]float64 {
+  classWeights := make(map[int]float64)
+  for class, classTotal := range self.totals {
+    classWeights[class] =
+        self.total / (float64(len(self.totals)) * classTotal)
+  }
+  return classWeights
+}
+
+// Return a one-hot encoding of label among classes. Panics unless
+// 0 <= label < classes.
+func OneHot(label int, classes int) []float64 {
+  values := make([]float64, classes)
+  values[label] = 1
+  return values
+}
+
+// Return datapoints with single integer labels replaced by their one-hot
+// encoding among classes, or an error if a label isn't one of classes.
+// Datapoints that already have a value per class, including multi-label
+// indicators, are returned unchanged.
+func OneHotEncode(datapoints []Datapoint,
+                  classes int) ([]Datapoint, error) {
+  if len(datapoints) == 0 || len(datapoints[0].Values) != 1 || classes < 2 {
+    return datapoints, nil
+  }
+  if err := checkLabels(datapoints, classes); err != nil {
+    return nil, err
+  }
+  encoded := make([]Datapoint, len(datapoints))
+  for i, datapoint := range datapoints {
+    encoded[i] = datapoint
+    encoded[i].Values = OneHot(datapoint.class(), classes)
+  }
+  return encoded, nil
+}
+
+// Return an error if the single integer label of any of datapoints isn't one
+// of classes.
+func checkLabels(datapoints []Datapoint, classes int) error {
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    if len(datapoint.Values) != 1 {
+      continue
+    }
+    if class := datapoint.class(); class < 0 || class >= classes {
+      return fmt.Errorf("neural: label %v of datapoint %v isn't one of %v " +
+                        "classes", datapoint.Values[0], i, classes)
+    }
+  }
+  return nil
+}
+
+// Index of the largest of values, the first if several are largest.
+func Argmax(values []float64) int {
+  index := 0
+  for i, value := range values {
+    if value > values[index] {
+      index = i
+    }
+  }
+  return index
 }
 
 func min(a, b int) int {
@@ -17,41 +170,418 @@ func min(a, b int) int {
   return b
 }
 
+// Train neuralNetwork on datapoints. Returns an error describing where
+// training went wrong if the loss or weights become NaN or infinite and
+// learningConfiguration's non_finite_action is ABORT.
 func Train(neuralNetwork *Network, datapoints []Datapoint,
-           learningConfiguration LearningConfiguration) {
-  // Train on some number of iterations of permuted versions of the input.
-  batchSize := int(*learningConfiguration.BatchSize)
+           learningConfiguration LearningConfiguration) error {
   // Batch size 0 means do full batch learning.
-  if batchSize == 0 {
-    batchSize = len(datapoints)
+  if learningConfiguration.GetBatchSize() == 0 {
+    learningConfiguration.BatchSize = proto.Int32(int32(len(datapoints)))
+  }
+  return TrainDataset(neuralNetwork, SliceDataset(datapoints),
+                      learningConfiguration)
+}
+
+// Like Train, but reading datapoints a batch at a time from dataset, which
+// needs a batch_size. Gradients of a last, partial accumulation are applied
+// after the last batch.
+func TrainDataset(neuralNetwork *Network, dataset Dataset,
+                  learningConfiguration LearningConfiguration) error {
+  batchSize := int(learningConfiguration.GetBatchSize())
+  if batchSize <= 0 {
+    return fmt.Errorf("neural: training a dataset needs a batch size, not %v",
+                      batchSize)
+  }
+  // Prepare each batch's datapoints for training.
+  prepare := func(batch []Datapoint) ([]Datapoint, error) {
+    if learningConfiguration.GetAutoencoder() {
+      batch = AutoencoderDatapoints(batch)
+    }
+    return neuralNetwork.encodeLabels(batch)
   }
   error_function := NewErrorFunction(*learningConfiguration.ErrorName)
-  features := mat64.NewDense(batchSize, len(datapoints[0].Features), nil)
-  values := mat64.NewDense(batchSize, len(datapoints[0].Values), nil)
-  for i := 0; i < int(*learningConfiguration.Epochs); i++ {
-    perm := rand.Perm(len(datapoints))
-    // TODO(ariw): This misses the last len(perm) % batchSize examples. Is this
-    // okay?
-    for j := 0; j <= len(perm) - batchSize; j += batchSize {
-      for k := 0; k < batchSize; k++ {
-        features.SetRow(k, datapoints[perm[j + k]].Features)
-        values.SetRow(k, datapoints[perm[j + k]].Values)
+  var classWeights map[int]float64
+  if learningConfiguration.GetBalanceClasses() {
+    var err error
+    if classWeights, err = datasetClassWeights(dataset, batchSize,
+                                               prepare); err != nil {
+      return err
+    }
+  }
+  computeLoss :=
+      learningConfiguration.GetNonFiniteAction() != NonFiniteAction_IGNORE
+  inputs := neuralNetwork.Layers[0].Inputs()
+  // Batches of sparse features are never densified.
+  sparseFeatures := NewSparse(inputs)
+  var features, values *mat64.Dense
+  var weights []float64
+  step := func(batch []Datapoint) (float64, error) {
+    batch, err := prepare(batch)
+    if err != nil {
+      return 0, err
+    }
+    sparse := batch[0].Indices != nil
+    if sparse {
+      if err := checkSparse(batch, neuralNetwork.Layers[0]); err != nil {
+        return 0, err
+      }
+      sparseFeatures.Reset()
+    } else {
+      if len(batch[0].Features) == 0 {
+        return 0, fmt.Errorf("neural: dense datapoint without features")
+      }
+      features = resizeDense(features, len(batch), len(batch[0].Features))
+    }
+    values = resizeDense(values, len(batch), len(batch[0].Values))
+    if len(weights) != len(batch) {
+      weights = make([]float64, len(batch))
+    }
+    for k := range batch {
+      datapoint := &batch[k]
+      if sparse {
+        sparseFeatures.AppendRow(datapoint.Indices, datapoint.Features)
+      } else {
+        features.SetRow(k, datapoint.Features)
+      }
+      values.SetRow(k, datapoint.Values)
+      weights[k] = datapoint.weight()
+      if classWeights != nil {
+        weights[k] *= classWeights[datapoint.class()]
+      }
+    }
+    if sparse {
+      neuralNetwork.ForwardSparse(sparseFeatures)
+    } else {
+      if corruption := learningConfiguration.GetCorruption(); corruption > 0 {
+        corrupt(features, corruption)
       }
       neuralNetwork.Forward(features)
-      neuralNetwork.Backward(values, error_function)
-      neuralNetwork.Update(learningConfiguration)
     }
+    loss := 0.0
+    if computeLoss {
+      loss = error_function.Cost(
+          values, neuralNetwork.Layers[len(neuralNetwork.Layers) - 1].Output)
+    }
+    neuralNetwork.BackwardWeighted(values, weights, error_function)
+    return loss, nil
   }
+  return trainBatches(neuralNetwork.Layers, dataset, learningConfiguration,
+                      step, func(loss float64) error {
+                        return checkFinite(neuralNetwork, loss)
+                      })
 }
 
-// Return mean squared error of the network on datapoints.
-func Evaluate(neuralNetwork Network, datapoints []Datapoint) float64 {
+// Train layers on dataset by learningConfiguration, the loop shared by
+// TrainDataset and TrainGraphDataset. step computes the layers' gradients from
+// each batch and returns its cost, which is only checked for NaN or infinity
+// unless non_finite_action is IGNORE. checkFinite returns an error naming where
+// weights or loss, if NaN or infinite, went wrong; it only scans the layers
+// after a non-finite cost and once after training.
+func trainBatches(layers []*Layer, dataset Dataset,
+                  learningConfiguration LearningConfiguration,
+                  step func(batch []Datapoint) (float64, error),
+                  checkFinite func(loss float64) error) error {
+  batchSize := int(learningConfiguration.GetBatchSize())
+  if batchSize <= 0 {
+    return fmt.Errorf("neural: training a dataset needs a batch size, not %v",
+                      batchSize)
+  }
+  // Nothing accumulated is left for later updates, even after an error.
+  defer discardAccumulated(layers)
+  nonFiniteAction := learningConfiguration.GetNonFiniteAction()
+  // Weights before the last update, which gave finite costs. Later snapshots
+  // reuse the first one's storage.
+  var lastFiniteWeights []*mat64.Dense
+  snapshot := func() {
+    if nonFiniteAction == NonFiniteAction_ROLLBACK {
+      lastFiniteWeights = copyWeights(layers, lastFiniteWeights)
+    }
+  }
+  snapshot()
+  accumulationSteps := int(learningConfiguration.GetAccumulationSteps())
+  // Batches whose gradients are to be applied by the next update.
+  pending := 0
+  // Return an error for non-finite weights or loss under ABORT, or roll back
+  // to the last finite weights under ROLLBACK.
+  handleNonFinite := func(loss float64) error {
+    err := checkFinite(loss)
+    if err == nil || nonFiniteAction == NonFiniteAction_ABORT {
+      return err
+    }
+    restoreWeights(layers, lastFiniteWeights)
+    // Gradients accumulated with the bad weights are discarded too.
+    discardAccumulated(layers)
+    pending = 0
+    learningConfiguration.Rate = proto.Float64(
+        *learningConfiguration.Rate *
+        learningConfiguration.GetRollbackRateScale())
+    return nil
+  }
+  for i := 0; i < int(*learningConfiguration.Epochs); i++ {
+    iterator, err := dataset.Batches(batchSize)
+    if err != nil {
+      return err
+    }
+    for j := 0; ; j++ {
+      batch, err := iterator.Next()
+      if err == io.EOF {
+        break
+      }
+      if err != nil {
+        iterator.Close()
+        return err
+      }
+      cost, err := step(batch)
+      if err != nil {
+        iterator.Close()
+        return err
+      }
+      if nonFiniteAction != NonFiniteAction_IGNORE &&
+         (math.IsNaN(cost) || math.IsInf(cost, 0)) {
+        // The batch or the last update went wrong, so skip the batch.
+        if err := handleNonFinite(cost); err != nil {
+          iterator.Close()
+          return fmt.Errorf("%v in epoch %v batch %v", err, i, j)
+        }
+        continue
+      }
+      // Only update once every accumulationSteps batches.
+      pending++
+      if pending < accumulationSteps {
+        accumulateLayers(layers)
+        continue
+      }
+      pending = 0
+      snapshot()
+      updateLayers(layers, learningConfiguration)
+    }
+    if err := iterator.Close(); err != nil {
+      return err
+    }
+  }
+  if pending > 0 {
+    snapshot()
+    updateAccumulated(layers, learningConfiguration)
+  }
+  if nonFiniteAction == NonFiniteAction_IGNORE {
+    return nil
+  }
+  // No cost follows the last update, so check its weights.
+  if err := handleNonFinite(0); err != nil {
+    return fmt.Errorf("%v after the last batch", err)
+  }
+  return nil
+}
+
+// Return ClassWeights of the datapoints of dataset, after prepare.
+func datasetClassWeights(dataset Dataset, batchSize int,
+                         prepare func([]Datapoint) ([]Datapoint, error)) (
+    map[int]float64, error) {
+  // In-memory datapoints need no pass, which would also shuffle them.
+  if datapoints, ok := dataset.(SliceDataset); ok {
+    prepared, err := prepare(datapoints)
+    if err != nil {
+      return nil, err
+    }
+    return ClassWeights(prepared), nil
+  }
+  iterator, err := dataset.Batches(batchSize)
+  if err != nil {
+    return nil, err
+  }
+  defer iterator.Close()
+  totals := newClassTotals()
+  for {
+    batch, err := iterator.Next()
+    if err == io.EOF {
+      return totals.classWeights(), nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    if batch, err = prepare(batch); err != nil {
+      return nil, err
+    }
+    totals.add(batch)
+  }
+}
+
+// Return an error naming the first layer with NaN or infinite weights or, if
+// loss is NaN or infinite, outputs.
+func checkFinite(neuralNetwork *Network, loss float64) error {
+  for i, layer := range neuralNetwork.Layers {
+    if nonFinite(layer.Weights()) {
+      return fmt.Errorf("neural: non-finite weights in layer %v (%v)", i,
+                        layer.Name)
+    }
+  }
+  if !math.IsNaN(loss) && !math.IsInf(loss, 0) {
+    return nil
+  }
+  for i, layer := range neuralNetwork.Layers {
+    if nonFinite(layer.Output) {
+      return fmt.Errorf("neural: non-finite loss %v from outputs of layer %v " +
+                        "(%v)", loss, i, layer.Name)
+    }
+  }
+  return fmt.Errorf("neural: non-finite loss %v", loss)
+}
+
+// Return weighted mean squared error of the network on datapoints, or an
+// error if a label isn't one of the network's classes.
+func Evaluate(neuralNetwork Network, datapoints []Datapoint) (float64, error) {
+  datapoints, err := neuralNetwork.encodeLabels(datapoints)
+  if err != nil {
+    return 0, err
+  }
   square_error := 0.0
-  for _, datapoint := range datapoints {
-    output := neuralNetwork.Evaluate(datapoint.Features)
-    for i, value := range datapoint.Values {
-      square_error += (value - output[i]) * (value - output[i])
+  total_weight := 0.0
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    output := neuralNetwork.EvaluateDatapoint(datapoint)
+    for j, value := range datapoint.Values {
+      square_error += datapoint.weight() * (value - output[j]) *
+                      (value - output[j])
+    }
+    total_weight += datapoint.weight()
+  }
+  return square_error / total_weight, nil
+}
+
+// Return the training objective of the network on datapoints: the weighted
+// mean cost of learningConfiguration's error function, including the KL
+// divergence of any GAUSSIAN_SAMPLE layer, plus the network's regularization
+// penalty. For variational autoencoders this is an estimate of the negative
+// evidence lower bound. Returns an error like Evaluate on labels that aren't
+// classes.
+//
+// Training doesn't minimize exactly this: each update follows the gradient of
+// a batch's summed, rather than mean, weighted cost plus the penalty, so the
+// penalty weighs batch_size times less against the cost than it does here.
+func Loss(neuralNetwork Network, datapoints []Datapoint,
+          learningConfiguration LearningConfiguration) (float64, error) {
+  if learningConfiguration.GetAutoencoder() {
+    datapoints = AutoencoderDatapoints(datapoints)
+  }
+  datapoints, err := neuralNetwork.encodeLabels(datapoints)
+  if err != nil {
+    return 0, err
+  }
+  error_function := NewErrorFunction(learningConfiguration.GetErrorName())
+  cost := 0.0
+  total_weight := 0.0
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    output := neuralNetwork.EvaluateDatapoint(datapoint)
+    cost += datapoint.weight() * error_function.Cost(
+        mat64.NewDense(1, len(datapoint.Values), datapoint.Values),
+        mat64.NewDense(1, len(output), output))
+    for _, layer := range neuralNetwork.Layers {
+      cost += datapoint.weight() * layer.Divergence()
     }
+    total_weight += datapoint.weight()
+  }
+  return cost / total_weight +
+         neuralNetwork.Penalty(learningConfiguration.GetDecay()), nil
+}
+
+// Return a copy of the network's output for each datapoint.
+func Outputs(neuralNetwork Network, datapoints []Datapoint) [][]float64 {
+  outputs := make([][]float64, len(datapoints))
+  for i := range datapoints {
+    outputs[i] = append(
+        []float64(nil), neuralNetwork.EvaluateDatapoint(&datapoints[i])...)
+  }
+  return outputs
+}
+
+// Return the weighted fraction of datapoints whose class the network predicts
+// correctly.
+func Accuracy(neuralNetwork Network, datapoints []Datapoint) float64 {
+  correct := 0.0
+  total_weight := 0.0
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    if predictedClass(neuralNetwork.EvaluateDatapoint(datapoint)) ==
+       datapoint.class() {
+      correct += datapoint.weight()
+    }
+    total_weight += datapoint.weight()
+  }
+  return correct / total_weight
+}
+
+type MultiLabelMetrics struct {
+  // Weighted fraction of individual labels predicted incorrectly.
+  HammingLoss float64
+  // F1 score over every label decision pooled together.
+  MicroF1 float64
+  // Mean of each class's F1 score. Classes that are never present or
+  // predicted score 0.
+  MacroF1 float64
+  // Weighted fraction of datapoints whose labels are all predicted correctly.
+  SubsetAccuracy float64
+}
+
+// Return multi-label classification metrics of the network on datapoints,
+// whose values are 0 or 1 indicators for each class. Every metric is 0 if
+// there are no datapoints.
+func EvaluateMultiLabel(neuralNetwork Network,
+                        datapoints []Datapoint) MultiLabelMetrics {
+  var metrics MultiLabelMetrics
+  if len(datapoints) == 0 {
+    return metrics
+  }
+  classes := len(datapoints[0].Values)
+  truePositives := make([]float64, classes)
+  falsePositives := make([]float64, classes)
+  falseNegatives := make([]float64, classes)
+  total_weight := 0.0
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    weight := datapoint.weight()
+    output := neuralNetwork.EvaluateDatapoint(datapoint)
+    wrong := 0
+    for j, value := range datapoint.Values {
+      predicted := output[j] >= neuralNetwork.Threshold(j)
+      actual := value >= 0.5
+      switch {
+      case predicted && actual:
+        truePositives[j] += weight
+      case predicted:
+        falsePositives[j] += weight
+        wrong++
+      case actual:
+        falseNegatives[j] += weight
+        wrong++
+      }
+    }
+    metrics.HammingLoss += weight * float64(wrong) / float64(classes)
+    if wrong == 0 {
+      metrics.SubsetAccuracy += weight
+    }
+    total_weight += weight
+  }
+  metrics.HammingLoss /= total_weight
+  metrics.SubsetAccuracy /= total_weight
+
+  var allTruePositives, allFalsePositives, allFalseNegatives float64
+  for j := 0; j < classes; j++ {
+    metrics.MacroF1 += f1(truePositives[j], falsePositives[j],
+                          falseNegatives[j]) / float64(classes)
+    allTruePositives += truePositives[j]
+    allFalsePositives += falsePositives[j]
+    allFalseNegatives += falseNegatives[j]
+  }
+  metrics.MicroF1 = f1(allTruePositives, allFalsePositives, allFalseNegatives)
+  return metrics
+}
+
+func f1(truePositives, falsePositives, falseNegatives float64) float64 {
+  if truePositives == 0 {
+    return 0
   }
-  return square_error / float64(len(datapoints))
+  return 2 * truePositives /
+         (2 * truePositives + falsePositives + falseNegatives)
 }
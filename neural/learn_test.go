@@ -0,0 +1,213 @@
+package neural_test
+
+import (
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "strings";
+  "testing"
+  "neural";
+)
+
+func TestClassWeights(t *testing.T) {
+  datapoints := []neural.Datapoint{
+      {Features: []float64{0}, Values: []float64{1, 0}},
+      {Features: []float64{0}, Values: []float64{1, 0}},
+      {Features: []float64{0}, Values: []float64{1, 0}},
+      {Features: []float64{0}, Values: []float64{0, 1}},
+  }
+  classWeights := neural.ClassWeights(datapoints)
+  if !equalsApprox(2.0 / 3.0, classWeights[0], 0.0001) {
+    t.Errorf("class 0 weight %v unexpected", classWeights[0])
+  }
+  if !equalsApprox(2, classWeights[1], 0.0001) {
+    t.Errorf("class 1 weight %v unexpected", classWeights[1])
+  }
+
+  // Explicit example weights count towards class frequency.
+  datapoints[3].Weight = 3
+  classWeights = neural.ClassWeights(datapoints)
+  if !equalsApprox(1, classWeights[0], 0.0001) ||
+     !equalsApprox(1, classWeights[1], 0.0001) {
+    t.Errorf("class weights %v unexpected", classWeights)
+  }
+}
+
+func TestEvaluateWeighted(t *testing.T) {
+  neuralNetwork := CreateSimpleNetwork(t)
+  datapoints := []neural.Datapoint{
+      {Features: []float64{0.05, 0.10},
+       Values: []float64{0.75136507, 0.772928465}},
+      {Features: []float64{0.05, 0.10},
+       Values: []float64{1.75136507, 0.772928465}, Weight: 3},
+  }
+  // Only the second datapoint has error, with 3/4 of the total weight.
+  weightedError, err := neural.Evaluate(*neuralNetwork, datapoints)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !equalsApprox(0.75, weightedError, 0.0001) {
+    t.Errorf("weighted error %v unexpected", weightedError)
+  }
+}
+
+func TestOneHotEncode(t *testing.T) {
+  datapoints := []neural.Datapoint{{Values: []float64{2}}}
+  encoded, err := neural.OneHotEncode(datapoints, 3)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(encoded[0].Values) != 3 || encoded[0].Values[2] != 1 ||
+     encoded[0].Values[0] != 0 {
+    t.Errorf("encoding %v unexpected", encoded[0].Values)
+  }
+  if len(datapoints[0].Values) != 1 {
+    t.Errorf("original datapoints modified")
+  }
+  for _, label := range []float64{-1, 3} {
+    if _, err := neural.OneHotEncode(
+        []neural.Datapoint{{Values: []float64{label}}}, 3); err == nil {
+      t.Errorf("encoded label %v of 3 classes", label)
+    }
+  }
+}
+
+func TestEvaluateMultiLabel(t *testing.T) {
+  // Outputs are the logistic of each feature, thresholded at 0.5 by default and
+  // at 0.9 for the second class.
+  neuralNetwork := new(neural.Network)
+  if err := neuralNetwork.Deserialize([]byte(
+      "{\"inputs\":2,\"layer\":[{\"name\":2,\"outputs\":2,\"weight\":[1,0," +
+      "0,1,0,0]}],\"classes\":2,\"multi_label\":true," +
+      "\"threshold\":[0.5,0.9]}")); err != nil {
+    t.Fatal(err)
+  }
+  datapoints := []neural.Datapoint{
+      // Both labels correct.
+      {Features: []float64{1, 3}, Values: []float64{1, 1}},
+      // Second label is a false negative at threshold 0.9.
+      {Features: []float64{-1, 1}, Values: []float64{0, 1}},
+      // First label is a false positive.
+      {Features: []float64{1, -1}, Values: []float64{0, 0}},
+  }
+  metrics := neural.EvaluateMultiLabel(*neuralNetwork, datapoints)
+  if !equalsApprox(2.0 / 6.0, metrics.HammingLoss, 0.0001) {
+    t.Errorf("hamming loss %v unexpected", metrics.HammingLoss)
+  }
+  if !equalsApprox(1.0 / 3.0, metrics.SubsetAccuracy, 0.0001) {
+    t.Errorf("subset accuracy %v unexpected", metrics.SubsetAccuracy)
+  }
+  // 2 true positives, 1 false positive, 1 false negative.
+  if !equalsApprox(4.0 / 6.0, metrics.MicroF1, 0.0001) {
+    t.Errorf("micro F1 %v unexpected", metrics.MicroF1)
+  }
+  if !equalsApprox((2.0 / 3.0 + 2.0 / 3.0) / 2, metrics.MacroF1, 0.0001) {
+    t.Errorf("macro F1 %v unexpected", metrics.MacroF1)
+  }
+  if metrics := neural.EvaluateMultiLabel(*neuralNetwork, nil);
+     metrics != (neural.MultiLabelMetrics{}) {
+    t.Errorf("metrics %+v of no datapoints unexpected", metrics)
+  }
+}
+
+func TestClipNorm(t *testing.T) {
+  neuralNetwork := CreateSimpleNetwork(t)
+  var before, gradients [2]mat64.Dense
+  for i, layer := range neuralNetwork.Layers {
+    before[i].Clone(layer.Weight)
+  }
+  inputs := mat64.NewDense(1, 2, []float64{0.05, 0.10})
+  neuralNetwork.Forward(inputs)
+  values := mat64.NewDense(1, 2, []float64{0.01, 0.99})
+  neuralNetwork.Backward(values, new(neural.QuadraticErrorFunction))
+  for i, layer := range neuralNetwork.Layers {
+    gradients[i].Clone(layer.Gradient)
+  }
+  neuralNetwork.Update(neural.LearningConfiguration{
+      Rate: proto.Float64(1),
+      Decay: proto.Float64(0),
+      ClipNorm: proto.Float64(0.001),
+  })
+  // The change to any subset of the weights is at most the clip norm.
+  squaredNorm := 0.0
+  for i := 0; i < 2; i++ {
+    change := neuralNetwork.Layers[1].Weight.At(i, 0) - before[1].At(i, 0)
+    squaredNorm += change * change
+  }
+  if squaredNorm > 0.001 * 0.001 + 1e-12 {
+    t.Errorf("clipped update norm %v too large", math.Sqrt(squaredNorm))
+  }
+  // Clipping scales every gradient by the same factor, keeping the update's
+  // direction: each weight moves against its gradient by the same multiple.
+  scale := 0.0
+  for i, layer := range neuralNetwork.Layers {
+    rows, cols := layer.Weight.Dims()
+    // Biases aren't trained.
+    for j := 0; j < rows - 1; j++ {
+      for k := 0; k < cols; k++ {
+        gradient := gradients[i].At(j, k)
+        change := layer.Weight.At(j, k) - before[i].At(j, k)
+        if scale == 0 {
+          scale = -change / gradient
+        }
+        if !equalsApprox(-scale * gradient, change, 1e-12) {
+          t.Errorf("layer %v weight %v,%v changed by %v, not %v times its " +
+                   "gradient %v", i, j, k, change, -scale, gradient)
+        }
+      }
+    }
+  }
+  if scale <= 0 || scale >= 1 {
+    t.Errorf("gradients scaled by %v, expected clipping", scale)
+  }
+}
+
+func TestTrainNonFinite(t *testing.T) {
+  datapoints := []neural.Datapoint{
+      {Features: []float64{0.05, 0.10}, Values: []float64{0.01, 0.99}},
+  }
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(1),
+      Rate: proto.Float64(math.Inf(1)),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(1),
+      ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+  }
+  neuralNetwork := CreateSimpleNetwork(t)
+  err := neural.Train(neuralNetwork, datapoints, learningConfiguration)
+  if err == nil || !strings.Contains(err.Error(), "layer 0") {
+    t.Errorf("expected error naming layer 0, got %v", err)
+  }
+
+  neuralNetwork = CreateSimpleNetwork(t)
+  var before mat64.Dense
+  before.Clone(neuralNetwork.Layers[0].Weight)
+  learningConfiguration.NonFiniteAction =
+      neural.NonFiniteAction_ROLLBACK.Enum()
+  if err := neural.Train(neuralNetwork, datapoints, learningConfiguration);
+     err != nil {
+    t.Fatal(err)
+  }
+  if !mat64.Equal(&before, neuralNetwork.Layers[0].Weight) {
+    t.Errorf("weights not rolled back:\n%v",
+             mat64.Formatted(neuralNetwork.Layers[0].Weight))
+  }
+
+  // Later batches find the non-finite weights of earlier updates from their
+  // cost.
+  datapoints = append(datapoints, datapoints[0], datapoints[0])
+  if err := neural.Train(neuralNetwork, datapoints, learningConfiguration);
+     err != nil {
+    t.Fatal(err)
+  }
+  if !mat64.Equal(&before, neuralNetwork.Layers[0].Weight) {
+    t.Errorf("weights not rolled back after 3 batches:\n%v",
+             mat64.Formatted(neuralNetwork.Layers[0].Weight))
+  }
+  learningConfiguration.NonFiniteAction = neural.NonFiniteAction_ABORT.Enum()
+  err = neural.Train(neuralNetwork, datapoints, learningConfiguration)
+  if err == nil || !strings.Contains(err.Error(), "layer 0") ||
+     !strings.Contains(err.Error(), "batch 1") {
+    t.Errorf("expected error naming layer 0 in batch 1, got %v", err)
+  }
+}
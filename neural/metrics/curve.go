@@ -0,0 +1,124 @@
+package metrics
+
+import (
+  "math";
+  "sort";
+  "neural"
+)
+
+type Point struct {
+  // Score at or above which examples are predicted positive.
+  Threshold float64
+  X float64
+  Y float64
+}
+
+type Curve struct {
+  Points []Point
+  // Area under the curve.
+  AUC float64
+}
+
+type scoredExample struct {
+  score float64
+  positive bool
+  weight float64
+}
+
+// Sort examples by descending score and call visit with the weighted true and
+// false positive totals after each distinct score.
+func sweep(scores []float64, positives []bool, weights []float64,
+           visit func(threshold, truePositives, falsePositives float64)) (
+    totalPositives, totalNegatives float64) {
+  examples := make([]scoredExample, len(scores))
+  for i, score := range scores {
+    examples[i] = scoredExample{score, positives[i], weightOf(weights, i)}
+    if positives[i] {
+      totalPositives += examples[i].weight
+    } else {
+      totalNegatives += examples[i].weight
+    }
+  }
+  sort.Sort(byScore(examples))
+  truePositives, falsePositives := 0.0, 0.0
+  for i, example := range examples {
+    if example.positive {
+      truePositives += example.weight
+    } else {
+      falsePositives += example.weight
+    }
+    if i == len(examples) - 1 || examples[i + 1].score != example.score {
+      visit(example.score, truePositives, falsePositives)
+    }
+  }
+  return
+}
+
+// Receiver operating characteristic of scores for the binary labels in
+// positives: false positive rate (X) against true positive rate (Y).
+func ROC(scores []float64, positives []bool, weights []float64) Curve {
+  var points []Point
+  totalPositives, totalNegatives := sweep(
+      scores, positives, weights,
+      func(threshold, truePositives, falsePositives float64) {
+        points = append(points, Point{threshold, falsePositives,
+                                      truePositives})
+      })
+  // Start at the origin, where nothing is predicted positive.
+  curve := Curve{Points: []Point{Point{Threshold: math.MaxFloat64}}}
+  for _, point := range points {
+    curve.Points = append(curve.Points, Point{
+        point.Threshold, ratio(point.X, totalNegatives),
+        ratio(point.Y, totalPositives)})
+  }
+  // Trapezoidal area.
+  for i := 1; i < len(curve.Points); i++ {
+    previous, current := curve.Points[i - 1], curve.Points[i]
+    curve.AUC += (current.X - previous.X) * (current.Y + previous.Y) / 2
+  }
+  return curve
+}
+
+// Precision-recall curve of scores for the binary labels in positives: recall
+// (X) against precision (Y). AUC is the average precision.
+func PR(scores []float64, positives []bool, weights []float64) Curve {
+  var points []Point
+  totalPositives, _ := sweep(
+      scores, positives, weights,
+      func(threshold, truePositives, falsePositives float64) {
+        points = append(points, Point{threshold, truePositives,
+                                      truePositives + falsePositives})
+      })
+  var curve Curve
+  previousRecall := 0.0
+  for _, point := range points {
+    recall := ratio(point.X, totalPositives)
+    precision := ratio(point.X, point.Y)
+    curve.Points = append(curve.Points,
+                          Point{point.Threshold, recall, precision})
+    curve.AUC += (recall - previousRecall) * precision
+    previousRecall = recall
+  }
+  return curve
+}
+
+func ratio(numerator, denominator float64) float64 {
+  if denominator == 0 {
+    return 0
+  }
+  return numerator / denominator
+}
+
+// Weight of example i, 1 if weights is nil.
+func weightOf(weights []float64, i int) float64 {
+  if weights == nil {
+    return 1
+  }
+  return neural.ExampleWeight(weights[i])
+}
+
+type byScore []scoredExample
+
+func (a byScore) Len() int { return len(a) }
+func (a byScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScore) Less(i, j int) bool { return a[i].score > a[j].score }
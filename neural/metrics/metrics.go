@@ -0,0 +1,182 @@
+// Classification metrics computed from network outputs and datapoint values.
+package metrics
+
+import (
+  "bytes";
+  "encoding/json";
+  "fmt";
+  "math";
+  "strconv";
+  "neural"
+)
+
+// Keep log loss finite when outputs saturate.
+const logLossEpsilon = 1e-15
+
+type ClassMetrics struct {
+  Name string
+  Precision float64
+  Recall float64
+  F1 float64
+  // Weighted number of examples of this class.
+  Support float64
+  // One-vs-rest curves for this class.
+  ROC Curve
+  PR Curve
+}
+
+type Report struct {
+  // ConfusionMatrix[actual][predicted] is the weighted number of examples of
+  // class actual predicted as class predicted.
+  ConfusionMatrix [][]float64
+  Classes []ClassMetrics
+  Accuracy float64
+  // Unweighted means over classes.
+  MacroPrecision float64
+  MacroRecall float64
+  MacroF1 float64
+  // Means over classes weighted by support.
+  WeightedPrecision float64
+  WeightedRecall float64
+  WeightedF1 float64
+  // Means of the per-class ROC-AUC and PR-AUC.
+  MacroROCAUC float64
+  MacroPRAUC float64
+  LogLoss float64
+}
+
+// Compute a classification report from the network output and values of each
+// example. Values hold either a one-hot encoding or a single integer label.
+// Networks with a single output are treated as binary classifiers whose output
+// is the probability of class 1. weights scales each example's contribution;
+// nil weights every example equally and, as for neural.Datapoint, a weight of
+// 0 is treated as 1. classNames may be nil. Returns an error if there are no
+// examples or a label isn't one of the classes.
+func NewReport(outputs [][]float64, values [][]float64, weights []float64,
+               classNames []string) (*Report, error) {
+  if len(outputs) == 0 {
+    return nil, fmt.Errorf("metrics: no examples to report on")
+  }
+  if len(values) != len(outputs) ||
+     (weights != nil && len(weights) != len(outputs)) {
+    return nil, fmt.Errorf("metrics: %v outputs, %v values and %v weights",
+                           len(outputs), len(values), len(weights))
+  }
+  report := new(Report)
+  classes := len(outputs[0])
+  if classes == 1 {
+    classes = 2
+  }
+  report.ConfusionMatrix = make([][]float64, classes)
+  for i := range report.ConfusionMatrix {
+    report.ConfusionMatrix[i] = make([]float64, classes)
+  }
+  probabilities := make([][]float64, len(outputs))
+  labels := make([]int, len(outputs))
+  totalWeight := 0.0
+  for i, output := range outputs {
+    if len(output) != len(outputs[0]) {
+      return nil, fmt.Errorf("metrics: example %v has %v outputs, not %v", i,
+                             len(output), len(outputs[0]))
+    }
+    probabilities[i] = classProbabilities(output)
+    labels[i] = neural.Label(values[i])
+    if labels[i] < 0 || labels[i] >= classes {
+      return nil, fmt.Errorf("metrics: label %v of example %v isn't one of " +
+                             "%v classes", values[i], i, classes)
+    }
+    weight := weightOf(weights, i)
+    predicted := neural.Argmax(probabilities[i])
+    report.ConfusionMatrix[labels[i]][predicted] += weight
+    if predicted == labels[i] {
+      report.Accuracy += weight
+    }
+    report.LogLoss -= weight * math.Log(math.Max(
+        logLossEpsilon, math.Min(1, probabilities[i][labels[i]])))
+    totalWeight += weight
+  }
+  report.Accuracy /= totalWeight
+  report.LogLoss /= totalWeight
+
+  scores := make([]float64, len(outputs))
+  positives := make([]bool, len(outputs))
+  for class := 0; class < classes; class++ {
+    metrics := ClassMetrics{Name: strconv.Itoa(class)}
+    if class < len(classNames) {
+      metrics.Name = classNames[class]
+    }
+    truePositives := report.ConfusionMatrix[class][class]
+    predicted := 0.0
+    for actual := 0; actual < classes; actual++ {
+      predicted += report.ConfusionMatrix[actual][class]
+      metrics.Support += report.ConfusionMatrix[class][actual]
+    }
+    metrics.Precision = ratio(truePositives, predicted)
+    metrics.Recall = ratio(truePositives, metrics.Support)
+    metrics.F1 = ratio(2 * metrics.Precision * metrics.Recall,
+                       metrics.Precision + metrics.Recall)
+    for i := range outputs {
+      scores[i] = probabilities[i][class]
+      positives[i] = labels[i] == class
+    }
+    metrics.ROC = ROC(scores, positives, weights)
+    metrics.PR = PR(scores, positives, weights)
+
+    report.MacroPrecision += metrics.Precision / float64(classes)
+    report.MacroRecall += metrics.Recall / float64(classes)
+    report.MacroF1 += metrics.F1 / float64(classes)
+    report.WeightedPrecision += metrics.Precision * metrics.Support /
+                                totalWeight
+    report.WeightedRecall += metrics.Recall * metrics.Support / totalWeight
+    report.WeightedF1 += metrics.F1 * metrics.Support / totalWeight
+    report.MacroROCAUC += metrics.ROC.AUC / float64(classes)
+    report.MacroPRAUC += metrics.PR.AUC / float64(classes)
+    report.Classes = append(report.Classes, metrics)
+  }
+  return report, nil
+}
+
+// Human-readable rendering of the report, without curve points.
+func (self *Report) Text() string {
+  var buffer bytes.Buffer
+  buffer.WriteString("Confusion matrix (rows actual, columns predicted):\n")
+  for i, row := range self.ConfusionMatrix {
+    buffer.WriteString(fmt.Sprintf("%12s", self.Classes[i].Name))
+    for _, count := range row {
+      buffer.WriteString(fmt.Sprintf(" %8.6g", count))
+    }
+    buffer.WriteString("\n")
+  }
+  buffer.WriteString(fmt.Sprintf(
+      "%12s %9s %9s %9s %9s %9s %9s\n", "class", "precision", "recall", "f1",
+      "support", "roc-auc", "pr-auc"))
+  for _, class := range self.Classes {
+    buffer.WriteString(fmt.Sprintf(
+        "%12s %9.4f %9.4f %9.4f %9.6g %9.4f %9.4f\n", class.Name,
+        class.Precision, class.Recall, class.F1, class.Support, class.ROC.AUC,
+        class.PR.AUC))
+  }
+  buffer.WriteString(fmt.Sprintf(
+      "%12s %9.4f %9.4f %9.4f %9s %9.4f %9.4f\n", "macro", self.MacroPrecision,
+      self.MacroRecall, self.MacroF1, "", self.MacroROCAUC, self.MacroPRAUC))
+  buffer.WriteString(fmt.Sprintf(
+      "%12s %9.4f %9.4f %9.4f\n", "weighted", self.WeightedPrecision,
+      self.WeightedRecall, self.WeightedF1))
+  buffer.WriteString(fmt.Sprintf("Accuracy: %v\nLog loss: %v\n",
+                                 self.Accuracy, self.LogLoss))
+  return buffer.String()
+}
+
+// JSON rendering of the report, including curve points.
+func (self *Report) JSON() ([]byte, error) {
+  return json.MarshalIndent(self, "", "  ")
+}
+
+// Probability of each class given a network output, expanding single outputs
+// into binary probabilities.
+func classProbabilities(output []float64) []float64 {
+  if len(output) == 1 {
+    return []float64{1 - output[0], output[0]}
+  }
+  return output
+}
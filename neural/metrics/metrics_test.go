@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+  "encoding/json";
+  "strings";
+  "testing"
+  "neural/metrics";
+)
+
+func equalsApprox(a, b, tolerance float64) bool {
+  diff := a - b
+  return diff < tolerance && -diff < tolerance
+}
+
+func TestROC(t *testing.T) {
+  scores := []float64{0.9, 0.8, 0.7, 0.6, 0.5, 0.4}
+  positives := []bool{true, true, false, true, false, false}
+  roc := metrics.ROC(scores, positives, nil)
+  // 8 of the 9 positive / negative pairs are ranked correctly.
+  if !equalsApprox(8.0 / 9.0, roc.AUC, 0.0001) {
+    t.Errorf("ROC AUC %v unexpected", roc.AUC)
+  }
+  last := roc.Points[len(roc.Points) - 1]
+  if last.X != 1 || last.Y != 1 {
+    t.Errorf("ROC curve ends at %v", last)
+  }
+  pr := metrics.PR(scores, positives, nil)
+  // Precision at each positive is 1, 1 and 3/4.
+  if !equalsApprox((1 + 1 + 0.75) / 3, pr.AUC, 0.0001) {
+    t.Errorf("PR AUC %v unexpected", pr.AUC)
+  }
+}
+
+func TestNewReport(t *testing.T) {
+  outputs := [][]float64{
+      {0.8, 0.1, 0.1},
+      {0.6, 0.3, 0.1},
+      {0.2, 0.7, 0.1},
+      {0.1, 0.2, 0.7},
+  }
+  // Mixed one-hot and integer labels.
+  values := [][]float64{{1, 0, 0}, {1}, {0, 0, 1}, {2}}
+  report, err := metrics.NewReport(outputs, values, nil,
+                                   []string{"a", "b", "c"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if report.ConfusionMatrix[1][0] != 1 || report.ConfusionMatrix[2][1] != 1 {
+    t.Errorf("confusion matrix %v unexpected", report.ConfusionMatrix)
+  }
+  if !equalsApprox(0.5, report.Accuracy, 0.0001) {
+    t.Errorf("accuracy %v unexpected", report.Accuracy)
+  }
+  a := report.Classes[0]
+  if a.Name != "a" || !equalsApprox(0.5, a.Precision, 0.0001) ||
+     !equalsApprox(1, a.Recall, 0.0001) ||
+     !equalsApprox(2.0 / 3.0, a.F1, 0.0001) {
+    t.Errorf("class a metrics %+v unexpected", a)
+  }
+  if !equalsApprox(4.0 / 9.0, report.MacroF1, 0.0001) {
+    t.Errorf("macro F1 %v unexpected", report.MacroF1)
+  }
+
+  if text := report.Text(); !strings.Contains(text, "Accuracy: 0.5") {
+    t.Errorf("text report missing accuracy:\n%v", text)
+  }
+  byteReport, err := report.JSON()
+  if err != nil {
+    t.Fatal(err)
+  }
+  var decoded metrics.Report
+  if err := json.Unmarshal(byteReport, &decoded); err != nil {
+    t.Fatal(err)
+  }
+  if decoded.Accuracy != report.Accuracy {
+    t.Errorf("decoded accuracy %v unexpected", decoded.Accuracy)
+  }
+}
+
+func TestNewReportBinary(t *testing.T) {
+  outputs := [][]float64{{0.9}, {0.2}, {0.6}}
+  values := [][]float64{{1}, {0}, {0}}
+  report, err := metrics.NewReport(outputs, values, nil, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(report.Classes) != 2 || report.ConfusionMatrix[0][1] != 1 {
+    t.Errorf("binary confusion matrix %v unexpected", report.ConfusionMatrix)
+  }
+  if !equalsApprox(1, report.Classes[1].ROC.AUC, 0.0001) {
+    t.Errorf("binary ROC AUC %v unexpected", report.Classes[1].ROC.AUC)
+  }
+}
+
+func TestNewReportErrors(t *testing.T) {
+  if _, err := metrics.NewReport(nil, nil, nil, nil); err == nil {
+    t.Errorf("reported on no examples")
+  }
+  outputs := [][]float64{{0.9}, {0.2}}
+  for _, values := range [][][]float64{{{1}, {2}}, {{-1}, {0}}, {{1}}} {
+    if _, err := metrics.NewReport(outputs, values, nil, nil); err == nil {
+      t.Errorf("reported on binary values %v", values)
+    }
+  }
+  if _, err := metrics.NewReport(outputs, [][]float64{{1}, {0}},
+                                 []float64{1}, nil); err == nil {
+    t.Errorf("reported with 1 weight for 2 examples")
+  }
+}
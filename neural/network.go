@@ -6,7 +6,8 @@ import (
   "fmt";
   "github.com/golang/protobuf/proto";
   "github.com/gonum/matrix/mat64";
-  "math/rand"
+  "math";
+  "strconv"
 )
 
 func NewNetwork(
@@ -18,71 +19,340 @@ func NewNetwork(
 
 type Network struct {
   Layers []*Layer
+  // Number of classes if this network is a classifier, otherwise 0.
+  Classes int
+  // Optional name of each class.
+  ClassNames []string
+  // Whether examples may belong to several classes at once.
+  MultiLabel bool
+  // Per-class decision thresholds for multi-label networks.
+  Thresholds []float64
+  // Precision of every layer.
+  Precision Precision
+  // Shape of each example's inputs.
+  InputShape []int
+
+  evaluateInput *mat64.Dense  // Reused by Evaluate, 1 x inputs.
 }
 
 func (self *Network) RandomizeSynapses() {
   for _, layer := range self.Layers {
-    rows, cols := layer.Weight.Dims()
-    for i := 0; i < rows; i++ {
-      for j := 0; j < cols; j++ {
-        layer.Weight.Set(i, j, rand.NormFloat64())
-      }
+    layer.randomizeWeights()
+  }
+}
+
+// Replace the last strip layers of the network with the layers of head, for
+// transfer learning, which also replace its classes, class names, multi_label
+// and thresholds. New layers without weights are initialized randomly. Returns
+// an error, leaving the network unchanged, if the layers don't fit.
+func (self *Network) ReplaceLayers(strip int,
+                                   head NetworkConfiguration) error {
+  if strip < 0 || strip > len(self.Layers) {
+    return fmt.Errorf("neural: can't strip %v of %v layers", strip,
+                      len(self.Layers))
+  }
+  networkConfiguration := self.configuration()
+  networkConfiguration.Layer = append(
+      networkConfiguration.Layer[:len(self.Layers) - strip], head.Layer...)
+  networkConfiguration.Classes = head.Classes
+  networkConfiguration.ClassName = head.ClassName
+  networkConfiguration.MultiLabel = head.MultiLabel
+  networkConfiguration.Threshold = head.Threshold
+  if err := checkShapes(networkConfiguration); err != nil {
+    return err
+  }
+  self.Classes = int(head.GetClasses())
+  self.ClassNames = head.ClassName
+  self.MultiLabel = head.GetMultiLabel()
+  self.Thresholds = head.Threshold
+  self.Layers = self.Layers[:len(self.Layers) - strip]
+  inputShape := self.InputShape
+  if len(self.Layers) > 0 {
+    inputShape = self.Layers[len(self.Layers) - 1].OutputShape
+  }
+  for _, layerConfiguration := range head.Layer {
+    layer := newLayerFromConfiguration(
+        self.Precision, inputShape, layerConfiguration)
+    if len(layerConfiguration.Weight) == 0 &&
+       len(layerConfiguration.Weight32) == 0 {
+      layer.randomizeWeights()
     }
+    if layerConfiguration.TiedTo != nil {
+      layer.Tied = self.Layers[layerConfiguration.GetTiedTo()]
+    }
+    self.Layers = append(self.Layers, layer)
+    inputShape = layer.OutputShape
   }
+  return nil
+}
+
+// Stop training the first layers layers of the network.
+func (self *Network) Freeze(layers int) error {
+  if layers < 0 || layers > len(self.Layers) {
+    return fmt.Errorf("neural: can't freeze %v of %v layers", layers,
+                      len(self.Layers))
+  }
+  for i := 0; i < layers; i++ {
+    self.Layers[i].Trainable = false
+  }
+  return nil
 }
 
 func (self *Network) Forward(inputs *mat64.Dense) {
-  previous := &Layer{}
-  previous.Output = inputs
-  for _, layer := range self.Layers {
-    layer.Forward(previous)
-    previous = layer
+  self.Layers[0].forward(inputs)
+  for i := 1; i < len(self.Layers); i++ {
+    self.Layers[i].Forward(self.Layers[i - 1])
   }
 }
 
+// Like Forward, but for inputs of shape examples x InputShape.
+func (self *Network) ForwardTensor(inputs *Tensor) {
+  if product(inputs.Shape[1:]) != product(self.InputShape) {
+    panic(fmt.Sprintf("neural: inputs of shape %v for network of shape %v",
+                      inputs.Shape[1:], self.InputShape))
+  }
+  self.Forward(inputs.Matrix())
+}
+
+// Output of the last layer as an examples x OutputShape tensor.
+func (self *Network) OutputTensor() *Tensor {
+  return self.Layers[len(self.Layers) - 1].OutputTensor()
+}
+
 func (self *Network) Backward(values *mat64.Dense,
                               error_function ErrorFunction) {
-  next := self.Layers[len(self.Layers) - 1]
-  next.BackwardOutput(values, error_function)
-  for i := len(self.Layers) - 2; i >= 0; i-- {
+  self.BackwardWeighted(values, nil, error_function)
+}
+
+// Like Backward, but scales each example's contribution, including to any KL
+// divergence, by weights.
+func (self *Network) BackwardWeighted(values *mat64.Dense, weights []float64,
+                                      error_function ErrorFunction) {
+  last := self.Layers[len(self.Layers) - 1]
+  last.exampleWeights = weights
+  last.BackwardOutput(values, weights, error_function)
+  self.backwardLayers(len(self.Layers) - 1, last, weights)
+}
+
+// Backpropagate the deltas of next through the first layers layers, where next
+// follows them, such as the first layer of another network, for examples
+// weighted by weights.
+func (self *Network) backwardLayers(layers int, next *Layer,
+                                    weights []float64) {
+  for i := layers - 1; i >= 0; i-- {
+    self.Layers[i].exampleWeights = weights
     self.Layers[i].Backward(next)
     next = self.Layers[i]
   }
+  // Tied layers' gradients belong to the layers whose weights they use.
+  for _, layer := range self.Layers {
+    if layer.Tied != nil && layer.Tied.Trainable {
+      layer.Tied.weightGradient.Add(layer.Tied.weightGradient,
+                                    layer.weightGradient.T())
+      layer.Tied.sparseGradient = false
+    }
+    if layer.Tied != nil {
+      layer.weightGradient.Scale(0, layer.weightGradient)
+    }
+  }
+}
+
+// Sum each layer's Gradient from the current batch into the gradients applied
+// by the next Update, without changing any weights.
+func (self *Network) Accumulate() {
+  accumulateLayers(self.Layers)
+}
+
+func accumulateLayers(layers []*Layer) {
+  for _, layer := range layers {
+    if layer.Trainable {
+      layer.Accumulate()
+    }
+  }
 }
 
 func (self *Network) Update(learningConfiguration LearningConfiguration) {
+  updateLayers(self.Layers, learningConfiguration)
+}
+
+// Update layers with only the gradients accumulated since their last update,
+// such as those of a last, partial accumulation, whose current gradients were
+// accumulated already. Returns whether there were any.
+func updateAccumulated(layers []*Layer,
+                       learningConfiguration LearningConfiguration) bool {
+  accumulated := false
+  for _, layer := range layers {
+    accumulated = accumulated || layer.accumulated > 0
+  }
+  if !accumulated {
+    return false
+  }
+  for _, layer := range layers {
+    layer.zeroGradient()
+  }
+  updateLayers(layers, learningConfiguration)
+  return true
+}
+
+// Discard any gradients accumulated by layers since their last update.
+func discardAccumulated(layers []*Layer) {
+  for _, layer := range layers {
+    layer.accumulated = 0
+  }
+}
+
+// Clip the gradients of every trainable layer in layers by
+// learningConfiguration, then apply them.
+func updateLayers(layers []*Layer,
+                  learningConfiguration LearningConfiguration) {
+  squaredNorm := 0.0
+  for _, layer := range layers {
+    if layer.Trainable {
+      squaredNorm += layer.clipGradient(learningConfiguration.GetClipValue())
+    }
+  }
+  scale := 1.0
+  if clipNorm := learningConfiguration.GetClipNorm();
+     clipNorm > 0 && squaredNorm > clipNorm * clipNorm {
+    scale = clipNorm / math.Sqrt(squaredNorm)
+  }
+  for _, layer := range layers {
+    if layer.Trainable {
+      layer.applyGradient(learningConfiguration, scale)
+    }
+  }
+}
+
+// Total regularization penalty over every layer, with L2 weight decay decay.
+func (self *Network) Penalty(decay float64) float64 {
+  penalty := 0.0
   for _, layer := range self.Layers {
-    layer.Update(learningConfiguration)
+    penalty += layer.Penalty(decay)
+  }
+  return penalty
+}
+
+// Copy the weights of each of layers into weights, allocating it if nil.
+func copyWeights(layers []*Layer, weights []*mat64.Dense) []*mat64.Dense {
+  if weights == nil {
+    weights = make([]*mat64.Dense, len(layers))
+    for i, layer := range layers {
+      rows, cols := layer.Weights().Dims()
+      weights[i] = mat64.NewDense(rows, cols, nil)
+    }
+  }
+  for i, layer := range layers {
+    weights[i].Copy(layer.Weights())
+  }
+  return weights
+}
+
+// Replace the weights of each of layers with weights from copyWeights.
+func restoreWeights(layers []*Layer, weights []*mat64.Dense) {
+  for i, layer := range layers {
+    weight := layer.Weights()
+    rows, cols := weight.Dims()
+    for j := 0; j < rows; j++ {
+      for k := 0; k < cols; k++ {
+        weight.Set(j, k, weights[i].At(j, k))
+      }
+    }
   }
 }
 
 func (self *Network) Evaluate(features []float64) []float64 {
-  inputs := mat64.NewDense(1, len(features), features)
-  self.Forward(inputs)
+  if self.evaluateInput == nil {
+    self.evaluateInput = mat64.NewDense(1, len(features), nil)
+  }
+  self.evaluateInput.SetRow(0, features)
+  self.Forward(self.evaluateInput)
   return self.Layers[len(self.Layers)-1].Output.RawRowView(0)
 }
 
+// Return the most likely class for features along with the network's output
+// for each class. Networks with a single output are binary classifiers whose
+// output is the probability of class 1.
+func (self *Network) Predict(features []float64) (int, []float64) {
+  probabilities := self.Evaluate(features)
+  return predictedClass(probabilities), probabilities
+}
+
+// Most likely class given a network's output for each class.
+func predictedClass(probabilities []float64) int {
+  if len(probabilities) == 1 {
+    if probabilities[0] >= 0.5 {
+      return 1
+    }
+    return 0
+  }
+  return Argmax(probabilities)
+}
+
+// Return every class whose output meets its threshold for features along with
+// the network's output for each class.
+func (self *Network) PredictLabels(features []float64) ([]int, []float64) {
+  probabilities := self.Evaluate(features)
+  labels := []int{}
+  for i, probability := range probabilities {
+    if probability >= self.Threshold(i) {
+      labels = append(labels, i)
+    }
+  }
+  return labels, probabilities
+}
+
+// Return the decision threshold of class for multi-label networks.
+func (self *Network) Threshold(class int) float64 {
+  if class < len(self.Thresholds) {
+    return self.Thresholds[class]
+  }
+  return 0.5
+}
+
+// Return the name of class, or its index if it has no name.
+func (self *Network) ClassName(class int) string {
+  if class < len(self.ClassNames) {
+    return self.ClassNames[class]
+  }
+  return strconv.Itoa(class)
+}
+
 func (self *Network) Serialize() []byte {
+  networkConfiguration := self.configuration()
+  // TODO(ariw): Return byte representation rather than text representation.
+  byteNetwork, _ := json.Marshal(networkConfiguration)
+  return byteNetwork
+}
+
+// Configuration describing the network, including its weights.
+func (self *Network) configuration() NetworkConfiguration {
   var networkConfiguration NetworkConfiguration
-  inputs, _ := self.Layers[0].Weight.Dims()
-  networkConfiguration.Inputs = proto.Int32(int32(inputs - 1))
+  networkConfiguration.Inputs = proto.Int32(int32(self.Layers[0].Inputs()))
+  if self.Classes > 0 {
+    networkConfiguration.Classes = proto.Int32(int32(self.Classes))
+    networkConfiguration.ClassName = self.ClassNames
+  }
+  if self.MultiLabel {
+    networkConfiguration.MultiLabel = proto.Bool(true)
+    networkConfiguration.Threshold = self.Thresholds
+  }
+  if self.Precision != Precision_FLOAT64 {
+    networkConfiguration.Precision = self.Precision.Enum()
+  }
+  if len(self.InputShape) > 1 {
+    networkConfiguration.InputShape = int32s(self.InputShape)
+  }
   for _, layer := range self.Layers {
-    layerConfiguration := new(LayerConfiguration)
-    layerConfiguration.Name = layer.Name.Enum()
-    rows, cols := layer.Weight.Dims()
-    layerConfiguration.Outputs = proto.Int32(int32(cols))
-    for i := 0; i < rows; i++ {
-      for j := 0; j < cols; j++ {
-        layerConfiguration.Weight = append(
-            layerConfiguration.Weight, layer.Weight.At(i, j))
+    layerConfiguration := newLayerConfiguration(layer)
+    for i, tied := range self.Layers {
+      if layer.Tied == tied {
+        layerConfiguration.TiedTo = proto.Int32(int32(i))
       }
     }
     networkConfiguration.Layer = append(
         networkConfiguration.Layer, layerConfiguration)
   }
-  // TODO(ariw): Return byte representation rather than text representation.
-  byteNetwork, _ := json.Marshal(networkConfiguration)
-  return byteNetwork
+  return networkConfiguration
 }
 
 func (self *Network) Deserialize(byteNetwork []byte) error {
@@ -90,10 +360,38 @@ func (self *Network) Deserialize(byteNetwork []byte) error {
   if err := json.Unmarshal(byteNetwork, &networkConfiguration); err != nil {
     return err
   }
+  if len(networkConfiguration.Node) > 0 {
+    return fmt.Errorf("graph network configurations need a Graph")
+  }
+  if err := checkShapes(networkConfiguration); err != nil {
+    return err
+  }
   self.init(networkConfiguration)
   return nil
 }
 
+// Return an error if this network is a classifier and the integer label of any
+// of datapoints isn't one of its classes.
+func (self *Network) CheckLabels(datapoints []Datapoint) error {
+  if self.Classes == 0 {
+    return nil
+  }
+  return checkLabels(datapoints, self.Classes)
+}
+
+// One-hot encode integer labels in datapoints if this network has an output per
+// class, or return an error from CheckLabels.
+func (self *Network) encodeLabels(datapoints []Datapoint) ([]Datapoint,
+                                                           error) {
+  if err := self.CheckLabels(datapoints); err != nil {
+    return nil, err
+  }
+  if self.Layers[len(self.Layers) - 1].Outputs() != self.Classes {
+    return datapoints, nil
+  }
+  return OneHotEncode(datapoints, self.Classes)
+}
+
 func (self *Network) DebugString() string {
   var buffer bytes.Buffer
   for i, layer := range self.Layers {
@@ -104,12 +402,245 @@ func (self *Network) DebugString() string {
 
 func (self *Network) init(networkConfiguration NetworkConfiguration) {
   self.Layers = []*Layer{}
+  self.evaluateInput = nil
+  self.Classes = int(networkConfiguration.GetClasses())
+  self.ClassNames = networkConfiguration.ClassName
+  self.MultiLabel = networkConfiguration.GetMultiLabel()
+  self.Thresholds = networkConfiguration.Threshold
+  self.Precision = networkConfiguration.GetPrecision()
   inputs := int(*networkConfiguration.Inputs)
+  self.InputShape = []int{inputs}
+  if len(networkConfiguration.InputShape) > 0 {
+    self.InputShape = ints(networkConfiguration.InputShape)
+  }
+  inputShape := self.InputShape
   for _, layerConfiguration := range networkConfiguration.Layer {
-    outputs := int(*layerConfiguration.Outputs)
-    layer := NewLayer(*layerConfiguration.Name, inputs, outputs,
-                      layerConfiguration.Weight)
+    layer := newLayerFromConfiguration(
+        self.Precision, inputShape, layerConfiguration)
+    if layerConfiguration.TiedTo != nil {
+      layer.Tied = self.Layers[layerConfiguration.GetTiedTo()]
+    }
     self.Layers = append(self.Layers, layer)
-    inputs = outputs
+    inputShape = layer.OutputShape
+  }
+}
+
+// Configuration describing layer, including its weights.
+func newLayerConfiguration(layer *Layer) *LayerConfiguration {
+  layerConfiguration := new(LayerConfiguration)
+  if layer.Type == LayerType_GAUSSIAN_SAMPLE {
+    layerConfiguration.Type = layer.Type.Enum()
+    layerConfiguration.KlWeight = proto.Float64(layer.KLWeight)
+  } else if layer.Type != LayerType_DENSE {
+    layerConfiguration.Type = layer.Type.Enum()
+    layerConfiguration.Attention = &AttentionConfiguration{
+      Heads: proto.Int32(int32(layer.Heads)),
+      Causal: proto.Bool(layer.Causal),
+      FeedForward: proto.Int32(int32(layer.FeedForward)),
+    }
+    if layer.MaskPadding {
+      layerConfiguration.Attention.MaskPadding = proto.Bool(true)
+    }
+  }
+  layerConfiguration.Name = layer.Name.Enum()
+  rows, cols := layer.Weights().Dims()
+  layerConfiguration.Outputs = proto.Int32(int32(layer.Outputs()))
+  if len(layer.OutputShape) > 1 {
+    layerConfiguration.OutputShape = int32s(layer.OutputShape)
+  }
+  if layer.Precision == Precision_FLOAT32 {
+    layerConfiguration.Weight32 = layer.Weight32.data
+  } else {
+    for i := 0; i < rows; i++ {
+      for j := 0; j < cols; j++ {
+        layerConfiguration.Weight = append(
+            layerConfiguration.Weight, layer.Weight.At(i, j))
+      }
+    }
+  }
+  if layer.L1 != 0 {
+    layerConfiguration.L1 = proto.Float64(layer.L1)
+  }
+  if layer.L2 != 0 {
+    layerConfiguration.L2 = proto.Float64(layer.L2)
+  }
+  if layer.MaxNorm != 0 {
+    layerConfiguration.MaxNorm = proto.Float64(layer.MaxNorm)
+  }
+  if layer.NonNegative {
+    layerConfiguration.NonNegative = proto.Bool(true)
+  }
+  if layer.UnitNorm {
+    layerConfiguration.UnitNorm = proto.Bool(true)
+  }
+  if !layer.Trainable {
+    layerConfiguration.Trainable = proto.Bool(false)
+  }
+  if layer.Type == LayerType_DENSE && layer.Function != nil {
+    layerConfiguration.Autodiff = proto.Bool(true)
+  }
+  if layer.RateMultiplier != 1 {
+    layerConfiguration.RateMultiplier = proto.Float64(layer.RateMultiplier)
+  }
+  return layerConfiguration
+}
+
+// Return an error if any shape in networkConfiguration doesn't match the number
+// of inputs or outputs it describes.
+func checkShapes(networkConfiguration NetworkConfiguration) error {
+  if shape := networkConfiguration.InputShape; len(shape) > 0 &&
+     product(ints(shape)) != int(networkConfiguration.GetInputs()) {
+    return fmt.Errorf("input shape %v doesn't match %v inputs", shape,
+                      networkConfiguration.GetInputs())
+  }
+  inputShape := []int{int(networkConfiguration.GetInputs())}
+  if len(networkConfiguration.InputShape) > 0 {
+    inputShape = ints(networkConfiguration.InputShape)
+  }
+  // Inputs and outputs of each layer.
+  var layerInputs, layerOutputs []int
+  for i, layerConfiguration := range networkConfiguration.Layer {
+    layerInputs = append(layerInputs, product(inputShape))
+    layerOutputs = append(layerOutputs, product(inputShape))
+    if tied := int(layerConfiguration.GetTiedTo());
+       layerConfiguration.TiedTo != nil {
+      if tied < 0 || tied >= i ||
+         networkConfiguration.Layer[tied].GetType() != LayerType_DENSE ||
+         layerConfiguration.GetType() != LayerType_DENSE ||
+         networkConfiguration.GetPrecision() != Precision_FLOAT64 {
+        return fmt.Errorf("layer %v can't be tied to layer %v", i, tied)
+      }
+      if layerInputs[i] != layerOutputs[tied] ||
+         int(layerConfiguration.GetOutputs()) != layerInputs[tied] {
+        return fmt.Errorf("layer %v with %v inputs and %v outputs can't be " +
+                          "tied to layer %v with %v inputs and %v outputs", i,
+                          layerInputs[i], layerConfiguration.GetOutputs(),
+                          tied, layerInputs[tied], layerOutputs[tied])
+      }
+    }
+    if layerConfiguration.GetType() != LayerType_DENSE &&
+       networkConfiguration.GetPrecision() != Precision_FLOAT64 {
+      return fmt.Errorf("layer %v of type %v isn't FLOAT64", i,
+                        layerConfiguration.GetType())
+    }
+    if layerConfiguration.GetType() == LayerType_GAUSSIAN_SAMPLE {
+      if len(inputShape) != 1 || inputShape[0] % 2 != 0 {
+        return fmt.Errorf("layer %v of type %v has inputs of shape %v " +
+                          "rather than means and log variances", i,
+                          layerConfiguration.GetType(), inputShape)
+      }
+      inputShape = []int{inputShape[0] / 2}
+      layerOutputs[i] = inputShape[0]
+      continue
+    }
+    if layerConfiguration.GetType() != LayerType_DENSE {
+      if len(inputShape) != 2 {
+        return fmt.Errorf("layer %v of type %v has inputs of shape %v " +
+                          "rather than tokens x features", i,
+                          layerConfiguration.GetType(), inputShape)
+      }
+      heads := int(layerConfiguration.GetAttention().GetHeads())
+      if heads <= 0 || inputShape[1] % heads != 0 {
+        return fmt.Errorf("layer %v has %v heads for %v features", i, heads,
+                          inputShape[1])
+      }
+      continue
+    }
+    if shape := layerConfiguration.OutputShape; len(shape) > 0 &&
+       product(ints(shape)) != int(layerConfiguration.GetOutputs()) {
+      return fmt.Errorf("layer %v output shape %v doesn't match %v outputs", i,
+                        shape, layerConfiguration.GetOutputs())
+    }
+    inputShape = []int{int(layerConfiguration.GetOutputs())}
+    if len(layerConfiguration.OutputShape) > 0 {
+      inputShape = ints(layerConfiguration.OutputShape)
+    }
+    layerOutputs[i] = product(inputShape)
+  }
+  // Classifiers have an output per class, or one for binary classification.
+  if classes := int(networkConfiguration.GetClasses());
+     classes > 0 && len(layerOutputs) > 0 {
+    outputs := layerOutputs[len(layerOutputs) - 1]
+    if outputs != classes && (outputs != 1 || classes != 2 ||
+                              networkConfiguration.GetMultiLabel()) {
+      return fmt.Errorf("%v classes for %v outputs", classes, outputs)
+    }
+  }
+  return nil
+}
+
+func ints(values []int32) []int {
+  converted := make([]int, len(values))
+  for i, value := range values {
+    converted[i] = int(value)
+  }
+  return converted
+}
+
+func int32s(values []int) []int32 {
+  converted := make([]int32, len(values))
+  for i, value := range values {
+    converted[i] = int32(value)
+  }
+  return converted
+}
+
+// Layer described by layerConfiguration for inputs of inputShape.
+func newLayerFromConfiguration(precision Precision, inputShape []int,
+                               layerConfiguration *LayerConfiguration) *Layer {
+  inputs := product(inputShape)
+  outputs := int(layerConfiguration.GetOutputs())
+  var layer *Layer
+  if layerConfiguration.GetType() == LayerType_GAUSSIAN_SAMPLE {
+    layer = NewSampleLayer(inputs / 2, layerConfiguration.GetKlWeight())
+  } else if layerConfiguration.GetType() != LayerType_DENSE {
+    if precision != Precision_FLOAT64 {
+      panic(fmt.Sprintf("neural: %v layers are FLOAT64 only",
+                        layerConfiguration.GetType()))
+    }
+    weight := layerConfiguration.Weight
+    if weight == nil && layerConfiguration.Weight32 != nil {
+      weight = make([]float64, len(layerConfiguration.Weight32))
+      for i, w := range layerConfiguration.Weight32 {
+        weight[i] = float64(w)
+      }
+    }
+    layer = NewSequenceLayer(layerConfiguration.GetType(), inputShape,
+                             layerConfiguration.Attention, weight)
+  } else if precision == Precision_FLOAT32 {
+    weight := layerConfiguration.Weight32
+    if weight == nil && layerConfiguration.Weight != nil {
+      weight = make([]float32, len(layerConfiguration.Weight))
+      for i, w := range layerConfiguration.Weight {
+        weight[i] = float32(w)
+      }
+    }
+    layer = NewLayer32(*layerConfiguration.Name, inputs, outputs, weight)
+  } else {
+    weight := layerConfiguration.Weight
+    if weight == nil && layerConfiguration.Weight32 != nil {
+      weight = make([]float64, len(layerConfiguration.Weight32))
+      for i, w := range layerConfiguration.Weight32 {
+        weight[i] = float64(w)
+      }
+    }
+    layer = NewLayer(*layerConfiguration.Name, inputs, outputs, weight)
+  }
+  if layer.Type == LayerType_DENSE {
+    layer.InputShape = inputShape
+    if len(layerConfiguration.OutputShape) > 0 {
+      layer.OutputShape = ints(layerConfiguration.OutputShape)
+    }
+    if layerConfiguration.GetAutodiff() {
+      layer.Function = DenseFunction(layer.Name)
+    }
   }
+  layer.L1 = layerConfiguration.GetL1()
+  layer.L2 = layerConfiguration.GetL2()
+  layer.MaxNorm = layerConfiguration.GetMaxNorm()
+  layer.NonNegative = layerConfiguration.GetNonNegative()
+  layer.UnitNorm = layerConfiguration.GetUnitNorm()
+  layer.Trainable = layerConfiguration.GetTrainable()
+  layer.RateMultiplier = layerConfiguration.GetRateMultiplier()
+  return layer
 }
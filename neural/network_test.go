@@ -4,7 +4,7 @@ import (
   "github.com/gonum/matrix/mat64";
   "github.com/golang/protobuf/proto";
   "testing"
-  "../neural";
+  "neural";
 )
 
 // Example generated from
@@ -42,7 +42,7 @@ func TestBackward(t *testing.T) {
   inputs := mat64.NewDense(1, 2, []float64{0.05, 0.10})
   neuralNetwork.Forward(inputs)
   values := mat64.NewDense(1, 2, []float64{0.01, 0.99})
-  neuralNetwork.Backward(values)
+  neuralNetwork.Backward(values, new(neural.QuadraticErrorFunction))
   expected_gradient_1 := mat64.NewDense(2, 1, []float64{0.13849856, -0.03809824})
   if !mat64.EqualApprox(
           neuralNetwork.Layers[1].Deltas, expected_gradient_1, 0.0001) {
@@ -62,7 +62,7 @@ func TestUpdate(t *testing.T) {
   inputs := mat64.NewDense(1, 2, []float64{0.05, 0.10})
   neuralNetwork.Forward(inputs)
   values := mat64.NewDense(1, 2, []float64{0.01, 0.99})
-  neuralNetwork.Backward(values)
+  neuralNetwork.Backward(values, new(neural.QuadraticErrorFunction))
   learningConfiguration := neural.LearningConfiguration{
       Epochs: proto.Int32(1),
       Rate: proto.Float64(0.5),
@@ -87,3 +87,264 @@ func TestUpdate(t *testing.T) {
              mat64.Formatted(neuralNetwork.Layers[1].Weight))
   }
 }
+
+func TestPredict(t *testing.T) {
+  neuralNetwork := new(neural.Network)
+  if err := neuralNetwork.Deserialize([]byte(
+      "{\"inputs\":2,\"layer\":[{\"name\":4,\"outputs\":2,\"weight\":[1,0," +
+      "0,1,0,0]}],\"classes\":2,\"class_name\":[\"cat\",\"dog\"]}"));
+     err != nil {
+    t.Fatal(err)
+  }
+  class, probabilities := neuralNetwork.Predict([]float64{0, 1})
+  if class != 1 || neuralNetwork.ClassName(class) != "dog" {
+    t.Errorf("class %v unexpected", class)
+  }
+  if !equalsApprox(0.7310586, probabilities[1], 0.0001) {
+    t.Errorf("probabilities %v unexpected", probabilities)
+  }
+
+  // Classes survive serialization.
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if deserialized.Classes != 2 || deserialized.ClassName(0) != "cat" {
+    t.Errorf("classes %v %v unexpected", deserialized.Classes,
+             deserialized.ClassNames)
+  }
+
+  if err := deserialized.Deserialize([]byte(
+      "{\"inputs\":2,\"layer\":[{\"name\":4,\"outputs\":2}]," +
+      "\"classes\":3}")); err == nil {
+    t.Errorf("deserialized 3 classes for 2 outputs")
+  }
+  if err := neuralNetwork.CheckLabels(
+      []neural.Datapoint{{Values: []float64{1}}, {Values: []float64{2}}});
+     err == nil {
+    t.Errorf("label 2 of 2 classes passed")
+  }
+  unlabeled := []neural.Datapoint{
+      {Features: []float64{0, 1}, Values: []float64{-1}}}
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(1),
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+      ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+  if err := neural.Train(
+      neuralNetwork, unlabeled, learningConfiguration); err == nil {
+    t.Errorf("trained on label -1")
+  }
+  if _, err := neural.Evaluate(*neuralNetwork, unlabeled); err == nil {
+    t.Errorf("evaluated label -1")
+  }
+  if _, err := neural.Loss(
+      *neuralNetwork, unlabeled, learningConfiguration); err == nil {
+    t.Errorf("computed the loss of label -1")
+  }
+}
+
+func TestFreezeAndReplaceLayers(t *testing.T) {
+  neuralNetwork := CreateSimpleNetwork(t)
+  head := neural.NetworkConfiguration{
+      Layer: []*neural.LayerConfiguration{
+          &neural.LayerConfiguration{
+              Name: neural.ActivationName_SOFTMAX.Enum(),
+              Outputs: proto.Int32(3),
+              RateMultiplier: proto.Float64(2),
+          },
+      },
+      Classes: proto.Int32(3),
+      ClassName: []string{"a", "b", "c"},
+  }
+  if err := neuralNetwork.ReplaceLayers(1, head); err != nil {
+    t.Fatal(err)
+  }
+  if err := neuralNetwork.Freeze(1); err != nil {
+    t.Fatal(err)
+  }
+  if len(neuralNetwork.Layers) != 2 {
+    t.Fatalf("%v layers unexpected", len(neuralNetwork.Layers))
+  }
+  if neuralNetwork.Classes != 3 || neuralNetwork.ClassName(2) != "c" {
+    t.Errorf("head classes %v %v not copied", neuralNetwork.Classes,
+             neuralNetwork.ClassNames)
+  }
+  if rows, cols := neuralNetwork.Layers[1].Weight.Dims(); rows != 3 ||
+     cols != 3 {
+    t.Errorf("new layer dimensions %vx%v unexpected", rows, cols)
+  }
+  if neuralNetwork.Layers[1].Weight.At(0, 0) == 0 {
+    t.Errorf("new layer not randomized")
+  }
+
+  var before0, before1 mat64.Dense
+  before0.Clone(neuralNetwork.Layers[0].Weight)
+  before1.Clone(neuralNetwork.Layers[1].Weight)
+  inputs := mat64.NewDense(1, 2, []float64{0.05, 0.10})
+  neuralNetwork.Forward(inputs)
+  values := mat64.NewDense(1, 3, []float64{0, 1, 0})
+  neuralNetwork.Backward(values, new(neural.CrossEntropyErrorFunction))
+  neuralNetwork.Update(neural.LearningConfiguration{
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+  })
+  if !mat64.Equal(&before0, neuralNetwork.Layers[0].Weight) {
+    t.Errorf("frozen layer changed")
+  }
+  if mat64.Equal(&before1, neuralNetwork.Layers[1].Weight) {
+    t.Errorf("trainable layer unchanged")
+  }
+
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if deserialized.Layers[0].Trainable ||
+     deserialized.Layers[1].RateMultiplier != 2 {
+    t.Errorf("trainable / rate multiplier not serialized")
+  }
+
+  for _, strip := range []int{-1, 3} {
+    if err := deserialized.ReplaceLayers(strip, head); err == nil {
+      t.Errorf("stripped %v of 2 layers", strip)
+    }
+  }
+  head.Classes = proto.Int32(4)
+  if err := deserialized.ReplaceLayers(1, head); err == nil {
+    t.Errorf("replaced a head of 3 outputs for 4 classes")
+  }
+  head.Classes = nil
+  head.Layer[0].TiedTo = proto.Int32(1)
+  if err := deserialized.ReplaceLayers(1, head); err == nil {
+    t.Errorf("tied a head layer to itself")
+  }
+  if len(deserialized.Layers) != 2 || deserialized.Classes != 3 {
+    t.Errorf("failed replacements changed the network")
+  }
+  for _, layers := range []int{-1, 3} {
+    if err := deserialized.Freeze(layers); err == nil {
+      t.Errorf("froze %v of 2 layers", layers)
+    }
+  }
+}
+
+func TestAccumulate(t *testing.T) {
+  learningConfiguration := neural.LearningConfiguration{
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+  }
+  errorFunction := new(neural.QuadraticErrorFunction)
+  features := []float64{0.05, 0.10, 0.3, -0.2}
+  values := []float64{0.01, 0.99, 0.5, 0.5}
+
+  // One update from a batch of two examples.
+  batchNetwork := CreateSimpleNetwork(t)
+  batchNetwork.Forward(mat64.NewDense(2, 2, features))
+  batchNetwork.Backward(mat64.NewDense(2, 2, values), errorFunction)
+  batchNetwork.Update(learningConfiguration)
+
+  // One update from two accumulated batches of one example.
+  accumulatedNetwork := CreateSimpleNetwork(t)
+  accumulatedNetwork.Forward(mat64.NewDense(1, 2, features[:2]))
+  accumulatedNetwork.Backward(mat64.NewDense(1, 2, values[:2]), errorFunction)
+  accumulatedNetwork.Accumulate()
+  accumulatedNetwork.Forward(mat64.NewDense(1, 2, features[2:]))
+  accumulatedNetwork.Backward(mat64.NewDense(1, 2, values[2:]), errorFunction)
+  accumulatedNetwork.Update(learningConfiguration)
+
+  for i := range batchNetwork.Layers {
+    if !mat64.EqualApprox(batchNetwork.Layers[i].Weight,
+                          accumulatedNetwork.Layers[i].Weight, 1e-9) {
+      t.Errorf("layer %v weights differ:\n%v\n%v", i,
+               mat64.Formatted(batchNetwork.Layers[i].Weight),
+               mat64.Formatted(accumulatedNetwork.Layers[i].Weight))
+    }
+  }
+}
+
+func TestTrainPartialAccumulation(t *testing.T) {
+  learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(1),
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+      BatchSize: proto.Int32(1),
+      AccumulationSteps: proto.Int32(2),
+      ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+  }
+  errorFunction := new(neural.QuadraticErrorFunction)
+  features := mat64.NewDense(1, 2, []float64{0.05, 0.10})
+  values := mat64.NewDense(1, 2, []float64{0.01, 0.99})
+  datapoints := make([]neural.Datapoint, 3)
+  for i := range datapoints {
+    datapoints[i] = neural.Datapoint{Features: features.RawRowView(0),
+                                     Values: values.RawRowView(0)}
+  }
+  trainedNetwork := CreateSimpleNetwork(t)
+  if err := neural.Train(trainedNetwork, datapoints,
+                         learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  // An update from the first two batches, then one from the last alone.
+  expectedNetwork := CreateSimpleNetwork(t)
+  for _, accumulate := range []bool{true, false, false} {
+    expectedNetwork.Forward(features)
+    expectedNetwork.Backward(values, errorFunction)
+    if accumulate {
+      expectedNetwork.Accumulate()
+    } else {
+      expectedNetwork.Update(learningConfiguration)
+    }
+  }
+  compare := func(when string) {
+    for i, layer := range expectedNetwork.Layers {
+      if !mat64.EqualApprox(layer.Weight, trainedNetwork.Layers[i].Weight,
+                            1e-12) {
+        t.Errorf("layer %v weights differ %v:\n%v\n%v", i, when,
+                 mat64.Formatted(layer.Weight),
+                 mat64.Formatted(trainedNetwork.Layers[i].Weight))
+      }
+    }
+  }
+  compare("after training")
+  // Nothing accumulated is left over for the next update.
+  for _, neuralNetwork := range []*neural.Network{trainedNetwork,
+                                                  expectedNetwork} {
+    neuralNetwork.Forward(features)
+    neuralNetwork.Backward(values, errorFunction)
+    neuralNetwork.Update(learningConfiguration)
+  }
+  compare("after a later update")
+}
+
+func TestGradient(t *testing.T) {
+  neuralNetwork := CreateSimpleNetwork(t)
+  inputs := mat64.NewDense(1, 2, []float64{0.05, 0.10})
+  neuralNetwork.Forward(inputs)
+  values := mat64.NewDense(1, 2, []float64{0.01, 0.99})
+  neuralNetwork.Backward(values, new(neural.QuadraticErrorFunction))
+  // Output layer gradient is its input times its deltas, with the deltas
+  // themselves as the bias gradient.
+  expected_gradient_1 := mat64.NewDense(
+      3, 2, []float64{0.082167041, -0.022602540, 0.082667628, -0.022740242,
+                      0.13849856, -0.03809824})
+  if !mat64.EqualApprox(
+          neuralNetwork.Layers[1].Gradient, expected_gradient_1, 0.0001) {
+    t.Errorf("gradient 1 unexpected:\n%v",
+             mat64.Formatted(neuralNetwork.Layers[1].Gradient))
+  }
+
+  // Update applies whatever is in Gradient.
+  var before mat64.Dense
+  before.Clone(neuralNetwork.Layers[1].Weight)
+  neuralNetwork.Layers[1].Gradient.Scale(0, neuralNetwork.Layers[1].Gradient)
+  neuralNetwork.Update(neural.LearningConfiguration{
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(0),
+  })
+  if !mat64.Equal(&before, neuralNetwork.Layers[1].Weight) {
+    t.Errorf("weights 1 changed with zero gradient:\n%v",
+             mat64.Formatted(neuralNetwork.Layers[1].Weight))
+  }
+}
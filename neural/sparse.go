@@ -0,0 +1,186 @@
+package neural
+
+import (
+  "fmt"
+)
+
+// A batch of examples whose features are mostly 0, in compressed sparse row
+// form.
+type Sparse struct {
+  // Number of features per example.
+  Cols int
+  // Features of row i are Values[RowStart[i]:RowStart[i + 1]], at columns
+  // Indices[RowStart[i]:RowStart[i + 1]]. Every other feature is 0.
+  RowStart []int
+  Indices []int
+  Values []float64
+}
+
+func NewSparse(cols int) *Sparse {
+  return &Sparse{Cols: cols, RowStart: []int{0}}
+}
+
+// Number of examples.
+func (self *Sparse) Rows() int {
+  return len(self.RowStart) - 1
+}
+
+// Append an example whose feature indices[i] is values[i].
+func (self *Sparse) AppendRow(indices []int, values []float64) {
+  for _, index := range indices {
+    if index < 0 || index >= self.Cols {
+      panic(fmt.Sprintf("neural: sparse index %v out of range [0, %v)", index,
+                        self.Cols))
+    }
+  }
+  self.Indices = append(self.Indices, indices...)
+  self.Values = append(self.Values, values...)
+  self.RowStart = append(self.RowStart, len(self.Indices))
+}
+
+// Remove every example, keeping storage.
+func (self *Sparse) Reset() {
+  self.RowStart = self.RowStart[:1]
+  self.Indices = self.Indices[:0]
+  self.Values = self.Values[:0]
+}
+
+// Indices and values of row i.
+func (self *Sparse) Row(i int) ([]int, []float64) {
+  start, end := self.RowStart[i], self.RowStart[i + 1]
+  return self.Indices[start:end], self.Values[start:end]
+}
+
+// Return an error unless the layer is a FLOAT64 DENSE layer without a Function
+// or tied weights, the only layers that take sparse input.
+func (self* Layer) checkSparseInput() error {
+  switch {
+  case self.Precision != Precision_FLOAT64:
+    return fmt.Errorf("neural: %v layer can't take sparse input",
+                      self.Precision)
+  case self.Function != nil:
+    return fmt.Errorf("neural: %v layer can't take sparse input", self.Type)
+  case self.Tied != nil:
+    return fmt.Errorf("neural: tied layer can't take sparse input")
+  }
+  return nil
+}
+
+// Like forward, but for sparse inputs. Only the weights of each example's
+// nonzero features are read.
+func (self* Layer) forwardSparse(input *Sparse) {
+  if err := self.checkSparseInput(); err != nil {
+    panic(err.Error())
+  }
+  if input.Cols != self.Inputs() {
+    panic(fmt.Sprintf("neural: %v sparse inputs for layer of %v inputs",
+                      input.Cols, self.Inputs()))
+  }
+  examples := input.Rows()
+  self.resetForExamples(examples)
+  self.Input = nil
+  self.sparseInput = input
+  for i := 0; i < examples; i++ {
+    row := self.Output.RawRowView(i)
+    copy(row, self.bias)
+    indices, values := input.Row(i)
+    for k, index := range indices {
+      for j, weight := range self.weight.RawRowView(index) {
+        row[j] += values[k] * weight
+      }
+    }
+  }
+  self.DActivationFunction(self.outputT, self.Derivatives)
+  self.ActivationFunction(self.Output, self.Output)
+}
+
+// Like computeGradient, but from the sparse input of the last forward pass.
+// Only rows of the gradient for the batch's features are set, so that
+// updates without regularization or constraints skip every other row.
+func (self* Layer) computeSparseGradient() {
+  if self.sparseGradient {
+    for _, index := range self.sparseRows {
+      row := self.weightGradient.RawRowView(index)
+      for j := range row {
+        row[j] = 0
+      }
+    }
+  } else {
+    self.weightGradient.Scale(0, self.weightGradient)
+  }
+  input := self.sparseInput
+  if self.sparseRowSet == nil {
+    self.sparseRowSet = make([]bool, input.Cols)
+  }
+  self.sparseRows = self.sparseRows[:0]
+  for _, index := range input.Indices {
+    if !self.sparseRowSet[index] {
+      self.sparseRowSet[index] = true
+      self.sparseRows = append(self.sparseRows, index)
+    }
+  }
+  for _, index := range self.sparseRows {
+    self.sparseRowSet[index] = false
+  }
+  self.sparseGradient = true
+  outputs, _ := self.Deltas.Dims()
+  for i := 0; i < input.Rows(); i++ {
+    indices, values := input.Row(i)
+    for k, index := range indices {
+      row := self.weightGradient.RawRowView(index)
+      for j := 0; j < outputs; j++ {
+        row[j] += values[k] * self.Deltas.At(j, i)
+      }
+    }
+  }
+  for j := 0; j < outputs; j++ {
+    bias := 0.0
+    for _, delta := range self.Deltas.RawRowView(j) {
+      bias += delta
+    }
+    self.biasGradient[j] = bias
+  }
+}
+
+// Like Forward, but for sparse inputs.
+func (self *Network) ForwardSparse(inputs *Sparse) {
+  self.Layers[0].forwardSparse(inputs)
+  for i := 1; i < len(self.Layers); i++ {
+    self.Layers[i].Forward(self.Layers[i - 1])
+  }
+}
+
+// Return the network's output for datapoint, whose features may be sparse.
+// The result is only valid until the next call.
+func (self *Network) EvaluateDatapoint(datapoint *Datapoint) []float64 {
+  if datapoint.Indices == nil {
+    return self.Evaluate(datapoint.Features)
+  }
+  input := NewSparse(self.Layers[0].Inputs())
+  input.AppendRow(datapoint.Indices, datapoint.Features)
+  self.ForwardSparse(input)
+  return self.Layers[len(self.Layers) - 1].Output.RawRowView(0)
+}
+
+// Return an error if layer can't take sparse input or any of datapoints has
+// sparse features out of range for its inputs.
+func checkSparse(datapoints []Datapoint, layer *Layer) error {
+  if err := layer.checkSparseInput(); err != nil {
+    return err
+  }
+  inputs := layer.Inputs()
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    if len(datapoint.Indices) != len(datapoint.Features) {
+      return fmt.Errorf("neural: datapoint %v has %v indices for %v features",
+                        i, len(datapoint.Indices), len(datapoint.Features))
+    }
+    for _, index := range datapoint.Indices {
+      if index < 0 || index >= inputs {
+        return fmt.Errorf("neural: datapoint %v has feature %v of %v inputs",
+                          i, index, inputs)
+      }
+    }
+  }
+  return nil
+}
@@ -0,0 +1,220 @@
+package neural_test
+
+import (
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math/rand";
+  "strings";
+  "testing"
+  "neural";
+)
+
+// Network of inputs features with a hidden layer and a single LOGISTIC
+// output.
+func createSparseNetwork(inputs int) *neural.Network {
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(int32(inputs)),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_TANH.Enum(),
+        Outputs: proto.Int32(4),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LOGISTIC.Enum(),
+        Outputs: proto.Int32(1),
+      },
+    },
+  })
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+func TestForwardSparse(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createSparseNetwork(6)
+  sparse := neural.NewSparse(6)
+  sparse.AppendRow([]int{1, 4}, []float64{0.5, -2})
+  sparse.AppendRow(nil, nil)
+  sparse.AppendRow([]int{0, 5}, []float64{1, 3})
+  dense := mat64.NewDense(3, 6, []float64{
+    0, 0.5, 0, 0, -2, 0,
+    0, 0, 0, 0, 0, 0,
+    1, 0, 0, 0, 0, 3,
+  })
+  values := mat64.NewDense(3, 1, []float64{1, 0, 1})
+  errorFunction := new(neural.CrossEntropyErrorFunction)
+
+  neuralNetwork.Forward(dense)
+  denseOutput := mat64.DenseCopyOf(neuralNetwork.Layers[1].Output)
+  neuralNetwork.Backward(values, errorFunction)
+  denseGradient := mat64.DenseCopyOf(neuralNetwork.Layers[0].Gradient)
+
+  neuralNetwork.ForwardSparse(sparse)
+  if !mat64.EqualApprox(neuralNetwork.Layers[1].Output, denseOutput, 1e-12) {
+    t.Errorf("sparse output %v, expected %v", neuralNetwork.Layers[1].Output,
+             denseOutput)
+  }
+  neuralNetwork.Backward(values, errorFunction)
+  if !mat64.EqualApprox(neuralNetwork.Layers[0].Gradient, denseGradient,
+                        1e-12) {
+    t.Errorf("sparse gradient %v, expected %v",
+             neuralNetwork.Layers[0].Gradient, denseGradient)
+  }
+
+  datapoint := neural.Datapoint{Indices: []int{0, 5},
+                                Features: []float64{1, 3}}
+  output := neuralNetwork.EvaluateDatapoint(&datapoint)[0]
+  expected := neuralNetwork.Evaluate(dense.RawRowView(2))[0]
+  if output != expected {
+    t.Errorf("evaluated sparse datapoint to %v, expected %v", output,
+             expected)
+  }
+}
+
+func TestTrainSparse(t *testing.T) {
+  rand.Seed(1)
+  const inputs = 10000
+  // Examples are positive if they contain one of the first 5 features, and
+  // otherwise have a few features at random.
+  datapoints := make([]neural.Datapoint, 400)
+  for i := range datapoints {
+    datapoint := &datapoints[i]
+    positive := i % 2 == 0
+    for index := rand.Intn(5); index < inputs; index += 500 + rand.Intn(2000) {
+      if index < 5 && !positive {
+        continue
+      }
+      datapoint.Indices = append(datapoint.Indices, index)
+      datapoint.Features = append(datapoint.Features, 1)
+    }
+    datapoint.Values = []float64{0}
+    if positive {
+      datapoint.Values[0] = 1
+    }
+  }
+  neuralNetwork := createSparseNetwork(inputs)
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(20),
+    Rate: proto.Float64(0.05),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+  if err := neural.Train(
+      neuralNetwork, datapoints, learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  if accuracy := neural.Accuracy(*neuralNetwork, datapoints);
+     accuracy < 0.85 {
+    t.Errorf("accuracy %v on sparse datapoints", accuracy)
+  }
+
+  datapoints[3].Indices = []int{inputs}
+  datapoints[3].Features = []float64{1}
+  if err := neural.Train(
+      neuralNetwork, datapoints, learningConfiguration); err == nil {
+    t.Errorf("trained on a sparse feature out of range")
+  }
+}
+
+func TestSparseUpdate(t *testing.T) {
+  // Feature 3 is in neither batch, and features 0 and 5 only in the first.
+  batches := [][][]int{{{1, 4}, {0, 5}}, {{2}, {1, 4}}}
+  values := mat64.NewDense(2, 1, []float64{1, 0})
+  errorFunction := new(neural.CrossEntropyErrorFunction)
+  for _, test := range []struct {
+    decay, clipValue float64
+    nonNegative bool
+  }{
+    {0, 0, false},
+    {0, 0.01, false},
+    {0.1, 0, false},
+    {0, 0, true},
+  } {
+    rand.Seed(1)
+    sparseNetwork := createSparseNetwork(6)
+    rand.Seed(1)
+    denseNetwork := createSparseNetwork(6)
+    sparseNetwork.Layers[0].NonNegative = test.nonNegative
+    denseNetwork.Layers[0].NonNegative = test.nonNegative
+    initial := mat64.DenseCopyOf(sparseNetwork.Layers[0].Weight)
+    learningConfiguration := neural.LearningConfiguration{
+      Epochs: proto.Int32(1),
+      Rate: proto.Float64(0.5),
+      Decay: proto.Float64(test.decay),
+      BatchSize: proto.Int32(10),
+      ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+      ClipValue: proto.Float64(test.clipValue),
+    }
+    for _, batch := range batches {
+      sparse := neural.NewSparse(6)
+      dense := mat64.NewDense(len(batch), 6, nil)
+      for i, indices := range batch {
+        features := make([]float64, len(indices))
+        for k, index := range indices {
+          features[k] = float64(k + 1)
+          dense.Set(i, index, features[k])
+        }
+        sparse.AppendRow(indices, features)
+      }
+      sparseNetwork.ForwardSparse(sparse)
+      sparseNetwork.Backward(values, errorFunction)
+      sparseNetwork.Update(learningConfiguration)
+      denseNetwork.Forward(dense)
+      denseNetwork.Backward(values, errorFunction)
+      denseNetwork.Update(learningConfiguration)
+    }
+    for i := range sparseNetwork.Layers {
+      if !mat64.EqualApprox(sparseNetwork.Layers[i].Weight,
+                            denseNetwork.Layers[i].Weight, 1e-12) {
+        t.Errorf("%+v: sparse updates of layer %v gave %v, expected %v", test,
+                 i, sparseNetwork.Layers[i].Weight,
+                 denseNetwork.Layers[i].Weight)
+      }
+    }
+    weight := sparseNetwork.Layers[0].Weight
+    changed := weight.At(3, 0) != initial.At(3, 0)
+    if changed != (test.decay > 0 || test.nonNegative && initial.At(3, 0) < 0) {
+      t.Errorf("%+v: weight of feature 3 went from %v to %v", test,
+               initial.At(3, 0), weight.At(3, 0))
+    }
+  }
+}
+
+func TestTrainSparseLayers(t *testing.T) {
+  datapoints := []neural.Datapoint{
+    {Indices: []int{1}, Features: []float64{1}, Values: []float64{1}},
+  }
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(1),
+    Rate: proto.Float64(0.1),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(1),
+    ErrorName: neural.ErrorName_CROSS_ENTROPY.Enum(),
+  }
+  autodiffNetwork := createSparseNetwork(6)
+  autodiffNetwork.Layers[0].Function =
+      neural.DenseFunction(neural.ActivationName_TANH)
+  float32Network := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(6),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_TANH.Enum(),
+        Outputs: proto.Int32(4),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LOGISTIC.Enum(),
+        Outputs: proto.Int32(1),
+      },
+    },
+    Precision: neural.Precision_FLOAT32.Enum(),
+  })
+  for _, neuralNetwork := range []*neural.Network{autodiffNetwork,
+                                                   float32Network} {
+    err := neural.Train(neuralNetwork, datapoints, learningConfiguration)
+    if err == nil || !strings.Contains(err.Error(), "sparse input") {
+      t.Errorf("error %v training a %v network on sparse datapoints", err,
+               neuralNetwork.Layers[0].Precision)
+    }
+  }
+}
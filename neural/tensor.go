@@ -0,0 +1,186 @@
+package neural
+
+import (
+  "fmt";
+  "github.com/gonum/matrix/mat64"
+)
+
+// N-dimensional array of float64s, for shaped examples entering and leaving a
+// network: Datapoint.FeatureTensor, Network.ForwardTensor and OutputTensor.
+// Their first dimension indexes examples and the rest are the shape of each
+// example. Inside a network, layers still pass examples x size matrices, with
+// InputShape and OutputShape giving the shape each row holds.
+type Tensor struct {
+  Shape []int
+  // Distance in Data between consecutive elements of each dimension.
+  Stride []int
+  Data []float64
+}
+
+// Create a row-major tensor of shape backed by data, or by zeros if data is
+// nil.
+func NewTensor(shape []int, data []float64) *Tensor {
+  size := product(shape)
+  if data == nil {
+    data = make([]float64, size)
+  }
+  if len(data) != size {
+    panic(fmt.Sprintf("neural: %v elements for tensor of shape %v",
+                      len(data), shape))
+  }
+  tensor := &Tensor{Shape: append([]int(nil), shape...), Data: data}
+  tensor.Stride = make([]int, len(shape))
+  stride := 1
+  for i := len(shape) - 1; i >= 0; i-- {
+    tensor.Stride[i] = stride
+    stride *= shape[i]
+  }
+  return tensor
+}
+
+// Copy of a matrix as a rows x cols tensor.
+func TensorFromMatrix(m *mat64.Dense) *Tensor {
+  rows, cols := m.Dims()
+  tensor := NewTensor([]int{rows, cols}, nil)
+  for i := 0; i < rows; i++ {
+    copy(tensor.Data[i * cols:], m.RawRowView(i))
+  }
+  return tensor
+}
+
+// Number of elements.
+func (self *Tensor) Size() int {
+  return product(self.Shape)
+}
+
+func (self *Tensor) At(index ...int) float64 {
+  return self.Data[self.offset(index)]
+}
+
+func (self *Tensor) Set(v float64, index ...int) {
+  self.Data[self.offset(index)] = v
+}
+
+func (self *Tensor) offset(index []int) int {
+  if len(index) != len(self.Shape) {
+    panic(fmt.Sprintf("neural: index %v for tensor of shape %v", index,
+                      self.Shape))
+  }
+  offset := 0
+  for i, j := range index {
+    if j < 0 || j >= self.Shape[i] {
+      panic(fmt.Sprintf("neural: index %v out of range of shape %v", index,
+                        self.Shape))
+    }
+    offset += j * self.Stride[i]
+  }
+  return offset
+}
+
+// Whether elements are laid out in row-major order without gaps.
+func (self *Tensor) Contiguous() bool {
+  stride := 1
+  for i := len(self.Shape) - 1; i >= 0; i-- {
+    if self.Shape[i] != 1 && self.Stride[i] != stride {
+      return false
+    }
+    stride *= self.Shape[i]
+  }
+  return true
+}
+
+// Copy into a new contiguous tensor.
+func (self *Tensor) Clone() *Tensor {
+  clone := NewTensor(self.Shape, nil)
+  index := make([]int, len(self.Shape))
+  for i := range clone.Data {
+    clone.Data[i] = self.Data[self.offset(index)]
+    // Advance index in row-major order.
+    for d := len(index) - 1; d >= 0; d-- {
+      index[d]++
+      if index[d] < self.Shape[d] {
+        break
+      }
+      index[d] = 0
+    }
+  }
+  return clone
+}
+
+// The same elements with a different shape, sharing data if the tensor is
+// contiguous. One dimension may be -1 to infer it from the others.
+func (self *Tensor) Reshape(shape ...int) *Tensor {
+  shape = append([]int(nil), shape...)
+  inferred, known := -1, 1
+  for i, size := range shape {
+    if size == -1 {
+      inferred = i
+    } else {
+      known *= size
+    }
+  }
+  if inferred >= 0 && known > 0 {
+    shape[inferred] = self.Size() / known
+  }
+  if product(shape) != self.Size() {
+    panic(fmt.Sprintf("neural: can't reshape %v to %v", self.Shape, shape))
+  }
+  source := self
+  if !self.Contiguous() {
+    source = self.Clone()
+  }
+  return NewTensor(shape, source.Data[:source.Size()])
+}
+
+// View with dimensions reordered so that dimension i is this tensor's
+// dimension axes[i], sharing data.
+func (self *Tensor) Permute(axes ...int) *Tensor {
+  if len(axes) != len(self.Shape) {
+    panic(fmt.Sprintf("neural: can't permute %v by %v", self.Shape, axes))
+  }
+  permuted := &Tensor{Data: self.Data}
+  for _, axis := range axes {
+    permuted.Shape = append(permuted.Shape, self.Shape[axis])
+    permuted.Stride = append(permuted.Stride, self.Stride[axis])
+  }
+  return permuted
+}
+
+// View of index i of the first dimension, sharing data.
+func (self *Tensor) Slice(i int) *Tensor {
+  if i < 0 || i >= self.Shape[0] {
+    panic(fmt.Sprintf("neural: slice %v out of range of shape %v", i,
+                      self.Shape))
+  }
+  return &Tensor{
+    Shape: self.Shape[1:],
+    Stride: self.Stride[1:],
+    Data: self.Data[i * self.Stride[0]:],
+  }
+}
+
+// Matrix of the first dimension by the product of the others, sharing data if
+// the tensor is contiguous.
+func (self *Tensor) Matrix() *mat64.Dense {
+  source := self
+  if !self.Contiguous() {
+    source = self.Clone()
+  }
+  rows := 1
+  if len(self.Shape) > 0 {
+    rows = self.Shape[0]
+  }
+  cols := 0
+  if rows > 0 {
+    cols = self.Size() / rows
+  }
+  return mat64.NewDense(rows, cols, source.Data[:self.Size()])
+}
+
+func product(shape []int) int {
+  size := 1
+  for _, n := range shape {
+    size *= n
+  }
+  return size
+}
@@ -0,0 +1,82 @@
+package neural_test
+
+import (
+  "reflect";
+  "testing"
+  "neural";
+)
+
+func TestTensor(t *testing.T) {
+  tensor := neural.NewTensor(
+      []int{2, 3, 2}, []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})
+  if v := tensor.At(1, 2, 0); v != 10 {
+    t.Errorf("At(1, 2, 0) %v unexpected", v)
+  }
+  tensor.Set(-1, 0, 1, 1)
+  if tensor.Data[3] != -1 {
+    t.Errorf("Set didn't change data: %v", tensor.Data)
+  }
+
+  permuted := tensor.Permute(0, 2, 1)
+  if !reflect.DeepEqual(permuted.Shape, []int{2, 2, 3}) ||
+     permuted.At(1, 0, 2) != tensor.At(1, 2, 0) {
+    t.Errorf("permuted tensor %v unexpected", permuted)
+  }
+  if permuted.Contiguous() {
+    t.Errorf("permuted tensor is contiguous")
+  }
+  reshaped := permuted.Reshape(2, -1)
+  expected := []float64{0, 2, 4, 1, -1, 5, 6, 8, 10, 7, 9, 11}
+  if !reflect.DeepEqual(reshaped.Shape, []int{2, 6}) ||
+     !reflect.DeepEqual(reshaped.Data, expected) {
+    t.Errorf("reshaped tensor %v unexpected", reshaped)
+  }
+
+  slice := tensor.Slice(1)
+  if !reflect.DeepEqual(slice.Shape, []int{3, 2}) || slice.At(0, 1) != 7 {
+    t.Errorf("slice %v unexpected", slice)
+  }
+  m := slice.Matrix()
+  if rows, cols := m.Dims(); rows != 3 || cols != 2 || m.At(2, 1) != 11 {
+    t.Errorf("matrix of slice unexpected")
+  }
+  // Contiguous tensors share data with their matrices.
+  m.Set(0, 0, 42)
+  if tensor.At(1, 0, 0) != 42 {
+    t.Errorf("matrix doesn't share data with tensor")
+  }
+}
+
+func TestNetworkTensors(t *testing.T) {
+  neuralNetwork := new(neural.Network)
+  if err := neuralNetwork.Deserialize([]byte(
+      "{\"inputs\":4,\"input_shape\":[2,2],\"layer\":[{\"name\":0," +
+      "\"outputs\":6,\"output_shape\":[3,2]}]}")); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(neuralNetwork.Layers[0].InputShape, []int{2, 2}) {
+    t.Errorf("input shape %v unexpected", neuralNetwork.Layers[0].InputShape)
+  }
+  datapoints := []neural.Datapoint{
+      {Features: []float64{1, 2, 3, 4}, Shape: []int{2, 2}},
+      {Features: []float64{5, 6, 7, 8}, Shape: []int{2, 2}},
+  }
+  neuralNetwork.ForwardTensor(neural.FeatureTensor(datapoints))
+  output := neuralNetwork.OutputTensor()
+  if !reflect.DeepEqual(output.Shape, []int{2, 3, 2}) {
+    t.Errorf("output shape %v unexpected", output.Shape)
+  }
+
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(deserialized.InputShape, []int{2, 2}) ||
+     !reflect.DeepEqual(deserialized.Layers[0].OutputShape, []int{3, 2}) {
+    t.Errorf("shapes changed by serialization")
+  }
+  if err := deserialized.Deserialize([]byte(
+      "{\"inputs\":4,\"input_shape\":[3,2],\"layer\":[]}")); err == nil {
+    t.Errorf("mismatched input shape accepted")
+  }
+}
@@ -0,0 +1,127 @@
+package neural
+
+import (
+  "fmt";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "neural/autodiff"
+)
+
+// Create a GAUSSIAN_SAMPLE layer drawing latent values from inputs holding
+// latent means followed by latent log variances, with KL divergence weighted
+// by klWeight.
+func NewSampleLayer(latent int, klWeight float64) *Layer {
+  layer := NewLayer(ActivationName_LINEAR, 0, 1, nil)
+  layer.Type = LayerType_GAUSSIAN_SAMPLE
+  layer.KLWeight = klWeight
+  layer.InputShape = []int{2 * latent}
+  layer.OutputShape = []int{latent}
+  layer.Function = func(
+      tape *autodiff.Tape,
+      input, weight, bias *autodiff.Variable) *autodiff.Variable {
+    examples, _ := input.Value.Dims()
+    noise := mat64.NewDense(examples, latent, nil)
+    noise.Apply(func(i, j int, v float64) float64 {
+      return rand.NormFloat64()
+    }, noise)
+    mean := autodiff.Slice(input, 0, examples, 0, latent)
+    logVariance := autodiff.Slice(input, 0, examples, latent, 2 * latent)
+    return autodiff.Add(mean, autodiff.MulElem(
+        autodiff.Exp(autodiff.Scale(0.5, logVariance)), tape.Variable(noise)))
+  }
+  return layer
+}
+
+// Total KL divergence, weighted by KLWeight, of each example's Gaussian from
+// the standard normal in the last forward pass of a GAUSSIAN_SAMPLE layer,
+// otherwise 0.
+func (self* Layer) Divergence() float64 {
+  if self.Type != LayerType_GAUSSIAN_SAMPLE {
+    return 0
+  }
+  examples, inputs := self.Input.Dims()
+  latent := inputs / 2
+  divergence := 0.0
+  for i := 0; i < examples; i++ {
+    row := self.Input.RawRowView(i)
+    for j, mean := range row[:latent] {
+      logVariance := row[latent + j]
+      divergence += 0.5 * (math.Exp(logVariance) + mean * mean - 1 -
+                           logVariance)
+    }
+  }
+  return self.KLWeight * divergence
+}
+
+// Add the gradient of Divergence, with each example's term scaled by its
+// weight in exampleWeights, to the gradient with respect to the input.
+func (self* Layer) backwardDivergence() {
+  gradient := self.tape.input.Gradient
+  examples, inputs := self.Input.Dims()
+  latent := inputs / 2
+  for i := 0; i < examples; i++ {
+    weight := self.KLWeight
+    if self.exampleWeights != nil {
+      weight *= self.exampleWeights[i]
+    }
+    row, gradientRow := self.Input.RawRowView(i), gradient.RawRowView(i)
+    for j, mean := range row[:latent] {
+      gradientRow[j] += weight * mean
+      gradientRow[latent + j] += weight * 0.5 * (math.Exp(row[latent + j]) - 1)
+    }
+  }
+}
+
+// Index of the network's GAUSSIAN_SAMPLE layer.
+func (self *Network) sampleLayer() int {
+  for i, layer := range self.Layers {
+    if layer.Type == LayerType_GAUSSIAN_SAMPLE {
+      return i
+    }
+  }
+  panic(fmt.Sprintf("neural: network has no %v layer",
+                    LayerType_GAUSSIAN_SAMPLE))
+}
+
+// Return the mean and log variance of each latent dimension of a variational
+// autoencoder's encoding of features.
+func (self *Network) Encode(features []float64) ([]float64, []float64) {
+  sample := self.sampleLayer()
+  input := mat64.NewDense(1, len(features), features)
+  if sample > 0 {
+    self.Layers[0].forward(input)
+    for i := 1; i < sample; i++ {
+      self.Layers[i].Forward(self.Layers[i - 1])
+    }
+    input = self.Layers[sample - 1].Output
+  }
+  row := input.RawRowView(0)
+  latent := len(row) / 2
+  return append([]float64(nil), row[:latent]...),
+         append([]float64(nil), row[latent:]...)
+}
+
+// Return a variational autoencoder's output for latent values.
+func (self *Network) Decode(latent []float64) []float64 {
+  sample := self.sampleLayer()
+  if sample == len(self.Layers) - 1 {
+    return append([]float64(nil), latent...)
+  }
+  self.Layers[sample + 1].forward(mat64.NewDense(1, len(latent), latent))
+  for i := sample + 2; i < len(self.Layers); i++ {
+    self.Layers[i].Forward(self.Layers[i - 1])
+  }
+  return append([]float64(nil),
+                self.Layers[len(self.Layers) - 1].Output.RawRowView(0)...)
+}
+
+// Return a variational autoencoder's output for latent values drawn from the
+// standard normal distribution, such as a synthetic example.
+func (self *Network) Sample() []float64 {
+  latent := make([]float64, self.Layers[self.sampleLayer()].Outputs())
+  for i := range latent {
+    latent[i] = rand.NormFloat64()
+  }
+  return self.Decode(latent)
+}
@@ -0,0 +1,173 @@
+package neural_test
+
+import (
+  "encoding/json";
+  "github.com/golang/protobuf/proto";
+  "github.com/gonum/matrix/mat64";
+  "math";
+  "math/rand";
+  "testing"
+  "neural";
+)
+
+// Variational autoencoder of 6 features with 2 latent dimensions.
+func createVariationalAutoencoder() *neural.Network {
+  neuralNetwork := neural.NewNetwork(neural.NetworkConfiguration{
+    Inputs: proto.Int32(6),
+    Layer: []*neural.LayerConfiguration{
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_TANH.Enum(),
+        Outputs: proto.Int32(8),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LINEAR.Enum(),
+        Outputs: proto.Int32(4),
+      },
+      &neural.LayerConfiguration{
+        Type: neural.LayerType_GAUSSIAN_SAMPLE.Enum(),
+        KlWeight: proto.Float64(0.5),
+      },
+      &neural.LayerConfiguration{
+        Name: neural.ActivationName_LINEAR.Enum(),
+        Outputs: proto.Int32(6),
+      },
+    },
+  })
+  neuralNetwork.RandomizeSynapses()
+  return neuralNetwork
+}
+
+func TestVariationalAutoencoderGradient(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createVariationalAutoencoder()
+  datapoints := neural.AutoencoderDatapoints(createLowRankDatapoints(4))
+  features := mat64.NewDense(4, 6, nil)
+  values := mat64.NewDense(4, 6, nil)
+  for i, datapoint := range datapoints {
+    features.SetRow(i, datapoint.Features)
+    values.SetRow(i, datapoint.Values)
+  }
+  errorFunction := new(neural.QuadraticErrorFunction)
+  sample := neural.NewSampleLayer(2, 0.5)
+  // Total cost, each example's reconstruction error and KL divergence scaled
+  // by its weight. Reseeds before each forward pass so that every pass draws
+  // the same noise.
+  cost := func(weights []float64) float64 {
+    rand.Seed(2)
+    neuralNetwork.Forward(features)
+    output := neuralNetwork.Layers[len(neuralNetwork.Layers) - 1].Output
+    input := neuralNetwork.Layers[2].Input
+    cost := 0.0
+    for k, weight := range weights {
+      sample.Input = mat64.NewDense(1, 4, input.RawRowView(k))
+      cost += weight * (errorFunction.Cost(
+          mat64.NewDense(1, 6, values.RawRowView(k)),
+          mat64.NewDense(1, 6, output.RawRowView(k))) + sample.Divergence())
+    }
+    return cost
+  }
+  const step = 1e-5
+  for _, weights := range [][]float64{{1, 1, 1, 1}, {1, 2, 0.5, 3}} {
+    cost(weights)
+    neuralNetwork.BackwardWeighted(values, weights, errorFunction)
+    for l, layer := range neuralNetwork.Layers {
+      weight, gradients := layer.Weights(), layer.Gradients()
+      rows, cols := weight.Dims()
+      for i := 0; i < rows - 1; i++ {
+        for j := 0; j < cols; j++ {
+          w := weight.At(i, j)
+          weight.Set(i, j, w + step)
+          costPlus := cost(weights)
+          weight.Set(i, j, w - step)
+          costMinus := cost(weights)
+          weight.Set(i, j, w)
+          numerical := (costPlus - costMinus) / (2 * step)
+          analytic := gradients.At(i, j)
+          if math.Abs(analytic - numerical) > 1e-4 * math.Max(
+              1, math.Abs(analytic) + math.Abs(numerical)) {
+            t.Fatalf("weights %v: layer %v weight (%v, %v) gradient %v, " +
+                     "expected %v", weights, l, i, j, analytic, numerical)
+          }
+        }
+      }
+    }
+  }
+}
+
+func TestDivergence(t *testing.T) {
+  layer := neural.NewSampleLayer(1, 2)
+  layer.Input = mat64.NewDense(2, 2, []float64{0, 0, 1, math.Log(2)})
+  // KL(N(1, 2) || N(0, 1)) = 0.5 * (2 + 1 - 1 - log(2)).
+  expected := 2 * 0.5 * (2 - math.Log(2))
+  if divergence := layer.Divergence(); math.Abs(divergence - expected) > 1e-9 {
+    t.Errorf("divergence %v, expected %v", divergence, expected)
+  }
+  if divergence := neural.NewLayer(
+      neural.ActivationName_LINEAR, 2, 1, nil).Divergence(); divergence != 0 {
+    t.Errorf("dense layer divergence %v", divergence)
+  }
+}
+
+func TestTrainVariationalAutoencoder(t *testing.T) {
+  rand.Seed(1)
+  neuralNetwork := createVariationalAutoencoder()
+  datapoints := createLowRankDatapoints(200)
+  learningConfiguration := neural.LearningConfiguration{
+    Epochs: proto.Int32(50),
+    Rate: proto.Float64(0.001),
+    Decay: proto.Float64(0),
+    BatchSize: proto.Int32(10),
+    ErrorName: neural.ErrorName_QUADRATIC.Enum(),
+    Autoencoder: proto.Bool(true),
+  }
+  initialLoss, err := neural.Loss(*neuralNetwork, datapoints,
+                                  learningConfiguration)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := neural.Train(
+      neuralNetwork, datapoints, learningConfiguration); err != nil {
+    t.Fatal(err)
+  }
+  loss, err := neural.Loss(*neuralNetwork, datapoints, learningConfiguration)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if loss > 0.1 * initialLoss {
+    t.Errorf("loss %v didn't improve enough on %v", loss, initialLoss)
+  }
+
+  mean, logVariance := neuralNetwork.Encode(datapoints[0].Features)
+  if len(mean) != 2 || len(logVariance) != 2 {
+    t.Errorf("encoded to mean %v and log variance %v, expected 2 each", mean,
+             logVariance)
+  }
+  if output := neuralNetwork.Decode(mean); len(output) != 6 {
+    t.Errorf("decoded %v, expected 6 outputs", output)
+  }
+  if sample := neuralNetwork.Sample(); len(sample) != 6 {
+    t.Errorf("sampled %v, expected 6 outputs", sample)
+  }
+}
+
+func TestSerializeVariationalAutoencoder(t *testing.T) {
+  neuralNetwork := createVariationalAutoencoder()
+  deserialized := new(neural.Network)
+  if err := deserialized.Deserialize(neuralNetwork.Serialize()); err != nil {
+    t.Fatal(err)
+  }
+  layer := deserialized.Layers[2]
+  if layer.Type != neural.LayerType_GAUSSIAN_SAMPLE || layer.KLWeight != 0.5 ||
+     layer.Outputs() != 2 {
+    t.Errorf("deserialized sample layer %v with KL weight %v and %v outputs",
+             layer.Type, layer.KLWeight, layer.Outputs())
+  }
+
+  var networkConfiguration neural.NetworkConfiguration
+  json.Unmarshal(neuralNetwork.Serialize(), &networkConfiguration)
+  networkConfiguration.Layer[1].Outputs = proto.Int32(3)
+  bytes, _ := json.Marshal(networkConfiguration)
+  if err := deserialized.Deserialize(bytes); err == nil {
+    t.Errorf("deserialized sample layer with an odd number of inputs")
+  }
+}